@@ -26,7 +26,6 @@ import (
 	commands "github.com/ipfs/kubo/core/commands"
 	"github.com/ipfs/kubo/core/coreapi"
 	corehttp "github.com/ipfs/kubo/core/corehttp"
-	corerepo "github.com/ipfs/kubo/core/corerepo"
 	libp2p "github.com/ipfs/kubo/core/node/libp2p"
 	nodeMount "github.com/ipfs/kubo/fuse/node"
 	fsrepo "github.com/ipfs/kubo/repo/fsrepo"
@@ -975,7 +974,9 @@ func maybeRunGC(req *cmds.Request, node *core.IpfsNode) (<-chan error, error) {
 
 	errc := make(chan error)
 	go func() {
-		errc <- corerepo.PeriodicGC(req.Context, node)
+		//////////////////// Falcon ////////////////////
+		errc <- falconnode.PeriodicGC(req.Context, node)
+		//////////////////// Falcon ////////////////////
 		close(errc)
 	}()
 	return errc, nil
@@ -23,6 +23,7 @@ var localCommands = map[string]*cmds.Command{
 	"daemon":   daemonCmd,
 	"init":     initCmd,
 	"commands": commandsClientCmd,
+	"pins":     pinsCmd,
 }
 
 func init() {
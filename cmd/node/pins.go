@@ -0,0 +1,245 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	bs "github.com/ipfs/boxo/blockservice"
+	"github.com/ipfs/boxo/blockstore"
+	"github.com/ipfs/boxo/exchange/offline"
+	"github.com/ipfs/boxo/ipld/merkledag"
+	cmds "github.com/ipfs/go-ipfs-cmds"
+	oldcmds "github.com/ipfs/kubo/commands"
+	"github.com/ipfs/kubo/repo"
+	fsrepo "github.com/ipfs/kubo/repo/fsrepo"
+
+	falconnode "github.com/photon-storage/falcon/node"
+	"github.com/photon-storage/falcon/node/com"
+	"github.com/photon-storage/falcon/node/handlers"
+)
+
+const (
+	pinsBlocksOptionName = "blocks"
+	pinsBackupOptionName = "backup"
+)
+
+var pinsCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Manage the falcon pinner index.",
+		ShortDescription: `
+Exports recursive and direct pin counts with metadata to a CAR file, and
+restores them on another node. Migrates pins of a repo previously run with
+stock Kubo. The daemon must not be running.
+`,
+	},
+	Subcommands: map[string]*cmds.Command{
+		"export":  pinsExportCmd,
+		"import":  pinsImportCmd,
+		"migrate": pinsMigrateCmd,
+	},
+	NoRemote: true,
+}
+
+var pinsExportCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Export pin counts to a CAR file.",
+		ShortDescription: `
+Writes the pinner index as a versioned JSON block, which is the root of a
+CARv1 file. With --blocks, blocks of pinned DAGs are bundled in the file.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("path", true, false, "Path of the export file."),
+	},
+	Options: []cmds.Option{
+		cmds.BoolOption(pinsBlocksOptionName, "Bundle blocks of pinned DAGs."),
+	},
+	NoRemote: true,
+	Type:     handlers.PinExportResult{},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		withBlocks, _ := req.Options[pinsBlocksOptionName].(bool)
+
+		r, pinner, bstore, err := openPinnerRepo(env)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+
+		f, err := os.Create(req.Arguments[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		out, err := handlers.ExportPins(
+			req.Context,
+			f,
+			pinner,
+			bstore,
+			withBlocks,
+		)
+		if err != nil {
+			return err
+		}
+		if err := f.Sync(); err != nil {
+			return err
+		}
+		return res.Emit(out)
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *handlers.PinExportResult) error {
+			_, err := fmt.Fprintf(
+				w,
+				"exported %d recursive, %d direct pins and %d blocks\n",
+				out.Recursive,
+				out.Direct,
+				out.NumBlocks,
+			)
+			return err
+		}),
+	},
+}
+
+var pinsImportCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Import pin counts from a CAR file.",
+		ShortDescription: `
+Writes bundled blocks to the repo and verifies each pinned DAG is complete
+before restoring its count. Counts are raised to the exported ones, so
+importing twice is safe. Pins with missing blocks are reported and skipped.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("path", true, false, "Path of the export file."),
+	},
+	NoRemote: true,
+	Type:     handlers.PinImportResult{},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		r, pinner, bstore, err := openPinnerRepo(env)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+
+		f, err := os.Open(req.Arguments[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		// Blocks cannot be fetched without the daemon.
+		out, err := handlers.ImportPins(
+			req.Context,
+			f,
+			pinner,
+			bstore,
+			handlers.NewPinVerifier(bstore, nil, pinner),
+			false,
+		)
+		if err != nil {
+			return err
+		}
+		return res.Emit(out)
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *handlers.PinImportResult) error {
+			for _, v := range out.Failed {
+				if _, err := fmt.Fprintf(
+					w,
+					"failed %s: %d missing, %d corrupt blocks %s\n",
+					v.Cid,
+					len(v.Missing),
+					len(v.Corrupt),
+					v.Message,
+				); err != nil {
+					return err
+				}
+			}
+			_, err := fmt.Fprintf(
+				w,
+				"imported %d blocks, restored %d recursive, %d direct pins\n",
+				out.NumBlocks,
+				out.Recursive,
+				out.Direct,
+			)
+			return err
+		}),
+	},
+}
+
+var pinsMigrateCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Migrate legacy Kubo pins into the rc pinner.",
+		ShortDescription: `
+Converts each recursive and direct pin stored by stock Kubo into an rc
+count of 1. Pins already counted keep their count and pins migrated by a
+previous run are skipped, so an interrupted migration can be run again.
+The legacy pinset is left untouched. The daemon also migrates once at
+start unless disabled in the falcon config.
+`,
+	},
+	Options: []cmds.Option{
+		cmds.StringOption(pinsBackupOptionName, "Export the rc pinner index to the given path before migrating."),
+	},
+	NoRemote: true,
+	Type:     com.PinMigrationResult{},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		backup, _ := req.Options[pinsBackupOptionName].(string)
+
+		r, pinner, bstore, err := openPinnerRepo(env)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+
+		out, err := falconnode.MigrateLegacyPins(
+			req.Context,
+			r.Datastore(),
+			bstore,
+			pinner,
+			backup,
+		)
+		if err != nil {
+			return err
+		}
+		return res.Emit(out)
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *com.PinMigrationResult) error {
+			_, err := fmt.Fprintf(
+				w,
+				"migrated %d recursive, %d direct pins, skipped %d\n",
+				out.Recursive,
+				out.Direct,
+				out.Skipped,
+			)
+			return err
+		}),
+	},
+}
+
+// openPinnerRepo opens the repo with the rc pinner and an offline
+// blockstore.
+func openPinnerRepo(env cmds.Environment) (
+	repo.Repo,
+	*com.WrappedPinner,
+	blockstore.Blockstore,
+	error,
+) {
+	cctx, ok := env.(*oldcmds.Context)
+	if !ok {
+		return nil, nil, nil, errors.New("invalid command environment")
+	}
+
+	r, err := fsrepo.Open(cctx.ConfigRoot)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	bstore := blockstore.NewBlockstore(r.Datastore())
+	dserv := merkledag.NewDAGService(bs.New(bstore, offline.Exchange(bstore)))
+	pinner := com.GetRcPinner(com.RcPinning(bstore, dserv, r))
+	return r, pinner, bstore, nil
+}
@@ -10,15 +10,27 @@ import (
 	"strings"
 	"time"
 
+	"github.com/google/uuid"
 	lru "github.com/hashicorp/golang-lru/v2"
 	libp2pcrypto "github.com/libp2p/go-libp2p/core/crypto"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/atomic"
 
 	"github.com/photon-storage/go-common/log"
 	"github.com/photon-storage/go-common/metrics"
 	"github.com/photon-storage/go-gw3/common/auth"
 	"github.com/photon-storage/go-gw3/common/http"
+	rcpinner "github.com/photon-storage/go-rc-pinner"
 
 	"github.com/photon-storage/falcon/node/config"
+	"github.com/photon-storage/falcon/node/tracing"
+)
+
+const (
+	headerRequestID = "X-Request-Id"
 )
 
 var (
@@ -85,15 +97,53 @@ func (h *authHandler) wrap(next gohttp.Handler) gohttp.Handler {
 
 		uri := auth.CanonicalizeURI(r.URL.Path)
 
-		// Set global request timeout.
-		ctx, cancel := context.WithTimeout(r.Context(), getUriTimeout(uri))
+		// The request timeout is enforced by the watchdog so it could
+		// be extended by the signed override after args are decoded.
+		ctx, cancel := context.WithCancel(r.Context())
 		defer cancel()
+		p2pIngr := atomic.NewUint64(0)
+		ctx = rcpinner.WithDagSize(ctx, p2pIngr)
+
+		// Continue the upstream trace if any. The request headers are
+		// reset after authentication.
+		ctx = otel.GetTextMapPropagator().Extract(
+			ctx,
+			propagation.HeaderCarrier(r.Header),
+		)
+		ctx, span := tracing.Span(
+			ctx,
+			"Auth",
+			"Request",
+			trace.WithAttributes(
+				attribute.String("uri", uri),
+				attribute.String("method", r.Method),
+			),
+		)
+		defer span.End()
+
+		// Tag the request with an ID which is returned to the client
+		// and carried in usage logs.
+		reqID := uuid.New().String()
+		ctx = WithRequestID(ctx, reqID)
+		w.Header().Set(headerRequestID, reqID)
+		span.SetAttributes(attribute.String("request_id", reqID))
 		r = r.WithContext(ctx)
 
 		metrics.CounterInc("request_call_total")
-		sw := newContentSentry(ctx, w)
+		rec := newRouteRecorder(w)
+		wd := newWatchdog(rec, cancel, p2pIngr, getUriTimeout(uri))
+		go wd.run(ctx)
+		sw := newContentSentry(ctx, wd)
+		route := labelOther
+		subdomain := false
 		defer func() {
 			sw.flush()
+			wd.finish()
+			rec.observe(route, subdomain, r.Method)
+			span.SetAttributes(attribute.Int("status", rec.statusCode))
+			if reason := wd.abortReason(); reason != "" {
+				span.SetAttributes(attribute.String("abort", reason))
+			}
 
 			flagged := sw.getFlaggedRuleName()
 			if flagged != "" {
@@ -121,6 +171,9 @@ func (h *authHandler) wrap(next gohttp.Handler) gohttp.Handler {
 			uri = "/ipfs"
 		} else if strings.HasPrefix(uri, "/ipns/") {
 			uri = "/ipns"
+		} else if strings.HasPrefix(uri, apiPrefix+psaPrefix+"/pins/") {
+			// Pinning Service API carries request ID in path.
+			uri = apiPrefix + psaPrefix + "/pins"
 		} else if !strings.HasPrefix(uri, "/api/v0/") {
 			// Check subdomain namespace
 			// In our case, always honor the real hostname.
@@ -128,6 +181,7 @@ func (h *authHandler) wrap(next gohttp.Handler) gohttp.Handler {
 			// from Args, which is used by starbase to control if subdomain
 			// is enabled.
 			if _, _, ns, _, ok := h.gws.knownSubdomainDetails(r.Host); ok {
+				subdomain = true
 				if ns == "ipfs" {
 					uri = "/ipfs"
 				} else if ns == "ipns" {
@@ -144,8 +198,12 @@ func (h *authHandler) wrap(next gohttp.Handler) gohttp.Handler {
 			)
 			return
 		}
+		route = uri
 		if r.Method != gohttp.MethodOptions && h.pk != nil {
-			if err := auth.VerifyRequest(r, h.pk); err != nil {
+			_, vspan := tracing.Span(ctx, "Auth", "Verify")
+			err := auth.VerifyRequest(r, h.pk)
+			tracing.EndSpan(vspan, err)
+			if err != nil {
 				if err == auth.ErrReqSigMissing && h.redirectOnFailure {
 					redirectToStarbase(sw, r)
 				} else {
@@ -235,6 +293,18 @@ func (h *authHandler) wrap(next gohttp.Handler) gohttp.Handler {
 		}
 		r.URL.RawQuery = query.Encode()
 
+		timeout, err := requestTimeout(uri, GetArgsFromCtx(r.Context()))
+		if err != nil {
+			log.Debug("Error parsing request timeout", "error", err)
+			gohttp.Error(
+				sw,
+				gohttp.StatusText(gohttp.StatusBadRequest),
+				gohttp.StatusBadRequest,
+			)
+			return
+		}
+		wd.setTimeout(timeout)
+
 		next.ServeHTTP(sw, r)
 	})
 }
@@ -1,14 +1,19 @@
 package node
 
 import (
+	"bufio"
 	"fmt"
+	"io"
 	gohttp "net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/photon-storage/go-common/testing/require"
 	"github.com/photon-storage/go-gw3/common/auth"
+	"github.com/photon-storage/go-gw3/common/crypto"
 	"github.com/photon-storage/go-gw3/common/http"
 
 	"github.com/photon-storage/falcon/node/config"
@@ -108,3 +113,57 @@ func TestRedirect(t *testing.T) {
 		})
 	}
 }
+
+func TestStreamFlush(t *testing.T) {
+	cfg := &config.Config{
+		HttpClient: &mockHttpClient{
+			resp: &gohttp.Response{
+				StatusCode: gohttp.StatusOK,
+				Body:       io.NopCloser(strings.NewReader("")),
+			},
+		},
+		SecretKey: crypto.PregenEd25519(0),
+	}
+	cfg.Auth.NoAuth = true
+	cfg.ExternalServices.Spaceport = "http://127.0.0.1:9981"
+	config.Mock(cfg)
+
+	a := &authHandler{
+		wl: map[string]bool{
+			"/api/v0/stream": true,
+		},
+	}
+	report := newMonitorHandler(nil)
+	release := make(chan struct{})
+	srv := httptest.NewServer(a.wrap(report.wrap(gohttp.HandlerFunc(
+		func(w gohttp.ResponseWriter, r *gohttp.Request) {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			w.Write([]byte("first\n"))
+			f, ok := w.(gohttp.Flusher)
+			require.True(t, ok)
+			f.Flush()
+			<-release
+			w.Write([]byte("second\n"))
+		},
+	))))
+	defer srv.Close()
+	defer close(release)
+
+	resp, err := gohttp.Get(srv.URL + "/api/v0/stream")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, gohttp.StatusOK, resp.StatusCode)
+
+	// The first event arrives while the handler is still running.
+	line := make(chan string, 1)
+	go func() {
+		s, _ := bufio.NewReader(resp.Body).ReadString('\n')
+		line <- s
+	}()
+	select {
+	case s := <-line:
+		require.Equal(t, "first\n", s)
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream is not flushed")
+	}
+}
@@ -0,0 +1,130 @@
+package com
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+
+	rcpinner "github.com/photon-storage/go-rc-pinner"
+)
+
+// PinIndexVersion is the version of exported pinner index.
+const PinIndexVersion = 1
+
+var (
+	ErrUnsupportedIndexVersion = errors.New("unsupported pin index version")
+)
+
+// PinIndex is an export of the pinner index with metadata.
+type PinIndex struct {
+	Version   int              `json:"version"`
+	CreatedAt int64            `json:"created_at"`
+	Recursive []*PinIndexEntry `json:"recursive"`
+	Direct    []*PinIndexEntry `json:"direct"`
+}
+
+// PinIndexEntry is the reference count and metadata of a pinned CID.
+type PinIndexEntry struct {
+	Cid   string     `json:"cid"`
+	Count uint16     `json:"count"`
+	Meta  []*PinMeta `json:"meta,omitempty"`
+}
+
+// ExportIndex lists recursive and direct pins with counts and metadata.
+// Metadata is skipped if the pinner does not support it.
+func (p *WrappedPinner) ExportIndex(
+	ctx context.Context,
+	createdAt int64,
+) (*PinIndex, error) {
+	idx := &PinIndex{
+		Version:   PinIndexVersion,
+		CreatedAt: createdAt,
+	}
+	for _, recursive := range []bool{true, false} {
+		var ch <-chan *rcpinner.StreamedCidWithCount
+		if recursive {
+			ch = p.Pinner.RecursiveKeysWithCount(ctx)
+		} else {
+			ch = p.Pinner.DirectKeysWithCount(ctx)
+		}
+
+		var entries []*PinIndexEntry
+		for v := range ch {
+			if v.Cid.Err != nil {
+				return nil, v.Cid.Err
+			}
+			if v.Count == 0 {
+				continue
+			}
+			entries = append(entries, &PinIndexEntry{
+				Cid:   v.Cid.C.String(),
+				Count: v.Count,
+			})
+		}
+
+		if p.Datastore != nil {
+			for _, e := range entries {
+				c, err := cid.Decode(e.Cid)
+				if err != nil {
+					return nil, err
+				}
+				if e.Meta, err = p.ListMeta(ctx, c, recursive); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		if recursive {
+			idx.Recursive = entries
+		} else {
+			idx.Direct = entries
+		}
+	}
+	return idx, nil
+}
+
+// RestoreCount raises the count of a CID to the given count and stores
+// metadata entries, which are keyed by ID so restoring twice is a no-op.
+// The DAG must be present locally as counts are updated without fetching.
+// It returns the number of increments applied.
+func (p *WrappedPinner) RestoreCount(
+	ctx context.Context,
+	c cid.Cid,
+	recursive bool,
+	count uint16,
+	metas []*PinMeta,
+) (uint16, error) {
+	p.countsMu.Lock()
+	defer p.countsMu.Unlock()
+
+	cur, err := p.GetCount(ctx, c, recursive)
+	if err != nil {
+		return 0, err
+	}
+
+	var added uint16
+	if count > cur {
+		added = count - cur
+		incs := make([]*rcpinner.UpdateCount, 0, added)
+		for i := uint16(0); i < added; i++ {
+			incs = append(incs, &rcpinner.UpdateCount{
+				CID:       c,
+				Recursive: recursive,
+			})
+		}
+		if err := p.updateCountsLocked(ctx, incs, nil); err != nil {
+			return 0, err
+		}
+	}
+
+	if p.Datastore != nil {
+		for _, m := range metas {
+			if err := p.AddMeta(ctx, c, recursive, m); err != nil {
+				return added, fmt.Errorf("error restoring metadata: %w", err)
+			}
+		}
+	}
+	return added, nil
+}
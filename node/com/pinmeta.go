@@ -0,0 +1,394 @@
+package com
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/multiformats/go-multibase"
+)
+
+// Metadata entries are stored next to the pinner index, keyed by the
+// index key of the CID. Each pin increment may have one entry. Entries
+// are also indexed by owner.
+const (
+	rMetaPath     = "/pins/meta_r"
+	dMetaPath     = "/pins/meta_d"
+	ownerMetaPath = "/pins/meta_owner"
+)
+
+var (
+	ErrMetaUnsupported = errors.New("pinner does not support metadata")
+)
+
+// PinMeta describes a pin increment.
+type PinMeta struct {
+	ID        string            `json:"id"`
+	Name      string            `json:"name,omitempty"`
+	Owner     string            `json:"owner,omitempty"`
+	RequestID string            `json:"request_id,omitempty"`
+	CreatedAt int64             `json:"created_at"`
+	Tags      map[string]string `json:"tags,omitempty"`
+}
+
+// OwnedPin is a metadata entry listed by owner.
+type OwnedPin struct {
+	Cid       cid.Cid
+	Recursive bool
+	Meta      *PinMeta
+}
+
+// AddMeta stores metadata of a pin increment. It should be called after
+// the CID is pinned. ID and creation time are assigned if not set.
+func (p *WrappedPinner) AddMeta(
+	ctx context.Context,
+	c cid.Cid,
+	recursive bool,
+	m *PinMeta,
+) error {
+	if p.Datastore == nil {
+		return ErrMetaUnsupported
+	}
+
+	if m.ID == "" {
+		m.ID = uuid.New().String()
+	}
+	if m.CreatedAt == 0 {
+		m.CreatedAt = time.Now().Unix()
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	p.metaMu.Lock()
+	defer p.metaMu.Unlock()
+
+	if err := p.Datastore.Put(ctx, metaKey(c, recursive, m), data); err != nil {
+		return err
+	}
+	if m.Owner != "" {
+		if err := p.Datastore.Put(
+			ctx,
+			ownerMetaKey(c, recursive, m),
+			data,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListMeta lists metadata of a CID in creation order.
+func (p *WrappedPinner) ListMeta(
+	ctx context.Context,
+	c cid.Cid,
+	recursive bool,
+) ([]*PinMeta, error) {
+	if p.Datastore == nil {
+		return nil, ErrMetaUnsupported
+	}
+
+	var metas []*PinMeta
+	if err := p.forEachMeta(
+		ctx,
+		path.Join(metaPath(recursive), encodeIndexKey(c)),
+		func(_ string, m *PinMeta) error {
+			metas = append(metas, m)
+			return nil
+		},
+	); err != nil {
+		return nil, err
+	}
+	return metas, nil
+}
+
+// ListMetaByOwner lists metadata of an owner ordered by CID.
+func (p *WrappedPinner) ListMetaByOwner(
+	ctx context.Context,
+	owner string,
+	recursive bool,
+) ([]*OwnedPin, error) {
+	if p.Datastore == nil {
+		return nil, ErrMetaUnsupported
+	}
+
+	var pins []*OwnedPin
+	if err := p.forEachMeta(
+		ctx,
+		path.Join(ownerMetaPath, encodeOwner(owner), metaMode(recursive)),
+		func(k string, m *PinMeta) error {
+			// Key ends with <index key>/<entry>.
+			c, err := DecodeIndexKey(path.Base(path.Dir(k)))
+			if err != nil {
+				return err
+			}
+			pins = append(pins, &OwnedPin{
+				Cid:       c,
+				Recursive: recursive,
+				Meta:      m,
+			})
+			return nil
+		},
+	); err != nil {
+		return nil, err
+	}
+	return pins, nil
+}
+
+// OwnedCid is a pinned CID with metadata entries of an owner.
+type OwnedCid struct {
+	Cid   cid.Cid
+	Count uint16
+	Metas []*PinMeta
+}
+
+// OwnerPage is a page of CIDs pinned by an owner.
+type OwnerPage struct {
+	Pins []*OwnedCid
+	// Index key of the last CID if more CIDs follow.
+	Next string
+}
+
+// ListOwnerPage lists CIDs pinned by an owner in the owner index order.
+// As ListPage, a page resumes after the index key of the previous one.
+// Zero limit lists all CIDs.
+func (p *WrappedPinner) ListOwnerPage(
+	ctx context.Context,
+	owner string,
+	recursive bool,
+	opts *PageOptions,
+) (*OwnerPage, error) {
+	if p.Datastore == nil {
+		return nil, ErrMetaUnsupported
+	}
+	if opts.After != "" {
+		if _, err := DecodeIndexKey(opts.After); err != nil {
+			return nil, err
+		}
+	}
+
+	prefix := path.Join(ownerMetaPath, encodeOwner(owner), metaMode(recursive))
+	q := query.Query{
+		Prefix: prefix,
+		Orders: []query.Order{query.OrderByKey{}},
+	}
+	if opts.After != "" {
+		// Entries of a CID are keyed under its index key, so resume past
+		// all of them.
+		q.Filters = []query.Filter{
+			query.FilterKeyCompare{
+				Op:  query.GreaterThan,
+				Key: path.Join(prefix, opts.After) + "/\xff",
+			},
+		}
+	}
+	res, err := p.Datastore.Query(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	page := &OwnerPage{}
+	var last *OwnedCid
+	lastKey := ""
+	skipKey := ""
+	for r := range res.Next() {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if r.Error != nil {
+			return nil, fmt.Errorf("error advancing metadata query result: %w", r.Error)
+		}
+
+		var m PinMeta
+		if err := json.Unmarshal(r.Entry.Value, &m); err != nil {
+			return nil, err
+		}
+		// Key ends with <index key>/<entry>.
+		k := path.Base(path.Dir(r.Entry.Key))
+		if last != nil && k == lastKey {
+			last.Metas = append(last.Metas, &m)
+			continue
+		}
+		if k == skipKey {
+			continue
+		}
+
+		c, err := DecodeIndexKey(k)
+		if err != nil {
+			return nil, err
+		}
+		cnt, err := p.Pinner.GetCount(ctx, c, recursive)
+		if err != nil {
+			return nil, err
+		}
+		if !opts.Match(c, cnt) {
+			last = nil
+			skipKey = k
+			continue
+		}
+		if opts.Limit > 0 && len(page.Pins) >= opts.Limit {
+			// More CIDs follow.
+			page.Next = lastKey
+			break
+		}
+
+		last = &OwnedCid{
+			Cid:   c,
+			Count: cnt,
+			Metas: []*PinMeta{&m},
+		}
+		lastKey = k
+		page.Pins = append(page.Pins, last)
+	}
+	return page, nil
+}
+
+// RemoveMeta removes metadata of an unpinned increment. It should be
+// called after the CID is unpinned. The latest entry of the owner is
+// removed if any. All entries are removed once the CID is no longer
+// pinned. It returns whether an entry of the owner was removed.
+func (p *WrappedPinner) RemoveMeta(
+	ctx context.Context,
+	c cid.Cid,
+	recursive bool,
+	owner string,
+) (bool, error) {
+	if p.Datastore == nil {
+		return false, ErrMetaUnsupported
+	}
+
+	metas, err := p.ListMeta(ctx, c, recursive)
+	if err != nil {
+		return false, err
+	}
+	if len(metas) == 0 {
+		return false, nil
+	}
+
+	cnt, err := p.Pinner.GetCount(ctx, c, recursive)
+	if err != nil {
+		return false, err
+	}
+
+	var removes []*PinMeta
+	if cnt == 0 {
+		removes = metas
+	} else {
+		for i := len(metas) - 1; i >= 0; i-- {
+			if metas[i].Owner == owner {
+				removes = []*PinMeta{metas[i]}
+				break
+			}
+		}
+	}
+
+	p.metaMu.Lock()
+	defer p.metaMu.Unlock()
+
+	owned := false
+	for _, m := range removes {
+		if err := p.Datastore.Delete(ctx, metaKey(c, recursive, m)); err != nil {
+			return false, err
+		}
+		if m.Owner != "" {
+			if err := p.Datastore.Delete(
+				ctx,
+				ownerMetaKey(c, recursive, m),
+			); err != nil {
+				return false, err
+			}
+		}
+		if m.Owner == owner {
+			owned = true
+		}
+	}
+	return owned, nil
+}
+
+func (p *WrappedPinner) forEachMeta(
+	ctx context.Context,
+	prefix string,
+	fn func(k string, m *PinMeta) error,
+) error {
+	res, err := p.Datastore.Query(ctx, query.Query{
+		Prefix: prefix,
+		Orders: []query.Order{query.OrderByKey{}},
+	})
+	if err != nil {
+		return err
+	}
+	defer res.Close()
+
+	for r := range res.Next() {
+		if r.Error != nil {
+			return fmt.Errorf("error advancing metadata query result: %w", r.Error)
+		}
+
+		var m PinMeta
+		if err := json.Unmarshal(r.Entry.Value, &m); err != nil {
+			return err
+		}
+		if err := fn(r.Entry.Key, &m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func metaPath(recursive bool) string {
+	if recursive {
+		return rMetaPath
+	}
+	return dMetaPath
+}
+
+func metaMode(recursive bool) string {
+	if recursive {
+		return "r"
+	}
+	return "d"
+}
+
+// metaEntry orders entries of a CID by creation time.
+func metaEntry(m *PinMeta) string {
+	return fmt.Sprintf("%020d-%v", m.CreatedAt, m.ID)
+}
+
+func metaKey(c cid.Cid, recursive bool, m *PinMeta) datastore.Key {
+	return datastore.NewKey(path.Join(
+		metaPath(recursive),
+		encodeIndexKey(c),
+		metaEntry(m),
+	))
+}
+
+func ownerMetaKey(c cid.Cid, recursive bool, m *PinMeta) datastore.Key {
+	return datastore.NewKey(path.Join(
+		ownerMetaPath,
+		encodeOwner(m.Owner),
+		metaMode(recursive),
+		encodeIndexKey(c),
+		metaEntry(m),
+	))
+}
+
+// encodeOwner encodes an owner as a single key namespace.
+func encodeOwner(owner string) string {
+	k, _ := multibase.Encode(multibase.Base64url, []byte(owner))
+	return k
+}
+
+// encodeIndexKey encodes a CID as the pinner index does.
+func encodeIndexKey(c cid.Cid) string {
+	k, _ := multibase.Encode(multibase.Base64url, c.Bytes())
+	return k
+}
@@ -0,0 +1,157 @@
+package com
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	bs "github.com/ipfs/boxo/blockservice"
+	"github.com/ipfs/boxo/blockstore"
+	"github.com/ipfs/boxo/exchange/offline"
+	"github.com/ipfs/boxo/ipld/merkledag"
+	pin "github.com/ipfs/boxo/pinning/pinner"
+	"github.com/ipfs/boxo/pinning/pinner/dspinner"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+)
+
+// Pins of stock Kubo are stored by the dspinner under legacyPinPath. They
+// are left untouched by the migration. Each migrated pin is marked so an
+// interrupted migration resumes without adding counts twice, and pins
+// unpinned after migration are not restored by a later run.
+const (
+	legacyPinPath = "/pins/pin"
+)
+
+var (
+	migrationPrefix    = datastore.NewKey("/falcon/migration/dspinner")
+	migrationDoneKey   = migrationPrefix.ChildString("done")
+	migrationMarkerKey = migrationPrefix.ChildString("pins")
+)
+
+// PinMigrationResult counts pins converted from the legacy pinset.
+// Skipped pins were migrated by a previous run.
+type PinMigrationResult struct {
+	Recursive int `json:"recursive"`
+	Direct    int `json:"direct"`
+	Skipped   int `json:"skipped"`
+}
+
+// HasLegacyPins returns true if the datastore has a legacy pinset which
+// has not been migrated.
+func HasLegacyPins(ctx context.Context, dstore datastore.Datastore) (bool, error) {
+	done, err := dstore.Has(ctx, migrationDoneKey)
+	if err != nil || done {
+		return false, err
+	}
+
+	res, err := dstore.Query(ctx, query.Query{
+		Prefix:   legacyPinPath,
+		KeysOnly: true,
+		Limit:    1,
+	})
+	if err != nil {
+		return false, err
+	}
+	defer res.Close()
+
+	for r := range res.Next() {
+		if r.Error != nil {
+			return false, r.Error
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// MigrateLegacyPins converts each recursive and direct pin of the legacy
+// pinset into an rc count of 1. A pin already counted by the rc pinner
+// keeps its count. Blocks are not fetched, so missing blocks of a legacy
+// pin remain missing.
+func MigrateLegacyPins(
+	ctx context.Context,
+	dstore datastore.Batching,
+	p *WrappedPinner,
+) (*PinMigrationResult, error) {
+	// The DAG service is only used for pinning by the dspinner, which
+	// never happens here.
+	bstore := blockstore.NewBlockstore(dstore)
+	legacy, err := dspinner.New(
+		ctx,
+		dstore,
+		merkledag.NewDAGService(bs.New(bstore, offline.Exchange(bstore))),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &PinMigrationResult{}
+	for _, recursive := range []bool{true, false} {
+		var ch <-chan pin.StreamedCid
+		if recursive {
+			ch = legacy.RecursiveKeys(ctx)
+		} else {
+			ch = legacy.DirectKeys(ctx)
+		}
+
+		// Collect keys first as the dspinner holds its lock while
+		// streaming.
+		var cids []cid.Cid
+		for v := range ch {
+			if v.Err != nil {
+				return nil, v.Err
+			}
+			cids = append(cids, v.C)
+		}
+
+		for _, c := range cids {
+			key := migrationMarker(c, recursive)
+			migrated, err := dstore.Has(ctx, key)
+			if err != nil {
+				return nil, err
+			}
+			if migrated {
+				res.Skipped++
+				continue
+			}
+
+			if _, err := p.RestoreCount(ctx, c, recursive, 1, nil); err != nil {
+				return nil, err
+			}
+			if err := dstore.Put(ctx, key, []byte{1}); err != nil {
+				return nil, err
+			}
+
+			if recursive {
+				res.Recursive++
+			} else {
+				res.Direct++
+			}
+		}
+	}
+
+	data, err := json.Marshal(&struct {
+		MigratedAt int64               `json:"migrated_at"`
+		Result     *PinMigrationResult `json:"result"`
+	}{
+		MigratedAt: time.Now().Unix(),
+		Result:     res,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := dstore.Put(ctx, migrationDoneKey, data); err != nil {
+		return nil, err
+	}
+	if err := dstore.Sync(ctx, migrationPrefix); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func migrationMarker(c cid.Cid, recursive bool) datastore.Key {
+	return migrationMarkerKey.
+		ChildString(metaMode(recursive)).
+		ChildString(encodeIndexKey(c))
+}
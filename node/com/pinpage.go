@@ -0,0 +1,205 @@
+package com
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/multiformats/go-multibase"
+)
+
+// The layout mirrors the index of go-rc-pinner. Keys are base64url
+// multibase encoded CID bytes and values are little endian uint16
+// reference counts.
+const (
+	rIndexPath    = "/pins/idx_r"
+	dIndexPath    = "/pins/idx_d"
+	totalCountKey = "metadata:tc"
+)
+
+var (
+	ErrPageUnsupported = errors.New("pinner does not support paging")
+	ErrInvalidIndexKey = errors.New("invalid pinner index key")
+)
+
+type PinnedCid struct {
+	Cid   cid.Cid
+	Count uint16
+}
+
+// PageOptions specifies a page of the pinner index. Zero values disable
+// the corresponding filter.
+type PageOptions struct {
+	// Index key after which the page starts.
+	After    string
+	Limit    int
+	MinCount uint16
+	MaxCount uint16
+	// Prefix of CID string.
+	Prefix string
+	// Count all entries matching filters, which scans the whole index.
+	Total bool
+}
+
+// Match checks if an index entry passes filters.
+func (o *PageOptions) Match(c cid.Cid, cnt uint16) bool {
+	if cnt == 0 {
+		return false
+	}
+	if o.MinCount > 0 && cnt < o.MinCount {
+		return false
+	}
+	if o.MaxCount > 0 && cnt > o.MaxCount {
+		return false
+	}
+	return o.Prefix == "" || strings.HasPrefix(c.String(), o.Prefix)
+}
+
+type Page struct {
+	Pins []*PinnedCid
+	// Index key of the last entry if more entries follow.
+	Next string
+	// Number of entries matching filters, -1 if not counted.
+	Total int64
+}
+
+// ListPage lists pinner index entries in key order. A page resumes after
+// the key of the previous one so entries present during the whole
+// iteration are listed exactly once regardless of concurrent pin updates.
+// Entries added or removed during iteration may or may not be listed.
+func (p *WrappedPinner) ListPage(
+	ctx context.Context,
+	recursive bool,
+	opts *PageOptions,
+) (*Page, error) {
+	if p.Datastore == nil {
+		return nil, ErrPageUnsupported
+	}
+
+	prefix := dIndexPath
+	if recursive {
+		prefix = rIndexPath
+	}
+	if opts.After != "" {
+		if _, err := DecodeIndexKey(opts.After); err != nil {
+			return nil, err
+		}
+	}
+
+	page := &Page{
+		Total: -1,
+	}
+	last := ""
+	if err := p.forEachIndex(
+		ctx,
+		prefix,
+		opts.After,
+		func(k string, c cid.Cid, cnt uint16) bool {
+			if !opts.Match(c, cnt) {
+				return true
+			}
+			if len(page.Pins) >= opts.Limit {
+				// More entries follow.
+				page.Next = last
+				return false
+			}
+			page.Pins = append(page.Pins, &PinnedCid{
+				Cid:   c,
+				Count: cnt,
+			})
+			last = k
+			return true
+		},
+	); err != nil {
+		return nil, err
+	}
+
+	if opts.Total {
+		page.Total = 0
+		if err := p.forEachIndex(
+			ctx,
+			prefix,
+			"",
+			func(_ string, c cid.Cid, cnt uint16) bool {
+				if opts.Match(c, cnt) {
+					page.Total++
+				}
+				return true
+			},
+		); err != nil {
+			return nil, err
+		}
+	}
+
+	return page, nil
+}
+
+func (p *WrappedPinner) forEachIndex(
+	ctx context.Context,
+	prefix string,
+	after string,
+	fn func(k string, c cid.Cid, cnt uint16) bool,
+) error {
+	q := query.Query{
+		Prefix: prefix,
+		Orders: []query.Order{query.OrderByKey{}},
+	}
+	if after != "" {
+		q.Filters = []query.Filter{
+			query.FilterKeyCompare{
+				Op:  query.GreaterThan,
+				Key: path.Join(prefix, after),
+			},
+		}
+	}
+	res, err := p.Datastore.Query(ctx, q)
+	if err != nil {
+		return err
+	}
+	defer res.Close()
+
+	for r := range res.Next() {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if r.Error != nil {
+			return fmt.Errorf("error advancing index query result: %w", r.Error)
+		}
+
+		k := path.Base(r.Entry.Key)
+		if k == totalCountKey {
+			continue
+		}
+		c, err := DecodeIndexKey(k)
+		if err != nil {
+			return err
+		}
+		if len(r.Entry.Value) != 2 {
+			return fmt.Errorf("invalid index value for %v", c)
+		}
+
+		if !fn(k, c, binary.LittleEndian.Uint16(r.Entry.Value)) {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// DecodeIndexKey decodes the CID from a pinner index key.
+func DecodeIndexKey(k string) (cid.Cid, error) {
+	_, data, err := multibase.Decode(k)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("%w: %v", ErrInvalidIndexKey, err)
+	}
+	c, err := cid.Cast(data)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("%w: %v", ErrInvalidIndexKey, err)
+	}
+	return c, nil
+}
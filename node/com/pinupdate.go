@@ -0,0 +1,222 @@
+package com
+
+import (
+	"context"
+
+	"github.com/ipfs/boxo/ipld/merkledag"
+	pin "github.com/ipfs/boxo/pinning/pinner"
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	rcpinner "github.com/photon-storage/go-rc-pinner"
+
+	"github.com/photon-storage/falcon/node/tracing"
+)
+
+// PinUpdateDelta is the DAG difference of a pin update. Added blocks are
+// linked from the new root only and removed blocks from the old root
+// only. Blocks moved within the DAG are in neither.
+type PinUpdateDelta struct {
+	AddedSize        int64
+	AddedNumBlocks   int64
+	RemovedSize      int64
+	RemovedNumBlocks int64
+}
+
+// UpdateWithDelta moves a recursive pin count from one root to another,
+// keeping the old count if unpin is false. The two DAGs are diffed by
+// link name so subtrees shared by both roots are neither fetched nor
+// traversed. Blocks fetched are added to the DAG size in context. As
+// shared subtrees are skipped, a block dropped from one path but still
+// linked from a shared subtree is reported as removed.
+func (p *WrappedPinner) UpdateWithDelta(
+	ctx context.Context,
+	from cid.Cid,
+	to cid.Cid,
+	unpin bool,
+) (delta *PinUpdateDelta, err error) {
+	ctx, span := tracing.Span(
+		ctx,
+		"RcPinner",
+		"Update",
+		trace.WithAttributes(
+			attribute.String("from", from.String()),
+			attribute.String("to", to.String()),
+			attribute.Bool("unpin", unpin),
+		),
+	)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	if p.DAG == nil {
+		return nil, rcpinner.ErrUpdateUnsupported
+	}
+
+	cnt, err := p.GetCount(ctx, from, true)
+	if err != nil {
+		return nil, err
+	}
+	if cnt == 0 {
+		return nil, pin.ErrNotPinned
+	}
+
+	d := &pinDiff{
+		ng:      merkledag.NewSession(ctx, p.DAG),
+		added:   map[cid.Cid]int64{},
+		removed: map[cid.Cid]int64{},
+	}
+	if err := d.diff(ctx, from, to); err != nil {
+		return nil, err
+	}
+
+	incs := []*rcpinner.UpdateCount{{CID: to, Recursive: true}}
+	var decs []*rcpinner.UpdateCount
+	if unpin && !from.Equals(to) {
+		decs = []*rcpinner.UpdateCount{{CID: from, Recursive: true}}
+	}
+	if unpin && from.Equals(to) {
+		// Nothing to move.
+		incs = nil
+	}
+
+	p.countsMu.Lock()
+	defer p.countsMu.Unlock()
+	if err := p.updateCountsLocked(ctx, incs, decs); err != nil {
+		return nil, err
+	}
+	return d.delta(), nil
+}
+
+// pinDiff collects blocks added and removed between two DAGs.
+type pinDiff struct {
+	ng      ipld.NodeGetter
+	added   map[cid.Cid]int64
+	removed map[cid.Cid]int64
+}
+
+func (d *pinDiff) diff(ctx context.Context, from cid.Cid, to cid.Cid) error {
+	if from.Equals(to) {
+		return nil
+	}
+
+	fnd, err := d.ng.Get(ctx, from)
+	if err != nil {
+		return err
+	}
+	tnd, err := d.fetch(ctx, to)
+	if err != nil {
+		return err
+	}
+	d.removed[from] = int64(len(fnd.RawData()))
+
+	shared := map[cid.Cid]bool{}
+	byName := map[string]*ipld.Link{}
+	for _, l := range fnd.Links() {
+		shared[l.Cid] = false
+		if l.Name != "" {
+			byName[l.Name] = l
+		}
+	}
+
+	// Links kept as is are matched first so a subtree moved to another
+	// name is not diffed against a changed one.
+	var changed []*ipld.Link
+	for _, l := range tnd.Links() {
+		if _, ok := shared[l.Cid]; ok {
+			shared[l.Cid] = true
+			continue
+		}
+		changed = append(changed, l)
+	}
+
+	for _, l := range changed {
+		if fl, ok := byName[l.Name]; ok && l.Name != "" && !shared[fl.Cid] {
+			shared[fl.Cid] = true
+			if err := d.diff(ctx, fl.Cid, l.Cid); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := d.walk(ctx, l.Cid, true); err != nil {
+			return err
+		}
+	}
+
+	for c, used := range shared {
+		if !used {
+			if err := d.walk(ctx, c, false); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// walk collects all blocks of a subtree as added or removed. Blocks of
+// added subtrees are fetched.
+func (d *pinDiff) walk(ctx context.Context, root cid.Cid, add bool) error {
+	set := d.removed
+	if add {
+		set = d.added
+	}
+
+	stack := []cid.Cid{root}
+	for len(stack) > 0 {
+		c := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if _, ok := set[c]; ok {
+			continue
+		}
+
+		var nd ipld.Node
+		var err error
+		if add {
+			nd, err = d.fetch(ctx, c)
+		} else {
+			nd, err = d.ng.Get(ctx, c)
+		}
+		if err != nil {
+			return err
+		}
+		set[c] = int64(len(nd.RawData()))
+		for _, l := range nd.Links() {
+			stack = append(stack, l.Cid)
+		}
+	}
+	return nil
+}
+
+func (d *pinDiff) fetch(ctx context.Context, c cid.Cid) (ipld.Node, error) {
+	nd, err := d.ng.Get(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := d.added[c]; !ok {
+		d.added[c] = int64(len(nd.RawData()))
+		if sz := rcpinner.DagSize(ctx); sz != nil {
+			sz.Add(uint64(len(nd.RawData())))
+		}
+	}
+	return nd, nil
+}
+
+func (d *pinDiff) delta() *PinUpdateDelta {
+	delta := &PinUpdateDelta{}
+	for c, sz := range d.added {
+		if _, ok := d.removed[c]; ok {
+			continue
+		}
+		delta.AddedSize += sz
+		delta.AddedNumBlocks++
+	}
+	for c, sz := range d.removed {
+		if _, ok := d.added[c]; ok {
+			continue
+		}
+		delta.RemovedSize += sz
+		delta.RemovedNumBlocks++
+	}
+	return delta
+}
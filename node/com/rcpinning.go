@@ -2,18 +2,24 @@ package com
 
 import (
 	"context"
+	"sync"
 
 	"github.com/ipfs/boxo/blockstore"
 	"github.com/ipfs/boxo/filestore"
 	"github.com/ipfs/boxo/ipld/merkledag"
 	pin "github.com/ipfs/boxo/pinning/pinner"
 	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
 	ipld "github.com/ipfs/go-ipld-format"
 	"github.com/ipfs/kubo/repo"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/photon-storage/go-common/log"
 	"github.com/photon-storage/go-common/metrics"
 	rcpinner "github.com/photon-storage/go-rc-pinner"
+
+	"github.com/photon-storage/falcon/node/tracing"
 )
 
 // RcPinning creates new pinner which tells GC which blocks should be kept.
@@ -37,7 +43,9 @@ func RcPinning(
 	}
 
 	return &WrappedPinner{
-		Pinner: pinner,
+		Pinner:    pinner,
+		Datastore: rootDstore,
+		DAG:       dserv,
 	}
 }
 
@@ -74,6 +82,13 @@ func (s *syncDagService) Session(ctx context.Context) ipld.NodeGetter {
 
 type WrappedPinner struct {
 	Pinner *rcpinner.RcPinner
+	// Datastore holding the pinner index, used for paging and metadata.
+	Datastore datastore.Datastore
+	// DAG fetches blocks for recursive pins and pin updates.
+	DAG ipld.DAGService
+
+	metaMu   sync.Mutex
+	countsMu sync.Mutex
 }
 
 func (p *WrappedPinner) IsPinned(
@@ -95,8 +110,25 @@ func (p *WrappedPinner) Pin(
 	ctx context.Context,
 	node ipld.Node,
 	recursive bool,
-) error {
+) (err error) {
+	ctx, span := pinnerSpan(ctx, "Pin", node.Cid(), recursive)
+	defer func() { tracing.EndSpan(span, err) }()
+
 	metrics.CounterInc("rc_pinner_pin_call_total")
+	if recursive && p.DAG != nil {
+		if err := p.DAG.Add(ctx, node); err != nil {
+			metrics.CounterInc("rc_pinner_pin_err_total")
+			return err
+		}
+		if err := p.pinRecursive(ctx, node.Cid(), p.DAG); err != nil {
+			metrics.CounterInc("rc_pinner_pin_err_total")
+			return err
+		}
+		return nil
+	}
+
+	p.countsMu.Lock()
+	defer p.countsMu.Unlock()
 	if err := p.Pinner.Pin(ctx, node, recursive); err != nil {
 		metrics.CounterInc("rc_pinner_pin_err_total")
 		return err
@@ -104,12 +136,61 @@ func (p *WrappedPinner) Pin(
 	return nil
 }
 
+// PinWithDAG pins c recursively, reading its DAG from dag instead of the
+// pinner DAG. A DAG not complete in an offline dag fails with not found,
+// so nothing is fetched from the network.
+func (p *WrappedPinner) PinWithDAG(
+	ctx context.Context,
+	c cid.Cid,
+	dag ipld.DAGService,
+) (err error) {
+	ctx, span := pinnerSpan(ctx, "PinWithDAG", c, true)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	metrics.CounterInc("rc_pinner_pin_call_total")
+	if err := p.pinRecursive(ctx, c, dag); err != nil {
+		metrics.CounterInc("rc_pinner_pin_err_total")
+		return err
+	}
+	return nil
+}
+
+// pinRecursive fetches the DAG before taking countsMu so only the count
+// increment is serialized, which keeps a large pin from blocking count
+// changes of other pins.
+func (p *WrappedPinner) pinRecursive(
+	ctx context.Context,
+	c cid.Cid,
+	dag ipld.DAGService,
+) error {
+	if err := rcpinner.FetchGraphWithDepthLimit(
+		ctx,
+		c,
+		-1,
+		dag,
+	); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	p.countsMu.Lock()
+	defer p.countsMu.Unlock()
+	return p.Pinner.IncCount(ctx, c, true)
+}
+
 func (p *WrappedPinner) Unpin(
 	ctx context.Context,
 	cid cid.Cid,
 	recursive bool,
-) error {
+) (err error) {
+	ctx, span := pinnerSpan(ctx, "Unpin", cid, recursive)
+	defer func() { tracing.EndSpan(span, err) }()
+
 	metrics.CounterInc("rc_pinner_unpin_call_total")
+	p.countsMu.Lock()
+	defer p.countsMu.Unlock()
 	if err := p.Pinner.Unpin(ctx, cid, recursive); err != nil {
 		metrics.CounterInc("rc_pinner_unpin_err_total")
 		return err
@@ -121,7 +202,10 @@ func (p *WrappedPinner) GetCount(
 	ctx context.Context,
 	c cid.Cid,
 	recursive bool,
-) (uint16, error) {
+) (cnt uint16, err error) {
+	ctx, span := pinnerSpan(ctx, "GetCount", c, recursive)
+	defer func() { tracing.EndSpan(span, err) }()
+
 	return p.Pinner.GetCount(ctx, c, recursive)
 }
 
@@ -130,16 +214,57 @@ func (p *WrappedPinner) UpdateCounts(
 	incs []*rcpinner.UpdateCount,
 	decs []*rcpinner.UpdateCount,
 ) error {
+	p.countsMu.Lock()
+	defer p.countsMu.Unlock()
+	return p.updateCountsLocked(ctx, incs, decs)
+}
+
+// updateCountsLocked applies count updates with countsMu held.
+func (p *WrappedPinner) updateCountsLocked(
+	ctx context.Context,
+	incs []*rcpinner.UpdateCount,
+	decs []*rcpinner.UpdateCount,
+) (err error) {
+	ctx, span := tracing.Span(
+		ctx,
+		"RcPinner",
+		"UpdateCounts",
+		trace.WithAttributes(
+			attribute.Int("incs", len(incs)),
+			attribute.Int("decs", len(decs)),
+		),
+	)
+	defer func() { tracing.EndSpan(span, err) }()
+
 	return p.Pinner.UpdateCounts(ctx, incs, decs)
 }
 
+// UpdateCountsIf applies count updates if check passes. Count changes
+// through the wrapper are serialized by countsMu so counts seen by check
+// are not changed by other calls before updates are applied.
+func (p *WrappedPinner) UpdateCountsIf(
+	ctx context.Context,
+	incs []*rcpinner.UpdateCount,
+	decs []*rcpinner.UpdateCount,
+	check func(ctx context.Context) error,
+) error {
+	p.countsMu.Lock()
+	defer p.countsMu.Unlock()
+
+	if err := check(ctx); err != nil {
+		return err
+	}
+	return p.updateCountsLocked(ctx, incs, decs)
+}
+
 func (p *WrappedPinner) Update(
 	ctx context.Context,
 	from cid.Cid,
 	to cid.Cid,
 	unpin bool,
 ) error {
-	return rcpinner.ErrUpdateUnsupported
+	_, err := p.UpdateWithDelta(ctx, from, to, unpin)
+	return err
 }
 
 func (p *WrappedPinner) CheckIfPinned(
@@ -153,7 +278,16 @@ func (p *WrappedPinner) PinWithMode(
 	ctx context.Context,
 	cid cid.Cid,
 	mode pin.Mode,
-) error {
+) (err error) {
+	ctx, span := pinnerSpan(ctx, "PinWithMode", cid, mode == pin.Recursive)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	if mode == pin.Recursive && p.DAG != nil {
+		return p.pinRecursive(ctx, cid, p.DAG)
+	}
+
+	p.countsMu.Lock()
+	defer p.countsMu.Unlock()
 	return p.Pinner.PinWithMode(ctx, cid, mode)
 }
 
@@ -197,6 +331,23 @@ func (p *WrappedPinner) TotalPinnedCount() int64 {
 	return int64(p.Pinner.TotalPinnedCount(true) + p.Pinner.TotalPinnedCount(false))
 }
 
+func pinnerSpan(
+	ctx context.Context,
+	name string,
+	c cid.Cid,
+	recursive bool,
+) (context.Context, trace.Span) {
+	return tracing.Span(
+		ctx,
+		"RcPinner",
+		name,
+		trace.WithAttributes(
+			attribute.String("cid", c.String()),
+			attribute.Bool("recursive", recursive),
+		),
+	)
+}
+
 func RegisterPinnerMetrics() {
 	metrics.NewCounter("rc_pinner_pin_call_total")
 	metrics.NewCounter("rc_pinner_pin_err_total")
@@ -24,6 +24,12 @@ type ListenAddress struct {
 	UseTLS  bool   `yaml:"use_tls"`
 }
 
+// QuotaLimit limits usage of an account. Zero means unlimited.
+type QuotaLimit struct {
+	MaxBytes int64 `yaml:"max_bytes"`
+	MaxCount int64 `yaml:"max_count"`
+}
+
 // Config defines the config for falcon gateway.
 type Config struct {
 	// Log configuration.
@@ -42,6 +48,24 @@ type Config struct {
 		Color bool `yaml:"color"`
 	}
 
+	// Tracing configs OpenTelemetry span export. If exporter is empty,
+	// Kubo settings from OTEL_* environment variables are used.
+	Tracing struct {
+		// Supported exporters: "otlp", "file".
+		Exporter string `yaml:"exporter"`
+		// OTLP collector address in host:port format.
+		OtlpEndpoint string `yaml:"otlp_endpoint"`
+		// OTLP protocol: "http/protobuf" (default) or "grpc".
+		OtlpProtocol string `yaml:"otlp_protocol"`
+		// Disable TLS for OTLP connection.
+		OtlpInsecure bool `yaml:"otlp_insecure"`
+		// JSON trace file path for file exporter.
+		FilePath string `yaml:"file_path"`
+		// Fraction of root spans sampled in (0, 1). Out of range values
+		// sample everything.
+		SampleRatio float64 `yaml:"sample_ratio"`
+	} `yaml:"tracing"`
+
 	// Auth configs API authentication.
 	Auth struct {
 		// Disable authentication for API requests.
@@ -69,11 +93,98 @@ type Config struct {
 
 	ListenAddresses []ListenAddress `yaml:"listen_addresses"`
 
+	// Timeouts configs request timeouts. Zero values use defaults.
+	Timeouts struct {
+		// Timeout for routes not configured.
+		Default time.Duration `yaml:"default"`
+		// Per-route timeouts keyed by canonical URI, e.g. /api/v0/pin/add.
+		Routes map[string]time.Duration `yaml:"routes"`
+		// Upper bound of the timeout override signed in P3 args.
+		MaxOverride time.Duration `yaml:"max_override"`
+		// Cancel requests when neither p2p ingress nor HTTP egress has
+		// progressed for the interval. Zero disables stall detection.
+		StallInterval time.Duration `yaml:"stall_interval"`
+	} `yaml:"timeouts"`
+
 	ExternalServices struct {
 		Starbase  string `yaml:"starbase"`
 		Spaceport string `yaml:"spaceport"`
+		// Usage log version sent to Spaceport. Zero means using the
+		// latest version and falling back to the legacy version if
+		// Spaceport rejects it.
+		SpaceportLogVersion int `yaml:"spaceport_log_version"`
 	} `yaml:"extern_services"`
 
+	// PinJobs configs asynchronous pin jobs. Zero values use defaults.
+	PinJobs struct {
+		// Number of jobs running concurrently.
+		Workers int `yaml:"workers"`
+		// Finished jobs are deleted after retention.
+		Retention time.Duration `yaml:"retention"`
+	} `yaml:"pin_jobs"`
+
+	// DagStats configs the persistent DAG stats cache. Zero values use
+	// defaults.
+	DagStats struct {
+		// Disable caching DAG stats in datastore.
+		DisableCache bool `yaml:"disable_cache"`
+		// Interval of sweeping stats of unpinned CIDs.
+		SweepInterval time.Duration `yaml:"sweep_interval"`
+		// Calculate stats of recursive pins in background.
+		Precompute bool `yaml:"precompute"`
+	} `yaml:"dag_stats"`
+
+	// PinTTL configs expiry of pins with TTL signed in P3 args. Zero
+	// values use defaults.
+	PinTTL struct {
+		// Interval of unpinning expired pins.
+		SweepInterval time.Duration `yaml:"sweep_interval"`
+	} `yaml:"pin_ttl"`
+
+	// Quotas configs per-account limits on pinned bytes and pin count.
+	// Accounts are identified by the account ID signed in P3 args.
+	Quotas struct {
+		// Track account usage and enforce limits.
+		Enabled bool `yaml:"enabled"`
+		// Default limits of accounts.
+		Default QuotaLimit `yaml:"default"`
+		// Per-account limits keyed by account ID, overriding defaults.
+		Accounts map[string]QuotaLimit `yaml:"accounts"`
+	} `yaml:"quotas"`
+
+	// PinVerify configs background verification of pinned DAGs.
+	PinVerify struct {
+		// Interval of verifying all pins. Zero disables background
+		// verification.
+		Interval time.Duration `yaml:"interval"`
+		// Refetch missing or corrupt blocks from the network.
+		Repair bool `yaml:"repair"`
+	} `yaml:"pin_verify"`
+
+	// PinMigration configs migrating pins left by stock Kubo into the rc
+	// pinner at daemon start.
+	PinMigration struct {
+		// Skip migration at daemon start.
+		Disable bool `yaml:"disable"`
+		// Directory of the pin index backup written before migration.
+		// Defaults to the falcon path.
+		BackupDir string `yaml:"backup_dir"`
+	} `yaml:"pin_migration"`
+
+	// Health configs checks run by /status. Zero values use defaults.
+	Health struct {
+		// Timeout for running all checks.
+		CheckTimeout time.Duration `yaml:"check_timeout"`
+		// Minimum connected peers.
+		MinPeers int `yaml:"min_peers"`
+		// Fail when TLS certificate expires within the threshold.
+		CertExpiryThreshold time.Duration `yaml:"cert_expiry_threshold"`
+		// Maximum usage logs pending delivery to Spaceport.
+		MaxReportBacklog int `yaml:"max_report_backlog"`
+		// Minimum free disk space for the repo.
+		MinDiskFreeMBytes int `yaml:"min_disk_free_mbytes"`
+	} `yaml:"health"`
+
 	Discovery struct {
 		PublicHost string `yaml:"public_host"`
 		PublicPort int    `yaml:"public_port"`
@@ -3,13 +3,18 @@ package node
 import (
 	"context"
 
+	"go.uber.org/atomic"
+
 	"github.com/photon-storage/go-gw3/common/http"
+
+	"github.com/photon-storage/falcon/node/handlers"
 )
 
 const (
-	ctxArgsKey  = "ctx_args"
-	ctxNoAuth   = "ctx_noauth"
-	ctxNoReport = "ctx_noreport"
+	ctxArgsKey      = "ctx_args"
+	ctxNoAuth       = "ctx_noauth"
+	ctxNoReport     = "ctx_noreport"
+	ctxNetworkFetch = "ctx_network_fetch"
 )
 
 func WithArgs(ctx context.Context, args *http.Args) context.Context {
@@ -59,3 +64,31 @@ func GetNoReportFromCtx(ctx context.Context) bool {
 	}
 	return noreport
 }
+
+// WithRequestID tags the request ID shared with handlers.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return handlers.WithRequestID(ctx, id)
+}
+
+func GetRequestIDFromCtx(ctx context.Context) string {
+	return handlers.GetRequestIDFromCtx(ctx)
+}
+
+// WithNetworkFetch attaches a flag which is set when any block requested
+// within the context is fetched from the network instead of the local
+// blockstore.
+func WithNetworkFetch(ctx context.Context, v *atomic.Bool) context.Context {
+	return context.WithValue(ctx, ctxNetworkFetch, v)
+}
+
+func GetNetworkFetchFromCtx(ctx context.Context) *atomic.Bool {
+	v := ctx.Value(ctxNetworkFetch)
+	if v == nil {
+		return nil
+	}
+	fetched, ok := v.(*atomic.Bool)
+	if !ok {
+		return nil
+	}
+	return fetched
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"testing"
 
+	"go.uber.org/atomic"
+
 	"github.com/photon-storage/go-common/testing/require"
 	"github.com/photon-storage/go-gw3/common/http"
 )
@@ -26,4 +28,14 @@ func TestContext(t *testing.T) {
 	require.False(t, GetNoReportFromCtx(ctx))
 	ctx = WithNoReport(ctx)
 	require.True(t, GetNoReportFromCtx(ctx))
+
+	require.Equal(t, "", GetRequestIDFromCtx(ctx))
+	ctx = WithRequestID(ctx, "mock_id")
+	require.Equal(t, "mock_id", GetRequestIDFromCtx(ctx))
+
+	require.Nil(t, GetNetworkFetchFromCtx(ctx))
+	fetched := atomic.NewBool(false)
+	ctx = WithNetworkFetch(ctx, fetched)
+	GetNetworkFetchFromCtx(ctx).Store(true)
+	require.True(t, fetched.Load())
 }
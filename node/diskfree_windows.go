@@ -0,0 +1,8 @@
+//go:build windows
+// +build windows
+
+package node
+
+func diskFree(_ string) (uint64, error) {
+	return 0, errDiskFreeUnsupported
+}
@@ -0,0 +1,79 @@
+package node
+
+import (
+	"context"
+
+	"github.com/ipfs/boxo/exchange"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+)
+
+var (
+	_ exchange.SessionExchange = (*fetchTracker)(nil)
+)
+
+// fetchTracker wraps the node exchange and marks the request context when
+// any block has to be retrieved from the network. The blockservice only
+// hits the exchange after a local blockstore miss.
+type fetchTracker struct {
+	exchange.Interface
+}
+
+func newFetchTracker(ex exchange.Interface) *fetchTracker {
+	return &fetchTracker{
+		Interface: ex,
+	}
+}
+
+func (t *fetchTracker) GetBlock(
+	ctx context.Context,
+	c cid.Cid,
+) (blocks.Block, error) {
+	markNetworkFetch(ctx)
+	return t.Interface.GetBlock(ctx, c)
+}
+
+func (t *fetchTracker) GetBlocks(
+	ctx context.Context,
+	cids []cid.Cid,
+) (<-chan blocks.Block, error) {
+	markNetworkFetch(ctx)
+	return t.Interface.GetBlocks(ctx, cids)
+}
+
+func (t *fetchTracker) NewSession(ctx context.Context) exchange.Fetcher {
+	if sx, ok := t.Interface.(exchange.SessionExchange); ok {
+		return &trackedFetcher{
+			Fetcher: sx.NewSession(ctx),
+		}
+	}
+	return &trackedFetcher{
+		Fetcher: t.Interface,
+	}
+}
+
+type trackedFetcher struct {
+	exchange.Fetcher
+}
+
+func (f *trackedFetcher) GetBlock(
+	ctx context.Context,
+	c cid.Cid,
+) (blocks.Block, error) {
+	markNetworkFetch(ctx)
+	return f.Fetcher.GetBlock(ctx, c)
+}
+
+func (f *trackedFetcher) GetBlocks(
+	ctx context.Context,
+	cids []cid.Cid,
+) (<-chan blocks.Block, error) {
+	markNetworkFetch(ctx)
+	return f.Fetcher.GetBlocks(ctx, cids)
+}
+
+func markNetworkFetch(ctx context.Context) {
+	if fetched := GetNetworkFetchFromCtx(ctx); fetched != nil {
+		fetched.Store(true)
+	}
+}
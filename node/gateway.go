@@ -23,12 +23,14 @@ import (
 	"github.com/photon-storage/go-common/log"
 	"github.com/photon-storage/go-gw3/common/http"
 
+	"github.com/photon-storage/falcon/node/com"
 	"github.com/photon-storage/falcon/node/config"
 	"github.com/photon-storage/falcon/node/handlers"
 )
 
 const (
 	apiPrefix = "/api/v0"
+	psaPrefix = "/psa"
 )
 
 type serverConfig struct {
@@ -96,6 +98,62 @@ func initFalconGateway(
 	}
 	report := newMonitorHandler(coreapi)
 
+	var dagCache *handlers.DagStatsCache
+	if !cfg.DagStats.DisableCache {
+		dagCache = handlers.NewDagStatsCache(
+			nd.Repo.Datastore(),
+			coreapi,
+			com.GetRcPinner(nd.Pinning),
+		)
+		dagCache.Start(
+			ctx,
+			cfg.DagStats.SweepInterval,
+			cfg.DagStats.Precompute,
+		)
+	}
+
+	var quotas *handlers.Quotas
+	if cfg.Quotas.Enabled {
+		accounts := map[string]handlers.QuotaLimit{}
+		for acct, l := range cfg.Quotas.Accounts {
+			accounts[acct] = handlers.QuotaLimit(l)
+		}
+		quotas = handlers.NewQuotas(
+			nd.Repo.Datastore(),
+			handlers.QuotaLimit(cfg.Quotas.Default),
+			accounts,
+		)
+	}
+
+	jobs := handlers.NewPinJobQueue(
+		nd.Repo.Datastore(),
+		coreapi,
+		reportPinJob,
+		cfg.PinJobs.Workers,
+		cfg.PinJobs.Retention,
+	)
+	ttl := handlers.NewPinTTL(nd.Repo.Datastore(), coreapi, reportPinExpiry)
+	ttl.SetDagStatsCache(dagCache)
+	ttl.SetPinner(com.GetRcPinner(nd.Pinning))
+	ttl.SetQuotas(quotas)
+	ttl.Start(ctx, cfg.PinTTL.SweepInterval)
+
+	jobs.SetDagStatsCache(dagCache)
+	jobs.SetPinTTL(ttl)
+	jobs.SetPinner(com.GetRcPinner(nd.Pinning))
+	jobs.SetQuotas(quotas)
+	psa := handlers.NewPinService(nd.Repo.Datastore(), jobs, nd.Pinning)
+	if err := jobs.Start(ctx); err != nil {
+		return nil, err
+	}
+
+	verifier := handlers.NewPinVerifier(
+		nd.Blockstore,
+		nd.DAG,
+		com.GetRcPinner(nd.Pinning),
+	)
+	verifier.Start(ctx, cfg.PinVerify.Interval, cfg.PinVerify.Repair)
+
 	opts := []corehttp.ServeOption{
 		// The order of options is important. apiOption and hostnameOption
 		// share the same mux. Due to the matching rule, /status, /api/v0
@@ -104,7 +162,19 @@ func initFalconGateway(
 		// handles /ipfs or subdomain requests. The subdomain requests are
 		// reformated to /ipfs and handled by the next mux registered by
 		// the gatewayOption.
-		apiOption(cctx, gwCfg, coreapi, auth, report),
+		apiOption(
+			cctx,
+			gwCfg,
+			coreapi,
+			jobs,
+			psa,
+			dagCache,
+			ttl,
+			quotas,
+			verifier,
+			auth,
+			report,
+		),
 		hostnameOption(cctx, gwCfg, auth, report),
 		gatewayOption(cctx, coreapi, gwCfg, auth, report),
 		corehttp.VersionOption(),
@@ -136,6 +206,12 @@ func apiOption(
 	cctx *oldcmds.Context,
 	gwCfg gateway.Config,
 	coreapi coreiface.CoreAPI,
+	jobs *handlers.PinJobQueue,
+	psa *handlers.PinService,
+	dagCache *handlers.DagStatsCache,
+	ttl *handlers.PinTTL,
+	quotas *handlers.Quotas,
+	verifier *handlers.PinVerifier,
 	auth *authHandler,
 	report *monitorHandler,
 ) corehttp.ServeOption {
@@ -146,6 +222,13 @@ func apiOption(
 	) (*gohttp.ServeMux, error) {
 		apiHandlers := buildApiHandler(*cctx, lis)
 		extHandlers := handlers.New(nd, coreapi, apiHandlers)
+		extHandlers.SetPinJobQueue(jobs)
+		extHandlers.SetPinService(psa)
+		extHandlers.SetDagStatsCache(dagCache)
+		extHandlers.SetPinTTL(ttl)
+		extHandlers.SetQuotas(quotas)
+		extHandlers.SetPinVerifier(verifier)
+		registerHealthChecks(extHandlers, nd, cctx.ConfigRoot)
 
 		mux.Handle("/status", extHandlers.Status())
 		mux.Handle("/status/", extHandlers.Status())
@@ -173,6 +256,15 @@ func apiOption(
 		mux.Handle(apiPrefix+"/pin/rm", auth.wrap(
 			report.wrap(ch(extHandlers.PinRm())),
 		))
+		mux.Handle(apiPrefix+"/pin/add/batch", auth.wrap(
+			report.wrap(ch(extHandlers.PinAddBatch())),
+		))
+		mux.Handle(apiPrefix+"/pin/rm/batch", auth.wrap(
+			report.wrap(ch(extHandlers.PinRmBatch())),
+		))
+		mux.Handle(apiPrefix+"/pin/update", auth.wrap(
+			report.wrap(ch(extHandlers.PinUpdate())),
+		))
 		mux.Handle(apiPrefix+"/pin/children_update", auth.wrap(
 			report.wrap(ch(extHandlers.PinChildrenUpdate())),
 		))
@@ -182,12 +274,43 @@ func apiOption(
 		mux.Handle(apiPrefix+"/pin/count", auth.wrap(
 			report.wrap(ch(extHandlers.PinnedCount())),
 		))
+		mux.Handle(apiPrefix+"/pin/verify", auth.wrap(
+			report.wrap(ch(extHandlers.PinVerify())),
+		))
+		mux.Handle(apiPrefix+"/pin/export", auth.wrap(
+			report.wrap(ch(extHandlers.PinExport())),
+		))
+		mux.Handle(apiPrefix+"/pin/import", auth.wrap(
+			report.wrap(ch(extHandlers.PinImport())),
+		))
+		mux.Handle(apiPrefix+"/pin/jobs/status", auth.wrap(
+			report.wrap(ch(extHandlers.PinJobStatus())),
+		))
+		mux.Handle(apiPrefix+"/pin/jobs/cancel", auth.wrap(
+			report.wrap(ch(extHandlers.PinJobCancel())),
+		))
+		mux.Handle(apiPrefix+"/pin/jobs/ls", auth.wrap(
+			report.wrap(ch(extHandlers.PinJobList())),
+		))
+		// IPFS Pinning Service API with endpoint at /api/v0/psa.
+		mux.Handle(apiPrefix+psaPrefix+"/pins", auth.wrap(
+			report.wrap(ch(extHandlers.PinService())),
+		))
+		mux.Handle(apiPrefix+psaPrefix+"/pins/", auth.wrap(
+			report.wrap(ch(extHandlers.PinService())),
+		))
 		mux.Handle(apiPrefix+"/name/broadcast", auth.wrap(
 			report.wrap(ch(extHandlers.NameBroadcast())),
 		))
 		mux.Handle(apiPrefix+"/dag/import", auth.wrap(
 			report.wrap(ch(extHandlers.DagImport())),
 		))
+		mux.Handle(apiPrefix+"/dag/import/stream", auth.wrap(
+			report.wrap(ch(extHandlers.DagImportStream())),
+		))
+		mux.Handle(apiPrefix+"/dag/stats", auth.wrap(
+			report.wrap(ch(extHandlers.DagStats())),
+		))
 
 		return mux, nil
 	}
@@ -90,7 +90,13 @@ func newGatewayBackend(n *core.IpfsNode) (gateway.IPFSBackend, error) {
 		return nil, err
 	}
 
-	bserv := n.Blocks
+	//////////////////// Falcon ////////////////////
+	// Track network fetches for usage reporting.
+	bserv := blockservice.New(
+		n.Blocks.Blockstore(),
+		newFetchTracker(n.Exchange),
+	)
+	//////////////////// Falcon ////////////////////
 	var vsRouting routing.ValueStore = n.Routing
 	nsys := n.Namesys
 	if cfg.Gateway.NoFetch {
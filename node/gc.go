@@ -0,0 +1,100 @@
+package node
+
+import (
+	"context"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	core "github.com/ipfs/kubo/core"
+	"github.com/ipfs/kubo/core/corerepo"
+
+	"github.com/photon-storage/go-common/log"
+	"github.com/photon-storage/go-common/metrics"
+)
+
+// PeriodicGC mirrors corerepo.PeriodicGC with GC metrics collected.
+// Kubo does not expose GC results from its periodic loop, which
+// is replaced in daemon.go.
+func PeriodicGC(ctx context.Context, nd *core.IpfsNode) error {
+	cfg, err := nd.Repo.Config()
+	if err != nil {
+		return err
+	}
+
+	if cfg.Datastore.GCPeriod == "" {
+		cfg.Datastore.GCPeriod = "1h"
+	}
+
+	period, err := time.ParseDuration(cfg.Datastore.GCPeriod)
+	if err != nil {
+		return err
+	}
+	if int64(period) == 0 {
+		// if duration is 0, it means GC is disabled.
+		return nil
+	}
+
+	gc, err := corerepo.NewGC(nd)
+	if err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case <-time.After(period):
+			if err := maybeGC(ctx, gc); err != nil {
+				log.Error("Error running repo GC", "error", err)
+			}
+		}
+	}
+}
+
+func maybeGC(ctx context.Context, gc *corerepo.GC) error {
+	before, err := gc.Repo.GetStorageUsage(ctx)
+	if err != nil {
+		return err
+	}
+
+	if before <= gc.StorageGC {
+		return nil
+	}
+	if before > gc.StorageMax {
+		log.Warn("Pre-GC storage usage exceeds max",
+			"usage", before,
+			"max", gc.StorageMax,
+		)
+	}
+
+	log.Info("Watermark exceeded. Starting repo GC...")
+	metrics.CounterInc("gc_runs_total")
+
+	removed := 0
+	if err := corerepo.CollectResult(
+		ctx,
+		corerepo.GarbageCollectAsync(gc.Node, ctx),
+		func(cid.Cid) { removed++ },
+	); err != nil {
+		metrics.CounterInc("gc_err_total")
+		return err
+	}
+	metrics.CounterAdd("gc_removed_blocks_total", float64(removed))
+
+	after, err := gc.Repo.GetStorageUsage(ctx)
+	if err != nil {
+		return err
+	}
+	if after < before {
+		metrics.CounterAdd("gc_reclaimed_bytes", float64(before-after))
+	}
+
+	log.Info("Repo GC done",
+		"removed_blocks", removed,
+		"storage_before", before,
+		"storage_after", after,
+	)
+
+	return nil
+}
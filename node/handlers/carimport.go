@@ -0,0 +1,389 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	gohttp "net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ipfs/boxo/blockservice"
+	coreiface "github.com/ipfs/boxo/coreiface"
+	"github.com/ipfs/boxo/coreiface/options"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	carv2 "github.com/ipld/go-car/v2"
+
+	"github.com/photon-storage/go-common/log"
+	"github.com/photon-storage/go-gw3/common/http"
+
+	"github.com/photon-storage/falcon/node/com"
+	"github.com/photon-storage/falcon/node/tracing"
+)
+
+const (
+	carImportBatchSize = 256
+)
+
+var (
+	ErrInvalidCAR         = errors.New("invalid CAR")
+	ErrImportSizeExceeded = errors.New("import size cap exceeded")
+	ErrIncompleteDAG      = errors.New("incomplete DAG")
+)
+
+// CarImportResult reports blocks imported and a result per root. Success
+// is true only if all roots are pinned.
+type CarImportResult struct {
+	Success   bool               `json:"success"`
+	NumBlocks int64              `json:"num_blocks"`
+	Size      int64              `json:"size"`
+	Roots     []*DagImportResult `json:"roots"`
+	Message   string             `json:"message"`
+}
+
+// carImporter streams blocks of CAR files into the block service. Roots
+// of all files are collected in order for pinning once all files are
+// imported, as a root may link blocks of another file.
+type carImporter struct {
+	bserv     blockservice.BlockService
+	limit     int64
+	numBlocks int64
+	size      int64
+	roots     []cid.Cid
+	seen      *cid.Set
+}
+
+func newCarImporter(bserv blockservice.BlockService, limit int64) *carImporter {
+	return &carImporter{
+		bserv: bserv,
+		limit: limit,
+		seen:  cid.NewSet(),
+	}
+}
+
+// importCAR reads a CARv1 or CARv2 stream. Each block is verified against
+// its CID. Import stops once block bytes exceed the limit. Blocks written
+// before a failure are left unpinned for GC.
+func (im *carImporter) importCAR(ctx context.Context, r io.Reader) error {
+	br, err := carv2.NewBlockReader(r, carv2.WithTrustedCAR(false))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidCAR, err)
+	}
+	for _, c := range br.Roots {
+		if im.seen.Visit(c) {
+			im.roots = append(im.roots, c)
+		}
+	}
+
+	var batch []blocks.Block
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		blk, err := br.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidCAR, err)
+		}
+
+		im.size += int64(len(blk.RawData()))
+		if im.limit > 0 && im.size > im.limit {
+			return ErrImportSizeExceeded
+		}
+		im.numBlocks++
+
+		batch = append(batch, blk)
+		if len(batch) >= carImportBatchSize {
+			if err := im.bserv.AddBlocks(ctx, batch); err != nil {
+				return err
+			}
+			batch = nil
+		}
+	}
+	if len(batch) > 0 {
+		return im.bserv.AddBlocks(ctx, batch)
+	}
+	return nil
+}
+
+// pinRoots pins each root imported if its DAG is complete locally and
+// returns stats of pinned roots. A root with missing blocks fails alone.
+// On any other error, roots pinned are rolled back.
+func (im *carImporter) pinRoots(
+	ctx context.Context,
+	api coreiface.CoreAPI,
+	cache *DagStatsCache,
+	pinner *com.WrappedPinner,
+) ([]*DagImportResult, []*DagStats, error) {
+	var results []*DagImportResult
+	var stats []*DagStats
+	var pinned []cid.Cid
+	rollback := func() {
+		// Roll back even if the request is canceled.
+		for _, c := range pinned {
+			if err := pinner.Unpin(context.Background(), c, true); err != nil {
+				log.Error("Error rolling back imported root",
+					"error", err,
+					"cid", c.String(),
+				)
+			}
+		}
+	}
+
+	for _, c := range im.roots {
+		res := &DagImportResult{
+			Cid: c.String(),
+		}
+		results = append(results, res)
+
+		// The pin walks the DAG offline whether or not its stats are
+		// cached, so missing blocks are never fetched from the network.
+		if err := pinner.PinWithDAG(ctx, c, api.Dag()); err != nil {
+			if ctx.Err() != nil {
+				rollback()
+				return nil, nil, ctx.Err()
+			}
+			if ipld.IsNotFound(err) {
+				res.Message = fmt.Sprintf("%v: %v", ErrIncompleteDAG, err)
+				stats = append(stats, nil)
+				continue
+			}
+			rollback()
+			return nil, nil, fmt.Errorf("error pinning %v: %w", c, err)
+		}
+		pinned = append(pinned, c)
+
+		ds := NewDagStats()
+		if err := cache.Calculate(ctx, api, c, true, ds); err != nil {
+			rollback()
+			return nil, nil, fmt.Errorf(
+				"error calculating dag stats of %v: %w",
+				c,
+				err,
+			)
+		}
+
+		res.Success = true
+		res.DeduplicatedSize = ds.DeduplicatedSize.Load()
+		res.DeduplicatedNumBlocks = ds.DeduplicatedNumBlocks.Load()
+		res.TotalSize = ds.TotalSize.Load()
+		res.TotalNumBlocks = ds.TotalNumBlocks.Load()
+		res.UnixFSStats = newUnixFSStats(ds)
+		stats = append(stats, ds)
+	}
+
+	if err := pinner.Flush(ctx); err != nil {
+		rollback()
+		return nil, nil, err
+	}
+	return results, stats, nil
+}
+
+// DagImportStream imports CAR files natively. The body is a CAR file or
+// multipart form of CAR files. Blocks are streamed into the repo and
+// import stops at the signed size cap or the remaining account quota.
+// Roots are pinned recursively after all files are imported and each
+// pinned root is accounted with its DAG stats.
+func (h *ExtendedHandlers) DagImportStream() gohttp.HandlerFunc {
+	return gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		ctx, span := tracing.Span(r.Context(), "Handlers", "DagImportStream")
+		defer span.End()
+
+		args, err := parseSignedArgs(r)
+		if err != nil {
+			writeJSON(
+				w,
+				gohttp.StatusBadRequest,
+				&CarImportResult{
+					Success: false,
+					Message: fmt.Sprintf("error parsing params: %v", err),
+				},
+			)
+			return
+		}
+
+		meta, err := parsePinMeta(r, args)
+		if err != nil {
+			writeJSON(
+				w,
+				gohttp.StatusBadRequest,
+				&CarImportResult{
+					Success: false,
+					Message: fmt.Sprintf("error parsing params: %v", err),
+				},
+			)
+			return
+		}
+
+		pinner := com.GetRcPinner(h.nd.Pinning)
+		if pinner == nil {
+			writeJSON(
+				w,
+				gohttp.StatusNotImplemented,
+				&CarImportResult{
+					Success: false,
+					Message: "CAR import requires the rc pinner",
+				},
+			)
+			return
+		}
+
+		account := args.GetArg(http.ArgP3AcctID)
+		reserved, remaining, err := h.quotas.Check(ctx, account, 1)
+		defer func() {
+			reserved.release()
+		}()
+		if err != nil {
+			writeJSON(
+				w,
+				quotaErrorCode(err),
+				&CarImportResult{
+					Success: false,
+					Message: fmt.Sprintf("error checking quota: %v", err),
+				},
+			)
+			return
+		}
+
+		// An invalid size cap is ignored as in usage reporting.
+		limit, _ := strconv.ParseInt(args.GetArg(http.ArgP3Size), 10, 64)
+		quotaLimited := false
+		if remaining >= 0 && (limit <= 0 || remaining < limit) {
+			limit = remaining
+			quotaLimited = true
+		}
+
+		// Keep GC from collecting blocks before roots are pinned.
+		defer h.nd.Blockstore.PinLock(ctx).Unlock(ctx)
+
+		body := &countingReader{r: r.Body}
+		im := newCarImporter(h.nd.Blocks, limit)
+		err = h.importCARs(ctx, r, body, im)
+		if errors.Is(err, ErrInvalidCAR) && limit > 0 && body.n > limit {
+			// The body is cut by the ingress cap.
+			err = ErrImportSizeExceeded
+		}
+		if errors.Is(err, ErrImportSizeExceeded) && quotaLimited {
+			err = ErrQuotaExceeded
+		}
+		if err == nil {
+			// Reserve all roots and imported bytes for pinning.
+			reserved.release()
+			reserved, _, err = h.quotas.Check(
+				ctx,
+				account,
+				int64(len(im.roots)),
+			)
+			if err == nil {
+				err = reserved.reserve(ctx, im.size)
+			}
+		}
+
+		var results []*DagImportResult
+		var stats []*DagStats
+		if err == nil {
+			var api coreiface.CoreAPI
+			if api, err = h.api.WithOptions(options.Api.Offline(true)); err == nil {
+				results, stats, err = im.pinRoots(ctx, api, h.dagCache, pinner)
+			}
+		}
+		if err != nil {
+			code := gohttp.StatusInternalServerError
+			switch {
+			case errors.Is(err, ErrInvalidCAR):
+				code = gohttp.StatusBadRequest
+			case errors.Is(err, ErrImportSizeExceeded):
+				code = gohttp.StatusRequestEntityTooLarge
+			case errors.Is(err, ErrQuotaExceeded):
+				code = gohttp.StatusInsufficientStorage
+			}
+			writeJSON(
+				w,
+				code,
+				&CarImportResult{
+					Success:   false,
+					NumBlocks: im.numBlocks,
+					Size:      im.size,
+					Message:   fmt.Sprintf("error importing: %v", err),
+				},
+			)
+			return
+		}
+
+		res := &CarImportResult{
+			Success:   true,
+			NumBlocks: im.numBlocks,
+			Size:      im.size,
+			Roots:     results,
+			Message:   "ok",
+		}
+		aggrDs := getDagStatsFromCtx(ctx)
+		for i, ds := range stats {
+			if ds == nil {
+				res.Success = false
+				res.Message = "some roots failed"
+				continue
+			}
+			if aggrDs != nil {
+				aggrDs.Add(ds)
+			}
+			h.quotas.record(ctx, account, ds, false)
+			addPinMeta(ctx, pinner, im.roots[i], true, meta)
+		}
+		writeJSON(w, gohttp.StatusOK, res)
+	})
+}
+
+// importCARs imports the body as a single CAR, or each file part of a
+// multipart body.
+func (h *ExtendedHandlers) importCARs(
+	ctx context.Context,
+	r *gohttp.Request,
+	body *countingReader,
+	im *carImporter,
+) error {
+	mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if !strings.HasPrefix(mt, "multipart/") {
+		return im.importCAR(ctx, body)
+	}
+
+	r.Body = io.NopCloser(body)
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidCAR, err)
+	}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidCAR, err)
+		}
+		if part.FileName() == "" {
+			continue
+		}
+		if err := im.importCAR(ctx, part); err != nil {
+			return err
+		}
+	}
+}
+
+// countingReader counts bytes read from the request body.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime/multipart"
+	gohttp "net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	bs "github.com/ipfs/boxo/blockservice"
+	"github.com/ipfs/boxo/blockstore"
+	"github.com/ipfs/boxo/exchange/offline"
+	"github.com/ipfs/boxo/ipld/merkledag"
+	"github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipfs/kubo/core"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/storage"
+
+	"github.com/photon-storage/go-common/testing/require"
+	"github.com/photon-storage/go-gw3/common/http"
+	rcpinner "github.com/photon-storage/go-rc-pinner"
+
+	"github.com/photon-storage/falcon/node/com"
+)
+
+func TestDagImportStream(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	bstore := blockstore.NewBlockstore(dstore)
+	bserv := bs.New(bstore, offline.Exchange(bstore))
+	dserv := merkledag.NewDAGService(bserv)
+	rcp, err := rcpinner.New(ctx, dstore, dserv)
+	require.NoError(t, err)
+	pinner := &com.WrappedPinner{
+		Pinner:    rcp,
+		Datastore: dstore,
+	}
+
+	h := New(
+		&core.IpfsNode{
+			Pinning: pinner,
+			Blockstore: blockstore.NewGCBlockstore(
+				bstore,
+				blockstore.NewGCLocker(),
+			),
+			Blocks: bserv,
+		},
+		&mockAPI{
+			dag: &mockAPIDag{
+				DAGService: dserv,
+			},
+		},
+		nil,
+	)
+
+	// CAR of roots A{B} and C{D} without D.
+	a := rndNode(t)
+	b := rndNode(t)
+	c := rndNode(t)
+	d := rndNode(t)
+	require.NoError(t, a.AddNodeLink("c0", b))
+	require.NoError(t, c.AddNodeLink("c0", d))
+	writeCAR := func(roots []cid.Cid, nodes ...*merkledag.ProtoNode) []byte {
+		var buf bytes.Buffer
+		car, err := storage.NewWritable(
+			&buf,
+			roots,
+			carv2.WriteAsCarV1(true),
+		)
+		require.NoError(t, err)
+		for _, nd := range nodes {
+			require.NoError(t, car.Put(ctx, nd.Cid().KeyString(), nd.RawData()))
+		}
+		require.NoError(t, car.Finalize())
+		return buf.Bytes()
+	}
+	data := writeCAR([]cid.Cid{a.Cid(), c.Cid()}, a, b, c)
+
+	call := func(
+		body []byte,
+		contentType string,
+		size int64,
+		stats *DagStats,
+	) (int, *CarImportResult) {
+		args := http.NewArgs().SetArg(http.ArgP3AcctID, "acct")
+		if size > 0 {
+			args.SetArg(http.ArgP3Size, strconv.FormatInt(size, 10))
+		}
+		r := httptest.NewRequest(
+			gohttp.MethodPost,
+			"/api/v0/dag/import/stream?"+http.ParamP3Args+"="+args.Encode(),
+			bytes.NewReader(body),
+		)
+		r.Header.Set("Content-Type", contentType)
+		r = r.WithContext(WithDagStat(r.Context(), stats))
+		w := httptest.NewRecorder()
+		h.DagImportStream().ServeHTTP(w, r)
+		var res CarImportResult
+		decodeResp(t, w, &res)
+		return w.Code, &res
+	}
+	count := func(nd *merkledag.ProtoNode) uint16 {
+		cnt, err := pinner.GetCount(ctx, nd.Cid(), true)
+		require.NoError(t, err)
+		return cnt
+	}
+
+	// Stops at the size cap before any root is pinned.
+	code, res := call(data, mediaTypeCAR, totalSize(a), nil)
+	require.Equal(t, gohttp.StatusRequestEntityTooLarge, code)
+	require.False(t, res.Success)
+	require.Equal(t, uint16(0), count(a))
+
+	// Corrupted block data fails hash verification.
+	bad := bytes.Replace(data, b.Data(), make([]byte, len(b.Data())), 1)
+	code, res = call(bad, mediaTypeCAR, 0, nil)
+	require.Equal(t, gohttp.StatusBadRequest, code)
+	require.False(t, res.Success)
+
+	stats := NewDagStats()
+	code, res = call(data, mediaTypeCAR, 0, stats)
+	require.Equal(t, gohttp.StatusOK, code)
+	require.False(t, res.Success)
+	require.Equal(t, int64(3), res.NumBlocks)
+	require.Equal(t, totalSize(a, b, c), res.Size)
+	require.Equal(t, 2, len(res.Roots))
+	require.True(t, res.Roots[0].Success)
+	require.Equal(t, a.Cid().String(), res.Roots[0].Cid)
+	require.Equal(t, totalSize(a, b), res.Roots[0].TotalSize)
+	require.False(t, res.Roots[1].Success)
+	require.Equal(t, int64(1), stats.TotalCount.Load())
+	require.Equal(t, totalSize(a, b), stats.TotalSize.Load())
+	require.Equal(t, uint16(1), count(a))
+	require.Equal(t, uint16(0), count(c))
+
+	// D is imported as a CARv2 in a second file of a multipart body, so
+	// both roots are complete.
+	var v2 bytes.Buffer
+	require.NoError(t, carv2.WrapV1(
+		bytes.NewReader(writeCAR([]cid.Cid{d.Cid()}, d)),
+		&v2,
+	))
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for i, car := range [][]byte{data, v2.Bytes()} {
+		fw, err := mw.CreateFormFile("file", strconv.Itoa(i)+".car")
+		require.NoError(t, err)
+		_, err = io.Copy(fw, bytes.NewReader(car))
+		require.NoError(t, err)
+	}
+	require.NoError(t, mw.Close())
+
+	stats = NewDagStats()
+	code, res = call(body.Bytes(), mw.FormDataContentType(), 0, stats)
+	require.Equal(t, gohttp.StatusOK, code)
+	require.True(t, res.Success)
+	require.Equal(t, int64(4), res.NumBlocks)
+	require.Equal(t, 3, len(res.Roots))
+	require.Equal(t, int64(3), stats.TotalCount.Load())
+	require.Equal(t, uint16(2), count(a))
+	require.Equal(t, uint16(1), count(c))
+	require.Equal(t, uint16(1), count(d))
+
+	// E{F} has stats cached while F is only available from the network.
+	// F is not fetched as the DAG is checked offline.
+	e := rndNode(t)
+	f := rndNode(t)
+	require.NoError(t, e.AddNodeLink("c0", f))
+	netBstore := blockstore.NewBlockstore(
+		dssync.MutexWrap(ds.NewMapDatastore()),
+	)
+	netDserv := merkledag.NewDAGService(
+		bs.New(netBstore, offline.Exchange(netBstore)),
+	)
+	require.NoError(t, netDserv.Add(ctx, e))
+	require.NoError(t, netDserv.Add(ctx, f))
+	pinner.DAG = netDserv
+	cache := NewDagStatsCache(dstore, nil, pinner)
+	require.NoError(t, cache.Calculate(
+		ctx,
+		&mockAPI{
+			dag: &mockAPIDag{
+				DAGService: netDserv,
+			},
+		},
+		e.Cid(),
+		true,
+		NewDagStats(),
+	))
+	h.SetDagStatsCache(cache)
+
+	code, res = call(writeCAR([]cid.Cid{e.Cid()}, e), mediaTypeCAR, 0, nil)
+	require.Equal(t, gohttp.StatusOK, code)
+	require.False(t, res.Success)
+	require.False(t, res.Roots[0].Success)
+	require.Equal(t, uint16(0), count(e))
+	has, err := bstore.Has(ctx, f.Cid())
+	require.NoError(t, err)
+	require.False(t, has)
+}
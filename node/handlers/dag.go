@@ -1,12 +1,296 @@
 package handlers
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
 	gohttp "net/http"
+	"strconv"
+	"strings"
+
+	coreiface "github.com/ipfs/boxo/coreiface"
+	"github.com/ipfs/boxo/coreiface/options"
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	dagcmd "github.com/ipfs/kubo/core/commands/dag"
+
+	"github.com/photon-storage/go-common/log"
+	"github.com/photon-storage/go-gw3/common/http"
+
+	"github.com/photon-storage/falcon/node/com"
+	"github.com/photon-storage/falcon/node/tracing"
 )
 
+type dagImportRespHandler struct {
+	statusCode int
+	api        coreiface.CoreAPI
+	dagStats   *DagStats
+	cache      *DagStatsCache
+	pinner     *com.WrappedPinner
+	meta       *com.PinMeta
+	quotas     *Quotas
+	account    string
+}
+
+func (h *dagImportRespHandler) status(statusCode int) {
+	if h.statusCode != 0 {
+		h.statusCode = statusCode
+	}
+}
+
+// update converts each root pinned by Kubo to a result with its DAG
+// stats, which are added to the request DagStats. Import stats are
+// converted as well. Other responses are passed through.
+func (h *dagImportRespHandler) update(
+	ctx context.Context,
+	data []byte,
+) ([]byte, error) {
+	var val dagcmd.CarImportOutput
+	if err := json.Unmarshal(data, &val); err != nil {
+		return data, nil
+	}
+
+	var res interface{}
+	switch {
+	case val.Root != nil:
+		res = h.result(ctx, val.Root)
+	case val.Stats != nil:
+		res = &DagImportStatsResult{
+			ImportedNumBlocks: val.Stats.BlockCount,
+			ImportedSize:      val.Stats.BlockBytesCount,
+		}
+	default:
+		return data, nil
+	}
+
+	out, err := json.Marshal(res)
+	if err != nil {
+		return nil, err
+	}
+	return append(out, '\n'), nil
+}
+
+func (h *dagImportRespHandler) result(
+	ctx context.Context,
+	root *dagcmd.RootMeta,
+) *DagImportResult {
+	if root.PinErrorMsg != "" {
+		return &DagImportResult{
+			Success: false,
+			Cid:     root.Cid.String(),
+			Message: root.PinErrorMsg,
+		}
+	}
+
+	ds := NewDagStats()
+	if err := h.cache.Calculate(
+		ctx,
+		h.api,
+		root.Cid,
+		true,
+		ds,
+	); err != nil {
+		// Ignore stats error.
+		log.Error("Error calculating dag stats",
+			"error", err,
+			"cid", root.Cid.String(),
+			"source", "dag import",
+		)
+	}
+	if h.dagStats != nil {
+		h.dagStats.Add(ds)
+	}
+	h.quotas.record(ctx, h.account, ds, false)
+	addPinMeta(ctx, h.pinner, root.Cid, true, h.meta)
+
+	return &DagImportResult{
+		Success:               true,
+		Cid:                   root.Cid.String(),
+		DeduplicatedSize:      ds.DeduplicatedSize.Load(),
+		DeduplicatedNumBlocks: ds.DeduplicatedNumBlocks.Load(),
+		TotalSize:             ds.TotalSize.Load(),
+		TotalNumBlocks:        ds.TotalNumBlocks.Load(),
+		UnixFSStats:           newUnixFSStats(ds),
+	}
+}
+
+// DagImportResult is the result of pinning a CAR root.
+type DagImportResult struct {
+	Success               bool   `json:"success"`
+	Cid                   string `json:"cid"`
+	DeduplicatedSize      int64  `json:"duplicated_size"`
+	DeduplicatedNumBlocks int64  `json:"duplicated_num_blocks"`
+	TotalSize             int64  `json:"total_size"`
+	TotalNumBlocks        int64  `json:"total_num_blocks"`
+	UnixFSStats
+	Message string `json:"message"`
+}
+
+// DagImportStatsResult is returned after root results if stats are
+// requested.
+type DagImportStatsResult struct {
+	ImportedNumBlocks uint64 `json:"imported_num_blocks"`
+	ImportedSize      uint64 `json:"imported_size"`
+}
+
+// DagImport imports CAR files with Kubo. Each root pinned is accounted
+// as a recursive pin of the signed account and returned with its DAG
+// stats, one result per line.
 func (h *ExtendedHandlers) DagImport() gohttp.HandlerFunc {
 	return gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
-		// TODO(kmax): intercept response and handle pin success reporting
-		h.apiHandlers.ServeHTTP(w, r)
+		ctx, span := tracing.Span(r.Context(), "Handlers", "DagImport")
+		defer span.End()
+		r = r.WithContext(ctx)
+
+		args, err := parseSignedArgs(r)
+		if err != nil {
+			writeJSON(
+				w,
+				gohttp.StatusBadRequest,
+				&DagImportResult{
+					Success: false,
+					Message: fmt.Sprintf("error parsing params: %v", err),
+				},
+			)
+			return
+		}
+
+		meta, err := parsePinMeta(r, args)
+		if err != nil {
+			writeJSON(
+				w,
+				gohttp.StatusBadRequest,
+				&DagImportResult{
+					Success: false,
+					Message: fmt.Sprintf("error parsing params: %v", err),
+				},
+			)
+			return
+		}
+
+		h.apiHandlers.ServeHTTP(
+			newResponseWriter(
+				r.Context(),
+				w,
+				&dagImportRespHandler{
+					api:      h.api,
+					dagStats: getDagStatsFromCtx(r.Context()),
+					cache:    h.dagCache,
+					pinner:   com.GetRcPinner(h.nd.Pinning),
+					meta:     meta,
+					quotas:   h.quotas,
+					account:  args.GetArg(http.ArgP3AcctID),
+				},
+			),
+			r,
+		)
 	})
 }
+
+type DagStatsResult struct {
+	Success               bool   `json:"success"`
+	Cid                   string `json:"cid"`
+	Recursive             bool   `json:"recursive"`
+	DeduplicatedSize      int64  `json:"duplicated_size"`
+	DeduplicatedNumBlocks int64  `json:"duplicated_num_blocks"`
+	TotalSize             int64  `json:"total_size"`
+	TotalNumBlocks        int64  `json:"total_num_blocks"`
+	UnixFSStats
+	Message string `json:"message"`
+}
+
+// DagStats returns block and UnixFS stats of a DAG. Recursive defaults to
+// true. Stats are served from the DAG stats cache if enabled. Only local
+// blocks are walked, so stats of an incomplete DAG are not found.
+func (h *ExtendedHandlers) DagStats() gohttp.HandlerFunc {
+	return gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		ctx, span := tracing.Span(r.Context(), "Handlers", "DagStats")
+		defer span.End()
+
+		c, recursive, err := parseDagStatsParams(r)
+		if err != nil {
+			writeJSON(
+				w,
+				gohttp.StatusBadRequest,
+				&DagStatsResult{
+					Success: false,
+					Message: fmt.Sprintf("error parsing params: %v", err),
+				},
+			)
+			return
+		}
+
+		// Stats are served unmetered, so blocks are never fetched.
+		api, err := h.api.WithOptions(options.Api.Offline(true))
+		if err != nil {
+			writeJSON(
+				w,
+				gohttp.StatusInternalServerError,
+				&DagStatsResult{
+					Success: false,
+					Message: fmt.Sprintf("error creating offline api: %v", err),
+				},
+			)
+			return
+		}
+
+		ds := NewDagStats()
+		if err := h.dagCache.Calculate(
+			ctx,
+			api,
+			c,
+			recursive,
+			ds,
+		); err != nil {
+			code := gohttp.StatusInternalServerError
+			if ipld.IsNotFound(err) {
+				code = gohttp.StatusNotFound
+			}
+			writeJSON(
+				w,
+				code,
+				&DagStatsResult{
+					Success:   false,
+					Cid:       c.String(),
+					Recursive: recursive,
+					Message:   fmt.Sprintf("error calculating dag stats: %v", err),
+				},
+			)
+			return
+		}
+
+		writeJSON(
+			w,
+			gohttp.StatusOK,
+			&DagStatsResult{
+				Success:               true,
+				Cid:                   c.String(),
+				Recursive:             recursive,
+				DeduplicatedSize:      ds.DeduplicatedSize.Load(),
+				DeduplicatedNumBlocks: ds.DeduplicatedNumBlocks.Load(),
+				TotalSize:             ds.TotalSize.Load(),
+				TotalNumBlocks:        ds.TotalNumBlocks.Load(),
+				UnixFSStats:           newUnixFSStats(ds),
+				Message:               "ok",
+			},
+		)
+	})
+}
+
+func parseDagStatsParams(r *gohttp.Request) (cid.Cid, bool, error) {
+	query := r.URL.Query()
+	c, err := cid.Decode(query.Get(http.ParamIPFSArg))
+	if err != nil {
+		return cid.Undef, false, ErrInvalidCID
+	}
+
+	str := strings.TrimSpace(query.Get(http.ParamIPFSRecursive))
+	if str == "" {
+		return c, true, nil
+	}
+	recursive, err := strconv.ParseBool(str)
+	if err != nil {
+		return cid.Undef, false, err
+	}
+	return c, recursive, nil
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	bs "github.com/ipfs/boxo/blockservice"
+	"github.com/ipfs/boxo/blockstore"
+	"github.com/ipfs/boxo/exchange/offline"
+	"github.com/ipfs/boxo/ipld/merkledag"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	dagcmd "github.com/ipfs/kubo/core/commands/dag"
+
+	"github.com/photon-storage/go-common/testing/require"
+)
+
+func TestDagImportRespHandler(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	bstore := blockstore.NewBlockstore(dstore)
+	dserv := merkledag.NewDAGService(bs.New(bstore, offline.Exchange(bstore)))
+
+	// A{B}, C
+	a := rndNode(t)
+	b := rndNode(t)
+	c := rndNode(t)
+	require.NoError(t, a.AddNodeLink("c0", b))
+	require.NoError(t, dserv.Add(ctx, a))
+	require.NoError(t, dserv.Add(ctx, b))
+	require.NoError(t, dserv.Add(ctx, c))
+
+	stats := NewDagStats()
+	h := &dagImportRespHandler{
+		api: &mockAPI{
+			dag: &mockAPIDag{
+				DAGService: dserv,
+			},
+		},
+		dagStats: stats,
+	}
+
+	update := func(v interface{}, res interface{}) {
+		data, err := json.Marshal(v)
+		require.NoError(t, err)
+		data, err = h.update(ctx, data)
+		require.NoError(t, err)
+		require.True(t, strings.HasSuffix(string(data), "\n"))
+		require.NoError(t, json.Unmarshal(data, res))
+	}
+
+	var res DagImportResult
+	update(&dagcmd.CarImportOutput{
+		Root: &dagcmd.RootMeta{Cid: a.Cid()},
+	}, &res)
+	require.True(t, res.Success)
+	require.Equal(t, a.Cid().String(), res.Cid)
+	require.Equal(t, totalSize(a, b), res.TotalSize)
+	require.Equal(t, int64(2), res.TotalNumBlocks)
+
+	res = DagImportResult{}
+	update(&dagcmd.CarImportOutput{
+		Root: &dagcmd.RootMeta{
+			Cid:         c.Cid(),
+			PinErrorMsg: "block was not found locally",
+		},
+	}, &res)
+	require.False(t, res.Success)
+	require.Equal(t, "block was not found locally", res.Message)
+
+	var sres DagImportStatsResult
+	update(&dagcmd.CarImportOutput{
+		Stats: &dagcmd.CarImportStats{
+			BlockCount:      3,
+			BlockBytesCount: 100,
+		},
+	}, &sres)
+	require.Equal(t, uint64(3), sres.ImportedNumBlocks)
+	require.Equal(t, uint64(100), sres.ImportedSize)
+
+	// Only the pinned root is accounted.
+	require.Equal(t, int64(1), stats.TotalCount.Load())
+	require.Equal(t, totalSize(a, b), stats.TotalSize.Load())
+
+	data, err := h.update(
+		ctx,
+		[]byte(`{"Message":"context canceled","Code":0,"Type":"error"}`),
+	)
+	require.NoError(t, err)
+	require.Equal(
+		t,
+		`{"Message":"context canceled","Code":0,"Type":"error"}`,
+		string(data),
+	)
+}
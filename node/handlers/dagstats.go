@@ -2,12 +2,18 @@ package handlers
 
 import (
 	"context"
+	"fmt"
 
 	coreiface "github.com/ipfs/boxo/coreiface"
 	"github.com/ipfs/boxo/ipld/merkledag"
-	"github.com/ipfs/boxo/ipld/merkledag/traverse"
+	"github.com/ipfs/boxo/ipld/unixfs"
 	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/atomic"
+
+	"github.com/photon-storage/falcon/node/tracing"
 )
 
 const (
@@ -30,12 +36,21 @@ func WithDagStat(ctx context.Context, v *DagStats) context.Context {
 	return context.WithValue(ctx, dagStatsCtxKey, v)
 }
 
+// DagStats counts blocks and raw block bytes of a DAG. UnixFS fields are
+// decoded from nodes during traversal. FileSize is the logical size of
+// files and NumShardedDirs counts HAMT-sharded directories, which are
+// also counted in NumDirs. Like TotalSize, they count every path to a
+// node.
 type DagStats struct {
 	TotalCount            *atomic.Int64
 	DeduplicatedSize      *atomic.Int64
 	DeduplicatedNumBlocks *atomic.Int64
 	TotalSize             *atomic.Int64
 	TotalNumBlocks        *atomic.Int64
+	FileSize              *atomic.Int64
+	NumFiles              *atomic.Int64
+	NumDirs               *atomic.Int64
+	NumShardedDirs        *atomic.Int64
 }
 
 func NewDagStats() *DagStats {
@@ -45,6 +60,10 @@ func NewDagStats() *DagStats {
 		DeduplicatedNumBlocks: atomic.NewInt64(0),
 		TotalSize:             atomic.NewInt64(0),
 		TotalNumBlocks:        atomic.NewInt64(0),
+		FileSize:              atomic.NewInt64(0),
+		NumFiles:              atomic.NewInt64(0),
+		NumDirs:               atomic.NewInt64(0),
+		NumShardedDirs:        atomic.NewInt64(0),
 	}
 }
 
@@ -54,6 +73,10 @@ func (d *DagStats) Add(o *DagStats) {
 	d.DeduplicatedNumBlocks.Add(o.DeduplicatedNumBlocks.Load())
 	d.TotalSize.Add(o.TotalSize.Load())
 	d.TotalNumBlocks.Add(o.TotalNumBlocks.Load())
+	d.FileSize.Add(o.FileSize.Load())
+	d.NumFiles.Add(o.NumFiles.Load())
+	d.NumDirs.Add(o.NumDirs.Load())
+	d.NumShardedDirs.Add(o.NumShardedDirs.Load())
 }
 
 func (d *DagStats) Sub(o *DagStats) {
@@ -62,6 +85,39 @@ func (d *DagStats) Sub(o *DagStats) {
 	d.DeduplicatedNumBlocks.Sub(o.DeduplicatedNumBlocks.Load())
 	d.TotalSize.Sub(o.TotalSize.Load())
 	d.TotalNumBlocks.Sub(o.TotalNumBlocks.Load())
+	d.FileSize.Sub(o.FileSize.Load())
+	d.NumFiles.Sub(o.NumFiles.Load())
+	d.NumDirs.Sub(o.NumDirs.Load())
+	d.NumShardedDirs.Sub(o.NumShardedDirs.Load())
+}
+
+// LogicalRatio returns the ratio between logical file bytes and bytes
+// stored, or 0 if nothing is stored.
+func (d *DagStats) LogicalRatio() float64 {
+	stored := d.DeduplicatedSize.Load()
+	if stored <= 0 {
+		return 0
+	}
+	return float64(d.FileSize.Load()) / float64(stored)
+}
+
+// UnixFSStats is the UnixFS part of DAG stats returned by APIs.
+type UnixFSStats struct {
+	FileSize       int64   `json:"file_size"`
+	NumFiles       int64   `json:"num_files"`
+	NumDirs        int64   `json:"num_dirs"`
+	NumShardedDirs int64   `json:"num_sharded_dirs"`
+	LogicalRatio   float64 `json:"logical_ratio"`
+}
+
+func newUnixFSStats(ds *DagStats) UnixFSStats {
+	return UnixFSStats{
+		FileSize:       ds.FileSize.Load(),
+		NumFiles:       ds.NumFiles.Load(),
+		NumDirs:        ds.NumDirs.Load(),
+		NumShardedDirs: ds.NumShardedDirs.Load(),
+		LogicalRatio:   ds.LogicalRatio(),
+	}
 }
 
 func CalculateDagStats(
@@ -70,7 +126,18 @@ func CalculateDagStats(
 	k cid.Cid,
 	recursive bool,
 	stats *DagStats,
-) error {
+) (err error) {
+	ctx, span := tracing.Span(
+		ctx,
+		"Handlers",
+		"CalculateDagStats",
+		trace.WithAttributes(
+			attribute.String("cid", k.String()),
+			attribute.Bool("recursive", recursive),
+		),
+	)
+	defer func() { tracing.EndSpan(span, err) }()
+
 	nodeGetter := merkledag.NewSession(ctx, coreapi.Dag())
 	root, err := nodeGetter.Get(ctx, k)
 	if err != nil {
@@ -84,27 +151,118 @@ func CalculateDagStats(
 		stats.DeduplicatedNumBlocks.Store(1)
 		stats.TotalSize.Store(sz)
 		stats.TotalNumBlocks.Store(1)
+		// The file size is known from its root.
+		countUnixFS(root, roleEntry, stats)
 		return nil
 	}
 
 	seen := cid.NewSet()
-	return traverse.Traverse(root, traverse.Options{
-		DAG:   nodeGetter,
-		Order: traverse.DFSPre,
-		Func: func(st traverse.State) error {
-			sz := int64(len(st.Node.RawData()))
-			if !seen.Has(st.Node.Cid()) {
-				stats.DeduplicatedSize.Add(sz)
-				stats.DeduplicatedNumBlocks.Inc()
-			}
-			seen.Add(st.Node.Cid())
+	var stack []*dagStatsItem
+	visit := func(nd ipld.Node, role dagRole) {
+		sz := int64(len(nd.RawData()))
+		if !seen.Has(nd.Cid()) {
+			stats.DeduplicatedSize.Add(sz)
+			stats.DeduplicatedNumBlocks.Inc()
+		}
+		seen.Add(nd.Cid())
+
+		stats.TotalSize.Add(sz)
+		stats.TotalNumBlocks.Inc()
+
+		// Push in reverse so links are visited in order.
+		roles := countUnixFS(nd, role, stats)
+		links := nd.Links()
+		for i := len(links) - 1; i >= 0; i-- {
+			stack = append(stack, &dagStatsItem{
+				cid:  links[i].Cid,
+				role: roles(links[i]),
+			})
+		}
+	}
+
+	visit(root, roleEntry)
+	for len(stack) > 0 {
+		it := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
 
-			stats.TotalSize.Add(sz)
-			stats.TotalNumBlocks.Inc()
+		nd, err := nodeGetter.Get(ctx, it.cid)
+		if err != nil {
+			return err
+		}
+		visit(nd, it.role)
+	}
+	return nil
+}
 
-			return nil
-		},
-		ErrFunc:        nil,
-		SkipDuplicates: false,
-	})
+// dagRole is the UnixFS role of a node given by its parent.
+type dagRole int
+
+const (
+	// A node linked from a directory or the root.
+	roleEntry dagRole = iota
+	// A chunk of a file.
+	roleChunk
+	// A sub-shard of a HAMT-sharded directory.
+	roleShard
+)
+
+type dagStatsItem struct {
+	cid  cid.Cid
+	role dagRole
+}
+
+// countUnixFS adds the UnixFS stats of a node to stats and returns the
+// roles of its links. Nodes which are not UnixFS are only traversed.
+func countUnixFS(
+	nd ipld.Node,
+	role dagRole,
+	stats *DagStats,
+) func(l *ipld.Link) dagRole {
+	same := func(*ipld.Link) dagRole { return role }
+	entry := func(*ipld.Link) dagRole { return roleEntry }
+	chunk := func(*ipld.Link) dagRole { return roleChunk }
+	if role == roleChunk {
+		return chunk
+	}
+
+	switch v := nd.(type) {
+	case *merkledag.RawNode:
+		if role == roleEntry {
+			stats.NumFiles.Inc()
+			stats.FileSize.Add(int64(len(v.RawData())))
+		}
+		return same
+
+	case *merkledag.ProtoNode:
+		fsn, err := unixfs.FSNodeFromBytes(v.Data())
+		if err != nil {
+			return entry
+		}
+
+		switch fsn.Type() {
+		case unixfs.TFile, unixfs.TRaw:
+			stats.NumFiles.Inc()
+			stats.FileSize.Add(int64(fsn.FileSize()))
+			return chunk
+
+		case unixfs.TDirectory:
+			stats.NumDirs.Inc()
+			return entry
+
+		case unixfs.THAMTShard:
+			if role != roleShard {
+				stats.NumDirs.Inc()
+				stats.NumShardedDirs.Inc()
+			}
+			// Links to sub-shards are named by the index prefix only.
+			padLen := len(fmt.Sprintf("%X", fsn.Fanout()-1))
+			return func(l *ipld.Link) dagRole {
+				if len(l.Name) == padLen {
+					return roleShard
+				}
+				return roleEntry
+			}
+		}
+	}
+	return entry
 }
@@ -1,13 +1,21 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
+	"fmt"
+	gohttp "net/http"
+	"net/http/httptest"
 	"testing"
 
 	bs "github.com/ipfs/boxo/blockservice"
 	"github.com/ipfs/boxo/blockstore"
+	chunk "github.com/ipfs/boxo/chunker"
 	"github.com/ipfs/boxo/exchange/offline"
 	"github.com/ipfs/boxo/ipld/merkledag"
+	"github.com/ipfs/boxo/ipld/unixfs/hamt"
+	"github.com/ipfs/boxo/ipld/unixfs/importer"
+	uio "github.com/ipfs/boxo/ipld/unixfs/io"
 	ds "github.com/ipfs/go-datastore"
 	dssync "github.com/ipfs/go-datastore/sync"
 
@@ -126,6 +134,68 @@ func TestDagStats(t *testing.T) {
 	require.Equal(t, int64(1), stats.DeduplicatedNumBlocks.Load())
 }
 
+func TestDagStatsUnixFS(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	bstore := blockstore.NewBlockstore(dstore)
+	dserv := merkledag.NewDAGService(bs.New(bstore, offline.Exchange(bstore)))
+	api := &mockAPI{
+		dag: &mockAPIDag{
+			DAGService: dserv,
+		},
+	}
+
+	// root{file: 1000 bytes in 4 chunks, raw: 10 bytes, shard: 20 entries}
+	file, err := importer.BuildDagFromReader(
+		dserv,
+		chunk.NewSizeSplitter(bytes.NewReader(make([]byte, 1000)), 256),
+	)
+	require.NoError(t, err)
+	raw := merkledag.NewRawNode(make([]byte, 10))
+	require.NoError(t, dserv.Add(ctx, raw))
+
+	// 20 entries in 8 buckets always have sub-shards.
+	shard, err := hamt.NewShard(dserv, 8)
+	require.NoError(t, err)
+	for i := 0; i < 20; i++ {
+		nd := merkledag.NewRawNode([]byte(fmt.Sprintf("entry-%02d", i)))
+		require.NoError(t, dserv.Add(ctx, nd))
+		require.NoError(t, shard.Set(ctx, fmt.Sprintf("e%d", i), nd))
+	}
+	shardNd, err := shard.Node()
+	require.NoError(t, err)
+	require.NoError(t, dserv.Add(ctx, shardNd))
+
+	dir := uio.NewDirectory(dserv)
+	require.NoError(t, dir.AddChild(ctx, "file", file))
+	require.NoError(t, dir.AddChild(ctx, "raw", raw))
+	require.NoError(t, dir.AddChild(ctx, "shard", shardNd))
+	root, err := dir.GetNode()
+	require.NoError(t, err)
+	require.NoError(t, dserv.Add(ctx, root))
+
+	stats := NewDagStats()
+	require.NoError(t, CalculateDagStats(ctx, api, root.Cid(), true, stats))
+	require.Equal(t, int64(1000+10+20*8), stats.FileSize.Load())
+	require.Equal(t, int64(22), stats.NumFiles.Load())
+	require.Equal(t, int64(2), stats.NumDirs.Load())
+	require.Equal(t, int64(1), stats.NumShardedDirs.Load())
+	require.Equal(
+		t,
+		float64(stats.FileSize.Load())/float64(stats.DeduplicatedSize.Load()),
+		stats.LogicalRatio(),
+	)
+
+	// The logical size of a file is known from its root.
+	stats = NewDagStats()
+	require.NoError(t, CalculateDagStats(ctx, api, file.Cid(), false, stats))
+	require.Equal(t, int64(1000), stats.FileSize.Load())
+	require.Equal(t, int64(1), stats.NumFiles.Load())
+	require.Equal(t, int64(0), stats.NumDirs.Load())
+}
+
 func totalSize(nodes ...*merkledag.ProtoNode) int64 {
 	sum := int64(0)
 	for _, n := range nodes {
@@ -133,3 +203,49 @@ func totalSize(nodes ...*merkledag.ProtoNode) int64 {
 	}
 	return sum
 }
+
+func TestDagStatsHandler(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	bstore := blockstore.NewBlockstore(dstore)
+	dserv := merkledag.NewDAGService(bs.New(bstore, offline.Exchange(bstore)))
+	// A{B}
+	a := rndNode(t)
+	b := rndNode(t)
+	require.NoError(t, a.AddNodeLink("c0", b))
+	require.NoError(t, dserv.Add(ctx, a))
+	require.NoError(t, dserv.Add(ctx, b))
+
+	h := New(
+		nil,
+		&mockAPI{
+			dag: &mockAPIDag{
+				DAGService: dserv,
+			},
+		},
+		nil,
+	)
+	call := func() (int, *DagStatsResult) {
+		w := httptest.NewRecorder()
+		h.DagStats()(w, httptest.NewRequest(
+			gohttp.MethodGet,
+			"/api/v0/dag/stats?arg="+a.Cid().String(),
+			nil,
+		))
+		var res DagStatsResult
+		decodeResp(t, w, &res)
+		return w.Code, &res
+	}
+
+	code, res := call()
+	require.Equal(t, gohttp.StatusOK, code)
+	require.Equal(t, totalSize(a, b), res.TotalSize)
+
+	// Missing blocks are not fetched.
+	require.NoError(t, bstore.DeleteBlock(ctx, b.Cid()))
+	code, res = call()
+	require.Equal(t, gohttp.StatusNotFound, code)
+	require.False(t, res.Success)
+}
@@ -0,0 +1,326 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	coreiface "github.com/ipfs/boxo/coreiface"
+	"github.com/ipfs/boxo/coreiface/options"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+
+	"github.com/photon-storage/go-common/log"
+	"github.com/photon-storage/go-common/metrics"
+
+	"github.com/photon-storage/falcon/node/com"
+)
+
+const (
+	defaultDagStatsSweepInterval = 6 * time.Hour
+	dagStatsPrecomputeBatch      = 1000
+	// Entries of older versions lack UnixFS stats and are recalculated.
+	dagStatsCacheVersion = 1
+)
+
+var (
+	dagStatsPrefix = datastore.NewKey("/falcon/dagstats")
+)
+
+type cachedDagStats struct {
+	Version               int   `json:"v"`
+	DeduplicatedSize      int64 `json:"ds"`
+	DeduplicatedNumBlocks int64 `json:"dn"`
+	TotalSize             int64 `json:"ts"`
+	TotalNumBlocks        int64 `json:"tn"`
+	FileSize              int64 `json:"fs"`
+	NumFiles              int64 `json:"nf"`
+	NumDirs               int64 `json:"nd"`
+	NumShardedDirs        int64 `json:"nh"`
+}
+
+// DagStatsCache persists DAG stats keyed by CID and recursion flag. As
+// CIDs are immutable, entries are never invalidated. They are swept once
+// the CID is no longer pinned.
+type DagStatsCache struct {
+	ds     datastore.Datastore
+	api    coreiface.CoreAPI
+	pinner *com.WrappedPinner
+}
+
+func NewDagStatsCache(
+	ds datastore.Datastore,
+	api coreiface.CoreAPI,
+	pinner *com.WrappedPinner,
+) *DagStatsCache {
+	return &DagStatsCache{
+		ds:     ds,
+		api:    api,
+		pinner: pinner,
+	}
+}
+
+func RegisterDagStatsMetrics() {
+	metrics.NewCounter("dag_stats_cache_hit_total")
+	metrics.NewCounter("dag_stats_cache_miss_total")
+	metrics.NewCounter("dag_stats_cache_swept_total")
+	metrics.NewCounter("dag_stats_precomputed_total")
+}
+
+// SetDagStatsCache enables caching of DAG stats.
+func (h *ExtendedHandlers) SetDagStatsCache(c *DagStatsCache) {
+	h.dagCache = c
+}
+
+// Start sweeps entries of unpinned CIDs periodically. If precompute is
+// set, stats of recursive pins missing from the cache are calculated in
+// background after each sweep.
+func (c *DagStatsCache) Start(
+	ctx context.Context,
+	interval time.Duration,
+	precompute bool,
+) {
+	if interval <= 0 {
+		interval = defaultDagStatsSweepInterval
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if precompute {
+				if err := c.Precompute(ctx); err != nil &&
+					ctx.Err() == nil {
+					log.Error("Error precomputing dag stats", "error", err)
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+
+			case <-ticker.C:
+				if err := c.Sweep(ctx); err != nil {
+					log.Error("Error sweeping dag stats", "error", err)
+				}
+			}
+		}
+	}()
+}
+
+// Calculate fills stats from cache, calculating and caching them on
+// miss. A nil cache always calculates.
+func (c *DagStatsCache) Calculate(
+	ctx context.Context,
+	api coreiface.CoreAPI,
+	k cid.Cid,
+	recursive bool,
+	stats *DagStats,
+) error {
+	if c == nil {
+		return CalculateDagStats(ctx, api, k, recursive, stats)
+	}
+
+	ok, err := c.get(ctx, k, recursive, stats)
+	if err != nil {
+		log.Error("Error reading dag stats cache",
+			"error", err,
+			"cid", k.String(),
+		)
+	}
+	if ok {
+		metrics.CounterInc("dag_stats_cache_hit_total")
+		return nil
+	}
+	metrics.CounterInc("dag_stats_cache_miss_total")
+
+	if err := CalculateDagStats(ctx, api, k, recursive, stats); err != nil {
+		return err
+	}
+	if err := c.put(ctx, k, recursive, stats); err != nil {
+		log.Error("Error writing dag stats cache",
+			"error", err,
+			"cid", k.String(),
+		)
+	}
+	return nil
+}
+
+// Sweep deletes entries of CIDs which are no longer pinned.
+func (c *DagStatsCache) Sweep(ctx context.Context) error {
+	if c.pinner == nil {
+		return nil
+	}
+
+	res, err := c.ds.Query(ctx, query.Query{
+		Prefix:   dagStatsPrefix.String(),
+		KeysOnly: true,
+	})
+	if err != nil {
+		return err
+	}
+	defer res.Close()
+
+	var stale []datastore.Key
+	for e := range res.Next() {
+		if e.Error != nil {
+			return e.Error
+		}
+
+		k := datastore.RawKey(e.Key)
+		recursive := k.Parent().BaseNamespace() == "r"
+		v, err := cid.Decode(k.BaseNamespace())
+		if err != nil {
+			stale = append(stale, k)
+			continue
+		}
+		cnt, err := c.pinner.GetCount(ctx, v, recursive)
+		if err != nil {
+			return err
+		}
+		if cnt == 0 {
+			stale = append(stale, k)
+		}
+	}
+
+	for _, k := range stale {
+		if err := c.ds.Delete(ctx, k); err != nil {
+			return err
+		}
+	}
+	metrics.CounterAdd("dag_stats_cache_swept_total", float64(len(stale)))
+	return nil
+}
+
+// Precompute calculates stats of recursive pins missing from the cache
+// or cached by an older version. Blocks are read offline so missing
+// blocks are never fetched.
+func (c *DagStatsCache) Precompute(ctx context.Context) error {
+	if c.pinner == nil {
+		return nil
+	}
+	api, err := c.api.WithOptions(options.Api.Offline(true))
+	if err != nil {
+		return err
+	}
+
+	opts := &com.PageOptions{
+		Limit: dagStatsPrecomputeBatch,
+	}
+	for {
+		page, err := c.pinner.ListPage(ctx, true, opts)
+		if err != nil {
+			if errors.Is(err, com.ErrPageUnsupported) {
+				return nil
+			}
+			return err
+		}
+
+		for _, p := range page.Pins {
+			stats := NewDagStats()
+			ok, err := c.get(ctx, p.Cid, true, stats)
+			if err != nil {
+				log.Debug("Error reading dag stats cache",
+					"error", err,
+					"cid", p.Cid.String(),
+				)
+			}
+			if ok {
+				continue
+			}
+
+			if err := CalculateDagStats(
+				ctx,
+				api,
+				p.Cid,
+				true,
+				stats,
+			); err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				log.Debug("Error precomputing dag stats",
+					"error", err,
+					"cid", p.Cid.String(),
+				)
+				continue
+			}
+			if err := c.put(ctx, p.Cid, true, stats); err != nil {
+				return err
+			}
+			metrics.CounterInc("dag_stats_precomputed_total")
+		}
+
+		if page.Next == "" {
+			return nil
+		}
+		opts.After = page.Next
+	}
+}
+
+func (c *DagStatsCache) get(
+	ctx context.Context,
+	k cid.Cid,
+	recursive bool,
+	stats *DagStats,
+) (bool, error) {
+	data, err := c.ds.Get(ctx, dagStatsKey(k, recursive))
+	if err != nil {
+		if errors.Is(err, datastore.ErrNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	var v cachedDagStats
+	if err := json.Unmarshal(data, &v); err != nil {
+		return false, err
+	}
+	if v.Version != dagStatsCacheVersion {
+		return false, nil
+	}
+
+	stats.TotalCount.Store(1)
+	stats.DeduplicatedSize.Store(v.DeduplicatedSize)
+	stats.DeduplicatedNumBlocks.Store(v.DeduplicatedNumBlocks)
+	stats.TotalSize.Store(v.TotalSize)
+	stats.TotalNumBlocks.Store(v.TotalNumBlocks)
+	stats.FileSize.Store(v.FileSize)
+	stats.NumFiles.Store(v.NumFiles)
+	stats.NumDirs.Store(v.NumDirs)
+	stats.NumShardedDirs.Store(v.NumShardedDirs)
+	return true, nil
+}
+
+func (c *DagStatsCache) put(
+	ctx context.Context,
+	k cid.Cid,
+	recursive bool,
+	stats *DagStats,
+) error {
+	data, err := json.Marshal(&cachedDagStats{
+		Version:               dagStatsCacheVersion,
+		DeduplicatedSize:      stats.DeduplicatedSize.Load(),
+		DeduplicatedNumBlocks: stats.DeduplicatedNumBlocks.Load(),
+		TotalSize:             stats.TotalSize.Load(),
+		TotalNumBlocks:        stats.TotalNumBlocks.Load(),
+		FileSize:              stats.FileSize.Load(),
+		NumFiles:              stats.NumFiles.Load(),
+		NumDirs:               stats.NumDirs.Load(),
+		NumShardedDirs:        stats.NumShardedDirs.Load(),
+	})
+	if err != nil {
+		return err
+	}
+	return c.ds.Put(ctx, dagStatsKey(k, recursive), data)
+}
+
+func dagStatsKey(k cid.Cid, recursive bool) datastore.Key {
+	mode := "d"
+	if recursive {
+		mode = "r"
+	}
+	return dagStatsPrefix.ChildString(mode).ChildString(k.String())
+}
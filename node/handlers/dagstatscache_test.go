@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+
+	bs "github.com/ipfs/boxo/blockservice"
+	"github.com/ipfs/boxo/blockstore"
+	"github.com/ipfs/boxo/exchange/offline"
+	"github.com/ipfs/boxo/ipld/merkledag"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+
+	"github.com/photon-storage/go-common/testing/require"
+	rcpinner "github.com/photon-storage/go-rc-pinner"
+
+	"github.com/photon-storage/falcon/node/com"
+)
+
+func TestDagStatsCache(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	bstore := blockstore.NewBlockstore(dstore)
+	bserv := bs.New(bstore, offline.Exchange(bstore))
+	dserv := merkledag.NewDAGService(bserv)
+	rcp, err := rcpinner.New(ctx, dstore, dserv)
+	require.NoError(t, err)
+	pinner := &com.WrappedPinner{
+		Pinner:    rcp,
+		Datastore: dstore,
+	}
+
+	// A{B,C}
+	a := rndNode(t)
+	b := rndNode(t)
+	c := rndNode(t)
+	require.NoError(t, a.AddNodeLink("c0", b))
+	require.NoError(t, a.AddNodeLink("c1", c))
+	require.NoError(t, dserv.Add(ctx, a))
+	require.NoError(t, dserv.Add(ctx, b))
+	require.NoError(t, dserv.Add(ctx, c))
+
+	api := &mockAPI{
+		dag: &mockAPIDag{
+			DAGService: dserv,
+		},
+	}
+	cache := NewDagStatsCache(dstore, api, pinner)
+
+	// Miss calculates and caches stats.
+	stats := NewDagStats()
+	require.NoError(t, cache.Calculate(ctx, api, a.Cid(), true, stats))
+	require.Equal(t, totalSize(a, b, c), stats.TotalSize.Load())
+	require.Equal(t, int64(3), stats.TotalNumBlocks.Load())
+	has, err := dstore.Has(ctx, dagStatsKey(a.Cid(), true))
+	require.NoError(t, err)
+	require.True(t, has)
+	has, err = dstore.Has(ctx, dagStatsKey(a.Cid(), false))
+	require.NoError(t, err)
+	require.False(t, has)
+
+	// Hit does not read blocks.
+	require.NoError(t, dserv.Remove(ctx, b.Cid()))
+	stats = NewDagStats()
+	require.NoError(t, cache.Calculate(ctx, api, a.Cid(), true, stats))
+	require.Equal(t, totalSize(a, b, c), stats.TotalSize.Load())
+	require.Equal(t, totalSize(a, b, c), stats.DeduplicatedSize.Load())
+	require.Equal(t, int64(3), stats.DeduplicatedNumBlocks.Load())
+	require.NoError(t, dserv.Add(ctx, b))
+
+	// Nil cache always calculates.
+	var nilCache *DagStatsCache
+	stats = NewDagStats()
+	require.NoError(t, nilCache.Calculate(ctx, api, b.Cid(), false, stats))
+	require.Equal(t, totalSize(b), stats.TotalSize.Load())
+
+	// Entries of unpinned CIDs are swept.
+	require.NoError(t, pinner.Pin(ctx, a, true))
+	require.NoError(t, cache.Calculate(ctx, api, c.Cid(), false, NewDagStats()))
+	require.NoError(t, cache.Sweep(ctx))
+	has, err = dstore.Has(ctx, dagStatsKey(a.Cid(), true))
+	require.NoError(t, err)
+	require.True(t, has)
+	has, err = dstore.Has(ctx, dagStatsKey(c.Cid(), false))
+	require.NoError(t, err)
+	require.False(t, has)
+
+	require.NoError(t, pinner.Unpin(ctx, a.Cid(), true))
+	require.NoError(t, cache.Sweep(ctx))
+	has, err = dstore.Has(ctx, dagStatsKey(a.Cid(), true))
+	require.NoError(t, err)
+	require.False(t, has)
+
+	// Precompute fills stats of recursive pins.
+	require.NoError(t, pinner.Pin(ctx, a, true))
+	require.NoError(t, pinner.Pin(ctx, c, true))
+	require.NoError(t, cache.Precompute(ctx))
+	for _, k := range []*merkledag.ProtoNode{a, c} {
+		has, err = dstore.Has(ctx, dagStatsKey(k.Cid(), true))
+		require.NoError(t, err)
+		require.True(t, has)
+	}
+	stats = NewDagStats()
+	ok, err := cache.get(ctx, c.Cid(), true, stats)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, totalSize(c), stats.TotalSize.Load())
+}
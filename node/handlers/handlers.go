@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	gohttp "net/http"
 
@@ -8,10 +9,39 @@ import (
 	"github.com/ipfs/kubo/core"
 )
 
+const (
+	requestIDCtxKey = "ctx_request_id"
+)
+
+// WithRequestID sets the ID tagged to a request by auth.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDCtxKey, id)
+}
+
+// GetRequestIDFromCtx returns the request ID, or empty if not tagged.
+func GetRequestIDFromCtx(ctx context.Context) string {
+	v := ctx.Value(requestIDCtxKey)
+	if v == nil {
+		return ""
+	}
+	id, ok := v.(string)
+	if !ok {
+		return ""
+	}
+	return id
+}
+
 type ExtendedHandlers struct {
 	nd          *core.IpfsNode
 	api         coreiface.CoreAPI
 	apiHandlers gohttp.Handler
+	health      healthChecks
+	jobs        *PinJobQueue
+	psa         *PinService
+	dagCache    *DagStatsCache
+	ttl         *PinTTL
+	quotas      *Quotas
+	verifier    *PinVerifier
 }
 
 func New(
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	HealthOk       = "ok"
+	HealthDegraded = "degraded"
+	HealthError    = "error"
+
+	defaultHealthCheckTimeout = 5 * time.Second
+)
+
+// HealthCheck is a probe run by the /status endpoint. Failure of a
+// critical check marks the node unhealthy and fails /status with 503
+// so load balancers could route around it. Failure of a non-critical
+// check only degrades the status.
+type HealthCheck struct {
+	Name     string
+	Critical bool
+	Run      func(ctx context.Context) error
+}
+
+type HealthCheckResult struct {
+	Name       string `json:"name"`
+	Critical   bool   `json:"critical"`
+	Ok         bool   `json:"ok"`
+	Message    string `json:"message,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+type healthChecks struct {
+	mu     sync.RWMutex
+	checks []*HealthCheck
+}
+
+// RegisterHealthCheck adds a check to be run by /status.
+func (h *ExtendedHandlers) RegisterHealthCheck(c *HealthCheck) {
+	h.health.mu.Lock()
+	defer h.health.mu.Unlock()
+	h.health.checks = append(h.health.checks, c)
+}
+
+// runHealthChecks runs all registered checks concurrently and returns
+// the overall status with individual results in registration order.
+func (h *ExtendedHandlers) runHealthChecks(
+	ctx context.Context,
+	timeout time.Duration,
+) (string, []*HealthCheckResult) {
+	h.health.mu.RLock()
+	checks := make([]*HealthCheck, len(h.health.checks))
+	copy(checks, h.health.checks)
+	h.health.mu.RUnlock()
+
+	if timeout <= 0 {
+		timeout = defaultHealthCheckTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	results := make([]*HealthCheckResult, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c *HealthCheck) {
+			defer wg.Done()
+			results[i] = runHealthCheck(ctx, c)
+		}(i, c)
+	}
+	wg.Wait()
+
+	status := HealthOk
+	for _, res := range results {
+		if res.Ok {
+			continue
+		}
+		if res.Critical {
+			status = HealthError
+			break
+		}
+		status = HealthDegraded
+	}
+
+	return status, results
+}
+
+func runHealthCheck(ctx context.Context, c *HealthCheck) *HealthCheckResult {
+	start := time.Now()
+	errc := make(chan error, 1)
+	go func() {
+		errc <- c.Run(ctx)
+	}()
+
+	// A check blocked on an unresponsive component is not waited for.
+	var err error
+	select {
+	case err = <-errc:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	res := &HealthCheckResult{
+		Name:       c.Name,
+		Critical:   c.Critical,
+		Ok:         err == nil,
+		DurationMs: time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		res.Message = err.Error()
+	}
+	return res
+}
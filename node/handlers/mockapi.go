@@ -10,7 +10,7 @@ import (
 	coreiface "github.com/ipfs/boxo/coreiface"
 	"github.com/ipfs/boxo/coreiface/options"
 	"github.com/ipfs/boxo/coreiface/path"
-	"github.com/ipfs/boxo/ipld/merkledag"
+	pinneriface "github.com/ipfs/boxo/pinning/pinner"
 	ipld "github.com/ipfs/go-ipld-format"
 )
 
@@ -19,6 +19,7 @@ var _ coreiface.CoreAPI = &mockAPI{}
 type mockAPI struct {
 	dag   coreiface.APIDagService
 	block *mockAPIBlock
+	pin   *mockAPIPin
 }
 
 func (m *mockAPI) Unixfs() coreiface.UnixfsAPI {
@@ -46,7 +47,7 @@ func (m *mockAPI) Key() coreiface.KeyAPI {
 
 // Pin returns an implementation of Pin API
 func (m *mockAPI) Pin() coreiface.PinAPI {
-	return nil
+	return m.pin
 }
 
 // Object returns an implementation of Object API
@@ -88,7 +89,7 @@ func (m *mockAPI) ResolveNode(context.Context, path.Path) (ipld.Node, error) {
 // WithOptions creates new instance of CoreAPI based on this instance with
 // a set of options applied
 func (m *mockAPI) WithOptions(...options.ApiOption) (coreiface.CoreAPI, error) {
-	return nil, errors.New("not implemented")
+	return m, nil
 }
 
 type mockAPIDag struct {
@@ -103,7 +104,7 @@ type mockAPIBlock struct {
 	blocks map[string][]byte
 }
 
-func newMockAPIBlock(nodes ...*merkledag.ProtoNode) *mockAPIBlock {
+func newMockAPIBlock(nodes ...ipld.Node) *mockAPIBlock {
 	m := &mockAPIBlock{
 		blocks: map[string][]byte{},
 	}
@@ -113,7 +114,7 @@ func newMockAPIBlock(nodes ...*merkledag.ProtoNode) *mockAPIBlock {
 	return m
 }
 
-func (m *mockAPIBlock) add(nd *merkledag.ProtoNode) {
+func (m *mockAPIBlock) add(nd ipld.Node) {
 	m.blocks[fmt.Sprintf("/ipfs/%v", nd.Cid().String())] = nd.RawData()
 }
 
@@ -158,3 +159,83 @@ func (m *mockAPIBlock) Stat(
 ) (coreiface.BlockStat, error) {
 	return nil, errors.New("not implemented")
 }
+
+type mockAPIPin struct {
+	dag    ipld.DAGService
+	pinner pinneriface.Pinner
+}
+
+// Add creates new pin, be default recursive - pinning the whole referenced
+// tree
+func (m *mockAPIPin) Add(
+	ctx context.Context,
+	p path.Path,
+	opts ...options.PinAddOption,
+) error {
+	settings, err := options.PinAddOptions(opts...)
+	if err != nil {
+		return err
+	}
+	rp, ok := p.(path.Resolved)
+	if !ok {
+		return errors.New("not implemented")
+	}
+	nd, err := m.dag.Get(ctx, rp.Cid())
+	if err != nil {
+		return err
+	}
+	return m.pinner.Pin(ctx, nd, settings.Recursive)
+}
+
+// Ls returns list of pinned objects on this node
+func (m *mockAPIPin) Ls(
+	context.Context,
+	...options.PinLsOption,
+) (<-chan coreiface.Pin, error) {
+	return nil, errors.New("not implemented")
+}
+
+// IsPinned returns whether or not the given cid is pinned
+// and an explanation of why its pinned
+func (m *mockAPIPin) IsPinned(
+	context.Context,
+	path.Path,
+	...options.PinIsPinnedOption,
+) (string, bool, error) {
+	return "", false, errors.New("not implemented")
+}
+
+// Rm removes pin for object specified by the path
+func (m *mockAPIPin) Rm(
+	ctx context.Context,
+	p path.Path,
+	opts ...options.PinRmOption,
+) error {
+	settings, err := options.PinRmOptions(opts...)
+	if err != nil {
+		return err
+	}
+	rp, ok := p.(path.Resolved)
+	if !ok {
+		return errors.New("not implemented")
+	}
+	return m.pinner.Unpin(ctx, rp.Cid(), settings.Recursive)
+}
+
+// Update changes one pin to another, skipping checks for matching paths in
+// the old tree
+func (m *mockAPIPin) Update(
+	context.Context,
+	path.Path,
+	path.Path,
+	...options.PinUpdateOption,
+) error {
+	return errors.New("not implemented")
+}
+
+// Verify verifies the integrity of pinned objects
+func (m *mockAPIPin) Verify(
+	context.Context,
+) (<-chan coreiface.PinStatus, error) {
+	return nil, errors.New("not implemented")
+}
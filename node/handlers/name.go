@@ -8,6 +8,8 @@ import (
 	"github.com/libp2p/go-libp2p/core/peer"
 
 	"github.com/photon-storage/go-gw3/common/http"
+
+	"github.com/photon-storage/falcon/node/tracing"
 )
 
 type NameBroadcastResult struct {
@@ -16,6 +18,10 @@ type NameBroadcastResult struct {
 
 func (h *ExtendedHandlers) NameBroadcast() gohttp.HandlerFunc {
 	return gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		ctx, span := tracing.Span(r.Context(), "Handlers", "NameBroadcast")
+		defer span.End()
+		r = r.WithContext(ctx)
+
 		query := r.URL.Query()
 		peerID, err := peer.Decode(query.Get(http.ParamIPFSKey))
 		if err != nil {
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
@@ -12,22 +13,33 @@ import (
 
 	coreiface "github.com/ipfs/boxo/coreiface"
 	coreifacepath "github.com/ipfs/boxo/coreiface/path"
-	"github.com/ipfs/boxo/ipld/merkledag"
 	pinneriface "github.com/ipfs/boxo/pinning/pinner"
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/kubo/core/commands/pin"
+	_ "github.com/ipld/go-codec-dagpb"
+	_ "github.com/ipld/go-ipld-prime/codec/dagcbor"
+	_ "github.com/ipld/go-ipld-prime/codec/dagjson"
+	_ "github.com/ipld/go-ipld-prime/codec/raw"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/multicodec"
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+	"github.com/ipld/go-ipld-prime/traversal"
 
 	"github.com/photon-storage/go-common/log"
 	"github.com/photon-storage/go-gw3/common/http"
 	rcpinner "github.com/photon-storage/go-rc-pinner"
 
 	"github.com/photon-storage/falcon/node/com"
+	"github.com/photon-storage/falcon/node/tracing"
 )
 
 var (
 	ErrInvalidCID    = errors.New("invalid CID")
 	ErrCIDNotChild   = errors.New("CID is not a child from root")
 	ErrCIDDuplicated = errors.New("duplicated CID found")
+	ErrInvalidLimit  = errors.New("invalid limit")
+	ErrInvalidCount  = errors.New("invalid count")
+	ErrCountMismatch = errors.New("count mismatch")
 )
 
 type pinAddRespHandler struct {
@@ -36,6 +48,16 @@ type pinAddRespHandler struct {
 	root       cid.Cid
 	recursive  bool
 	dagStats   *DagStats
+	cache      *DagStatsCache
+	ttl        *PinTTL
+	expiry     *PinExpiry
+	pinner     *com.WrappedPinner
+	meta       *com.PinMeta
+	quotas     *Quotas
+	account    string
+	guard      *quotaGuard
+	aborted    bool
+	format     streamFormat
 }
 
 func (h *pinAddRespHandler) status(statusCode int) {
@@ -44,10 +66,29 @@ func (h *pinAddRespHandler) status(statusCode int) {
 	}
 }
 
+func (h *pinAddRespHandler) header(header gohttp.Header) {
+	setStreamHeaders(header, h.format)
+}
+
+func (h *pinAddRespHandler) overrideStatus() int {
+	if h.guard.isExceeded() {
+		return gohttp.StatusInsufficientStorage
+	}
+	return 0
+}
+
 func (h *pinAddRespHandler) update(
 	ctx context.Context,
 	data []byte,
 ) ([]byte, error) {
+	if h.guard.isExceeded() {
+		return h.quotaExceeded(ctx)
+	}
+
+	if h.format != streamLegacy {
+		return h.updateStream(ctx, data)
+	}
+
 	// Only convert responses that we understand.
 	var val pin.AddPinOutput
 	if err := json.Unmarshal(data, &val); err == nil {
@@ -59,52 +100,124 @@ func (h *pinAddRespHandler) update(
 			})
 		}
 
-		ds := NewDagStats()
-		if err := CalculateDagStats(
-			ctx,
-			h.api,
-			h.root,
-			h.recursive,
-			ds,
-		); err != nil {
-			// Ignore stats error.
-			log.Error("Error calculating dag stats",
+		return json.Marshal(h.result(ctx, val.Progress))
+	}
+
+	return data, nil
+}
+
+func (h *pinAddRespHandler) updateStream(
+	ctx context.Context,
+	data []byte,
+) ([]byte, error) {
+	if msg, ok := decodeAPIError(data); ok {
+		ev := newStreamEvent(ctx, EventError, 0)
+		ev.Message = msg
+		return encodeEvent(h.format, ev)
+	}
+
+	var val pin.AddPinOutput
+	if err := json.Unmarshal(data, &val); err != nil {
+		return data, nil
+	}
+
+	if len(val.Pins) == 0 {
+		return encodeEvent(
+			h.format,
+			newStreamEvent(ctx, EventProgress, val.Progress),
+		)
+	}
+
+	ev := newStreamEvent(ctx, EventDone, val.Progress)
+	ev.Result = h.result(ctx, val.Progress)
+	return encodeEvent(h.format, ev)
+}
+
+// quotaExceeded replaces the error of a pin aborted by the account quota.
+// Responses after the first one are dropped.
+func (h *pinAddRespHandler) quotaExceeded(ctx context.Context) ([]byte, error) {
+	if h.aborted {
+		return nil, nil
+	}
+	h.aborted = true
+
+	if h.format != streamLegacy {
+		ev := newStreamEvent(ctx, EventError, 0)
+		ev.Message = ErrQuotaExceeded.Error()
+		return encodeEvent(h.format, ev)
+	}
+	return json.Marshal(&PinAddResult{
+		Success:    false,
+		InProgress: false,
+		Message:    ErrQuotaExceeded.Error(),
+	})
+}
+
+// result calculates DAG stats of the pinned root, stores its metadata and
+// indexes its expiry if any.
+func (h *pinAddRespHandler) result(
+	ctx context.Context,
+	progress int,
+) *PinAddResult {
+	ds := NewDagStats()
+	if err := h.cache.Calculate(
+		ctx,
+		h.api,
+		h.root,
+		h.recursive,
+		ds,
+	); err != nil {
+		// Ignore stats error.
+		log.Error("Error calculating dag stats",
+			"error", err,
+			"cid", h.root.String(),
+			"source", "pin add",
+		)
+	}
+	if h.dagStats != nil {
+		h.dagStats.Add(ds)
+	}
+	h.quotas.record(ctx, h.account, ds, false)
+	addPinMeta(ctx, h.pinner, h.root, h.recursive, h.meta)
+	if h.ttl != nil && h.expiry != nil {
+		if err := h.ttl.Add(ctx, h.expiry); err != nil {
+			log.Error("Error adding pin expiry",
 				"error", err,
 				"cid", h.root.String(),
-				"source", "pin add",
 			)
 		}
-		if h.dagStats != nil {
-			h.dagStats.Add(ds)
-		}
-
-		return json.Marshal(&PinAddResult{
-			Success:               true,
-			InProgress:            false,
-			ProcessedNumBlocks:    val.Progress,
-			DeduplicatedSize:      ds.DeduplicatedSize.Load(),
-			DeduplicatedNumBlocks: ds.DeduplicatedNumBlocks.Load(),
-			TotalSize:             ds.TotalSize.Load(),
-			TotalNumBlocks:        ds.TotalNumBlocks.Load(),
-		})
 	}
 
-	return data, nil
+	return &PinAddResult{
+		Success:               true,
+		InProgress:            false,
+		ProcessedNumBlocks:    progress,
+		DeduplicatedSize:      ds.DeduplicatedSize.Load(),
+		DeduplicatedNumBlocks: ds.DeduplicatedNumBlocks.Load(),
+		TotalSize:             ds.TotalSize.Load(),
+		TotalNumBlocks:        ds.TotalNumBlocks.Load(),
+		UnixFSStats:           newUnixFSStats(ds),
+	}
 }
 
 type PinAddResult struct {
-	Success               bool   `json:"success"`
-	InProgress            bool   `json:"in_progress"`
-	ProcessedNumBlocks    int    `json:"processed_num_blocks"`
-	DeduplicatedSize      int64  `json:"duplicated_size"`
-	DeduplicatedNumBlocks int64  `json:"duplicated_num_blocks"`
-	TotalSize             int64  `json:"total_size"`
-	TotalNumBlocks        int64  `json:"total_num_blocks"`
-	Message               string `json:"message"`
+	Success               bool  `json:"success"`
+	InProgress            bool  `json:"in_progress"`
+	ProcessedNumBlocks    int   `json:"processed_num_blocks"`
+	DeduplicatedSize      int64 `json:"duplicated_size"`
+	DeduplicatedNumBlocks int64 `json:"duplicated_num_blocks"`
+	TotalSize             int64 `json:"total_size"`
+	TotalNumBlocks        int64 `json:"total_num_blocks"`
+	UnixFSStats
+	Message string `json:"message"`
 }
 
 func (h *ExtendedHandlers) PinAdd() gohttp.HandlerFunc {
 	return gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		ctx, span := tracing.Span(r.Context(), "Handlers", "PinAdd")
+		defer span.End()
+		r = r.WithContext(ctx)
+
 		c, recursive, err := parsePinParams(r)
 		if err != nil {
 			writeJSON(
@@ -132,10 +245,87 @@ func (h *ExtendedHandlers) PinAdd() gohttp.HandlerFunc {
 			)
 			return
 		}
+
+		async, err := parseAsyncParam(r)
+		if err != nil {
+			writeJSON(
+				w,
+				gohttp.StatusBadRequest,
+				&PinAddResult{
+					Success:    false,
+					InProgress: false,
+					Message:    fmt.Sprintf("error parsing params: %v", err),
+				},
+			)
+			return
+		}
+		args, err := parseSignedArgs(r)
+		if err != nil {
+			writeJSON(
+				w,
+				gohttp.StatusBadRequest,
+				&PinAddResult{
+					Success:    false,
+					InProgress: false,
+					Message:    fmt.Sprintf("error parsing params: %v", err),
+				},
+			)
+			return
+		}
+
+		ttl, err := parseTTLArg(args)
+		if err != nil {
+			writeJSON(
+				w,
+				gohttp.StatusBadRequest,
+				&PinAddResult{
+					Success:    false,
+					InProgress: false,
+					Message:    fmt.Sprintf("error parsing params: %v", err),
+				},
+			)
+			return
+		}
+
+		meta, err := parsePinMeta(r, args)
+		if err != nil {
+			writeJSON(
+				w,
+				gohttp.StatusBadRequest,
+				&PinAddResult{
+					Success:    false,
+					InProgress: false,
+					Message:    fmt.Sprintf("error parsing params: %v", err),
+				},
+			)
+			return
+		}
+
+		if async {
+			h.pinAddAsync(w, r, c, recursive, cc, meta)
+			return
+		}
+
+		account := args.GetArg(http.ArgP3AcctID)
+		ctx, guard, err := h.quotas.enforce(r.Context(), account, 1)
+		if err != nil {
+			writeJSON(
+				w,
+				quotaErrorCode(err),
+				&PinAddResult{
+					Success:    false,
+					InProgress: false,
+					Message:    fmt.Sprintf("error checking quota: %v", err),
+				},
+			)
+			return
+		}
+		defer guard.stop()
+
 		if cc == 0 {
 			cc = 32
 		}
-		r = r.WithContext(rcpinner.WithConcurrency(r.Context(), cc))
+		r = r.WithContext(rcpinner.WithConcurrency(ctx, cc))
 
 		h.apiHandlers.ServeHTTP(
 			newResponseWriter(
@@ -146,6 +336,15 @@ func (h *ExtendedHandlers) PinAdd() gohttp.HandlerFunc {
 					root:      c,
 					recursive: recursive,
 					dagStats:  getDagStatsFromCtx(r.Context()),
+					cache:     h.dagCache,
+					ttl:       h.ttl,
+					expiry:    newPinExpiry(r, args, c, recursive, ttl),
+					pinner:    com.GetRcPinner(h.nd.Pinning),
+					meta:      meta,
+					quotas:    h.quotas,
+					account:   account,
+					guard:     guard,
+					format:    parseStreamFormat(r),
 				},
 			),
 			r,
@@ -159,6 +358,12 @@ type pinRmRespHandler struct {
 	root       cid.Cid
 	recursive  bool
 	dagStats   *DagStats
+	cache      *DagStatsCache
+	pinner     *com.WrappedPinner
+	ttl        *PinTTL
+	owner      string
+	quotas     *Quotas
+	format     streamFormat
 }
 
 func (h *pinRmRespHandler) status(statusCode int) {
@@ -167,15 +372,33 @@ func (h *pinRmRespHandler) status(statusCode int) {
 	}
 }
 
+func (h *pinRmRespHandler) header(header gohttp.Header) {
+	setStreamHeaders(header, h.format)
+}
+
 func (h *pinRmRespHandler) update(
 	ctx context.Context,
 	data []byte,
 ) ([]byte, error) {
+	// Kubo errors also decode as PinOutput, so they are checked first to
+	// skip side effects of a failed unpin.
+	if msg, ok := decodeAPIError(data); ok {
+		if h.format == streamLegacy {
+			return data, nil
+		}
+		ev := newStreamEvent(ctx, EventError, 0)
+		ev.Message = msg
+		return encodeEvent(h.format, ev)
+	}
+
 	// Only convert responses that we understand.
 	var val pin.PinOutput
 	if err := json.Unmarshal(data, &val); err == nil {
+		owned := removePinMeta(ctx, h.pinner, h.root, h.recursive, h.owner)
+		consumePinExpiry(ctx, h.ttl, h.root, h.recursive, h.owner)
+
 		ds := NewDagStats()
-		if err := CalculateDagStats(
+		if err := h.cache.Calculate(
 			ctx,
 			h.api,
 			h.root,
@@ -192,30 +415,46 @@ func (h *pinRmRespHandler) update(
 		if h.dagStats != nil {
 			h.dagStats.Sub(ds)
 		}
+		// Only unpins of the owner pins are credited.
+		if owned {
+			h.quotas.record(ctx, h.owner, ds, true)
+		}
 
-		return json.Marshal(&PinRmResult{
+		res := &PinRmResult{
 			Success:               true,
 			DeduplicatedSize:      ds.DeduplicatedSize.Load(),
 			DeduplicatedNumBlocks: ds.DeduplicatedNumBlocks.Load(),
 			TotalSize:             ds.TotalSize.Load(),
 			TotalNumBlocks:        ds.TotalNumBlocks.Load(),
-		})
+			UnixFSStats:           newUnixFSStats(ds),
+		}
+		if h.format != streamLegacy {
+			ev := newStreamEvent(ctx, EventDone, 0)
+			ev.Result = res
+			return encodeEvent(h.format, ev)
+		}
+		return json.Marshal(res)
 	}
 
 	return data, nil
 }
 
 type PinRmResult struct {
-	Success               bool   `json:"success"`
-	DeduplicatedSize      int64  `json:"duplicated_size"`
-	DeduplicatedNumBlocks int64  `json:"duplicated_num_blocks"`
-	TotalSize             int64  `json:"total_size"`
-	TotalNumBlocks        int64  `json:"total_num_blocks"`
-	Message               string `json:"message"`
+	Success               bool  `json:"success"`
+	DeduplicatedSize      int64 `json:"duplicated_size"`
+	DeduplicatedNumBlocks int64 `json:"duplicated_num_blocks"`
+	TotalSize             int64 `json:"total_size"`
+	TotalNumBlocks        int64 `json:"total_num_blocks"`
+	UnixFSStats
+	Message string `json:"message"`
 }
 
 func (h *ExtendedHandlers) PinRm() gohttp.HandlerFunc {
 	return gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		ctx, span := tracing.Span(r.Context(), "Handlers", "PinRm")
+		defer span.End()
+		r = r.WithContext(ctx)
+
 		c, recursive, err := parsePinParams(r)
 		if err != nil {
 			writeJSON(
@@ -229,6 +468,19 @@ func (h *ExtendedHandlers) PinRm() gohttp.HandlerFunc {
 			return
 		}
 
+		args, err := parseSignedArgs(r)
+		if err != nil {
+			writeJSON(
+				w,
+				gohttp.StatusBadRequest,
+				&PinRmResult{
+					Success: false,
+					Message: fmt.Sprintf("error parsing params: %v", err),
+				},
+			)
+			return
+		}
+
 		h.apiHandlers.ServeHTTP(
 			newResponseWriter(
 				r.Context(),
@@ -238,6 +490,12 @@ func (h *ExtendedHandlers) PinRm() gohttp.HandlerFunc {
 					root:      c,
 					recursive: recursive,
 					dagStats:  getDagStatsFromCtx(r.Context()),
+					cache:     h.dagCache,
+					pinner:    com.GetRcPinner(h.nd.Pinning),
+					ttl:       h.ttl,
+					owner:     args.GetArg(http.ArgP3AcctID),
+					quotas:    h.quotas,
+					format:    parseStreamFormat(r),
 				},
 			),
 			r,
@@ -245,9 +503,12 @@ func (h *ExtendedHandlers) PinRm() gohttp.HandlerFunc {
 	})
 }
 
+// PinChildrenUpdate updates the count of a child. If Count is set, the
+// update is applied only if the current count matches.
 type PinChildrenUpdate struct {
-	Cid       string `json:"c"`
-	Recursive bool   `json:"r"`
+	Cid       string  `json:"c"`
+	Recursive bool    `json:"r"`
+	Count     *uint16 `json:"n,omitempty"`
 }
 
 type PinChildrenUpdateRequest struct {
@@ -256,18 +517,67 @@ type PinChildrenUpdateRequest struct {
 	Decs []*PinChildrenUpdate `json:"decs"`
 }
 
+// PinChildrenUpdateDelta is the DAG stats delta of a child update. Stats
+// are negative for decrements.
+type PinChildrenUpdateDelta struct {
+	Cid                   string `json:"c"`
+	Recursive             bool   `json:"r"`
+	Count                 uint16 `json:"count"`
+	NewCount              uint16 `json:"new_count"`
+	DeduplicatedSize      int64  `json:"duplicated_size"`
+	DeduplicatedNumBlocks int64  `json:"duplicated_num_blocks"`
+	TotalSize             int64  `json:"total_size"`
+	TotalNumBlocks        int64  `json:"total_num_blocks"`
+}
+
+// PinChildrenUpdateStats aggregates deltas of all updates. Unreferenced
+// stats sum children whose count drops to zero. Their blocks may be
+// garbage collected unless referenced by other pins.
+type PinChildrenUpdateStats struct {
+	DeduplicatedSize      int64 `json:"duplicated_size"`
+	DeduplicatedNumBlocks int64 `json:"duplicated_num_blocks"`
+	TotalSize             int64 `json:"total_size"`
+	TotalNumBlocks        int64 `json:"total_num_blocks"`
+	UnreferencedSize      int64 `json:"unreferenced_size"`
+	UnreferencedNumBlocks int64 `json:"unreferenced_num_blocks"`
+}
+
 type PinChildrenUpdateResult struct {
-	Success bool              `json:"success"`
-	Message string            `json:"message"`
-	Sizes   map[string]uint64 `json:"sizes"`
+	Success bool                      `json:"success"`
+	Message string                    `json:"message"`
+	DryRun  bool                      `json:"dry_run"`
+	Sizes   map[string]uint64         `json:"sizes"`
+	Deltas  []*PinChildrenUpdateDelta `json:"deltas"`
+	Stats   *PinChildrenUpdateStats   `json:"stats"`
 }
 
 // PinChildrenUpdate updates a root node's children's reference count in
 // pinner index. The API requires the root node is already pinned recursively.
-// All updating CIDs must be current child of the root node. For decrementing
-// CID, its current count must be positive, otherwise, the all updates abort.
+// The root may be encoded with any IPLD codec registered, e.g. dag-pb,
+// dag-cbor or dag-json. All updating CIDs must be linked from the root node.
+// For decrementing CID, its current count must be positive, otherwise, the
+// all updates abort. If any update carries an expected count which does not
+// match, all updates abort with conflict. With dry_run set, the request is
+// validated and its DAG stats deltas are returned without updating counts.
 func (h *ExtendedHandlers) PinChildrenUpdate() gohttp.HandlerFunc {
 	return gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		ctx, span := tracing.Span(r.Context(), "Handlers", "PinChildrenUpdate")
+		defer span.End()
+		r = r.WithContext(ctx)
+
+		dryRun, err := parseDryRunParam(r)
+		if err != nil {
+			writeJSON(
+				w,
+				gohttp.StatusBadRequest,
+				&PinChildrenUpdateResult{
+					Success: false,
+					Message: fmt.Sprintf("error parsing params: %v", err),
+				},
+			)
+			return
+		}
+
 		var data []byte
 		if r.Body != nil {
 			data, _ = io.ReadAll(r.Body)
@@ -316,12 +626,68 @@ func (h *ExtendedHandlers) PinChildrenUpdate() gohttp.HandlerFunc {
 			return
 		}
 
-		if err := pinner.UpdateCounts(r.Context(), incs, decs); err != nil {
+		args, err := parseSignedArgs(r)
+		if err != nil {
 			writeJSON(
 				w,
 				gohttp.StatusBadRequest,
 				&PinChildrenUpdateResult{
 					Success: false,
+					Message: fmt.Sprintf("error parsing params: %v", err),
+				},
+			)
+			return
+		}
+		// Only increments are limited by quota.
+		account := args.GetArg(http.ArgP3AcctID)
+		if len(incs) > 0 {
+			res, _, err := h.quotas.Check(
+				r.Context(),
+				account,
+				int64(len(incs)-len(decs)),
+			)
+			defer res.release()
+			if err != nil {
+				writeJSON(
+					w,
+					quotaErrorCode(err),
+					&PinChildrenUpdateResult{
+						Success: false,
+						Message: fmt.Sprintf("error checking quota: %v", err),
+					},
+				)
+				return
+			}
+		}
+
+		var counts map[string]uint16
+		check := func(ctx context.Context) error {
+			var err error
+			counts, err = childrenCounts(ctx, pinner, incs, decs)
+			if err != nil {
+				return err
+			}
+			return checkChildrenCounts(&pcu, counts)
+		}
+		if dryRun {
+			err = check(r.Context())
+			if err == nil {
+				err = previewChildrenUpdate(decs, counts)
+			}
+		} else {
+			err = pinner.UpdateCountsIf(r.Context(), incs, decs, check)
+		}
+		if err != nil {
+			code := gohttp.StatusBadRequest
+			if errors.Is(err, ErrCountMismatch) {
+				code = gohttp.StatusConflict
+			}
+			writeJSON(
+				w,
+				code,
+				&PinChildrenUpdateResult{
+					Success: false,
+					DryRun:  dryRun,
 					Message: fmt.Sprintf("error updating counts: %v", err),
 				},
 			)
@@ -329,11 +695,20 @@ func (h *ExtendedHandlers) PinChildrenUpdate() gohttp.HandlerFunc {
 		}
 
 		aggrDs := getDagStatsFromCtx(r.Context())
-		sizes := map[string]uint64{}
+		res := &PinChildrenUpdateResult{
+			Success: true,
+			Message: "ok",
+			DryRun:  dryRun,
+			Sizes:   map[string]uint64{},
+			Deltas:  []*PinChildrenUpdateDelta{},
+			Stats:   &PinChildrenUpdateStats{},
+		}
+		newCounts := applyChildrenUpdate(incs, decs, counts)
+		unreferenced := map[string]bool{}
 		for idx, updates := range [][]*rcpinner.UpdateCount{incs, decs} {
 			for _, u := range updates {
 				ds := NewDagStats()
-				if err := CalculateDagStats(
+				if err := h.dagCache.Calculate(
 					r.Context(),
 					h.api,
 					u.CID,
@@ -348,26 +723,45 @@ func (h *ExtendedHandlers) PinChildrenUpdate() gohttp.HandlerFunc {
 					)
 				}
 
-				sizes[u.CID.String()] = uint64(ds.TotalSize.Load())
+				k := countKey(u.CID, u.Recursive)
+				res.Sizes[u.CID.String()] = uint64(ds.TotalSize.Load())
+				delta := NewDagStats()
+				if idx == 0 {
+					delta.Add(ds)
+				} else {
+					delta.Sub(ds)
+				}
+				res.Deltas = append(res.Deltas, &PinChildrenUpdateDelta{
+					Cid:                   u.CID.String(),
+					Recursive:             u.Recursive,
+					Count:                 counts[k],
+					NewCount:              newCounts[k],
+					DeduplicatedSize:      delta.DeduplicatedSize.Load(),
+					DeduplicatedNumBlocks: delta.DeduplicatedNumBlocks.Load(),
+					TotalSize:             delta.TotalSize.Load(),
+					TotalNumBlocks:        delta.TotalNumBlocks.Load(),
+				})
+				res.Stats.DeduplicatedSize += delta.DeduplicatedSize.Load()
+				res.Stats.DeduplicatedNumBlocks += delta.DeduplicatedNumBlocks.Load()
+				res.Stats.TotalSize += delta.TotalSize.Load()
+				res.Stats.TotalNumBlocks += delta.TotalNumBlocks.Load()
+				if newCounts[k] == 0 && !unreferenced[k] {
+					unreferenced[k] = true
+					res.Stats.UnreferencedSize += ds.DeduplicatedSize.Load()
+					res.Stats.UnreferencedNumBlocks += ds.DeduplicatedNumBlocks.Load()
+				}
+
+				if dryRun {
+					continue
+				}
+				h.quotas.record(r.Context(), account, ds, idx != 0)
 				if aggrDs != nil {
-					if idx == 0 {
-						aggrDs.Add(ds)
-					} else {
-						aggrDs.Sub(ds)
-					}
+					aggrDs.Add(delta)
 				}
 			}
 		}
 
-		writeJSON(
-			w,
-			gohttp.StatusOK,
-			&PinChildrenUpdateResult{
-				Success: true,
-				Message: "ok",
-				Sizes:   sizes,
-			},
-		)
+		writeJSON(w, gohttp.StatusOK, res)
 	})
 }
 
@@ -398,14 +792,14 @@ func validateChildrenUpdate(
 	if err != nil {
 		return nil, nil, err
 	}
-	rootNd, err := merkledag.DecodeProtobuf(data)
+	links, err := decodeLinks(rootCid, data)
 	if err != nil {
 		return nil, nil, err
 	}
 
 	m := map[string]bool{}
-	for _, link := range rootNd.Links() {
-		m[link.Cid.String()] = true
+	for _, link := range links {
+		m[link.String()] = true
 	}
 	seen := map[string]bool{}
 
@@ -461,19 +855,35 @@ func validateChildrenUpdate(
 type CidCount struct {
 	Cid   string `json:"c"`
 	Count int    `json:"v"`
+	// Seconds until the earliest expiry of the CID if any.
+	TTL  *int64         `json:"ttl,omitempty"`
+	Meta []*com.PinMeta `json:"meta,omitempty"`
 }
 
 type PinListResult struct {
 	Success    bool        `json:"success"`
 	InProgress bool        `json:"in_progress"`
 	Batch      []*CidCount `json:"batch"`
+	Cursor     string      `json:"cursor,omitempty"`
+	Total      *int64      `json:"total,omitempty"`
 	Message    string      `json:"message"`
 }
 
-const cidBatchSize = 100
+const (
+	cidBatchSize    = 100
+	maxPinListLimit = 1000
+)
 
+// PinList lists pinned CIDs with reference counts. Without limit or
+// cursor param, the whole index is streamed in batches. Otherwise, a
+// single page is returned with a cursor for the next page if any.
+// Pins could be filtered by count range and CID prefix in both cases.
 func (h *ExtendedHandlers) PinList() gohttp.HandlerFunc {
 	return gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		ctx, span := tracing.Span(r.Context(), "Handlers", "PinList")
+		defer span.End()
+		r = r.WithContext(ctx)
+
 		recursive, err := parseRecursiveParam(r)
 		if err != nil {
 			writeJSON(
@@ -502,6 +912,61 @@ func (h *ExtendedHandlers) PinList() gohttp.HandlerFunc {
 			return
 		}
 
+		opts, paged, err := parsePinListOptions(r)
+		if err != nil {
+			writeJSON(
+				w,
+				gohttp.StatusBadRequest,
+				&PinListResult{
+					Success:    false,
+					InProgress: false,
+					Message:    fmt.Sprintf("error parsing params: %v", err),
+				},
+			)
+			return
+		}
+
+		withMeta, err := parseMetaParam(r)
+		if err != nil {
+			writeJSON(
+				w,
+				gohttp.StatusBadRequest,
+				&PinListResult{
+					Success:    false,
+					InProgress: false,
+					Message:    fmt.Sprintf("error parsing params: %v", err),
+				},
+			)
+			return
+		}
+		annotate := func(ctx context.Context, batch []*CidCount) {
+			h.ttl.annotate(ctx, recursive, batch)
+			if withMeta {
+				annotateMeta(ctx, pinner, recursive, batch)
+			}
+		}
+
+		if owner := strings.TrimSpace(r.URL.Query().Get("owner")); owner != "" {
+			pinListOwner(
+				w,
+				r,
+				pinner,
+				recursive,
+				owner,
+				opts,
+				paged,
+				func(ctx context.Context, batch []*CidCount) {
+					h.ttl.annotate(ctx, recursive, batch)
+				},
+			)
+			return
+		}
+
+		if paged {
+			pinListPage(w, r, pinner, recursive, opts, annotate)
+			return
+		}
+
 		var ch <-chan *rcpinner.StreamedCidWithCount
 		if recursive {
 			ch = pinner.RecursiveKeysWithCount(r.Context())
@@ -509,6 +974,11 @@ func (h *ExtendedHandlers) PinList() gohttp.HandlerFunc {
 			ch = pinner.DirectKeysWithCount(r.Context())
 		}
 
+		if format := parseStreamFormat(r); format != streamLegacy {
+			streamPinList(r.Context(), w, format, ch, opts, annotate)
+			return
+		}
+
 		var batch []*CidCount
 		for v := range ch {
 			if v.Cid.Err != nil {
@@ -523,6 +993,9 @@ func (h *ExtendedHandlers) PinList() gohttp.HandlerFunc {
 				)
 				return
 			}
+			if !opts.Match(v.Cid.C, v.Count) {
+				continue
+			}
 
 			batch = append(batch, &CidCount{
 				Cid:   v.Cid.C.String(),
@@ -530,6 +1003,7 @@ func (h *ExtendedHandlers) PinList() gohttp.HandlerFunc {
 			})
 
 			if len(batch) >= cidBatchSize {
+				annotate(r.Context(), batch)
 				data, _ := json.Marshal(&PinListResult{
 					Success:    false,
 					InProgress: true,
@@ -540,6 +1014,7 @@ func (h *ExtendedHandlers) PinList() gohttp.HandlerFunc {
 			}
 		}
 
+		annotate(r.Context(), batch)
 		data, _ := json.Marshal(&PinListResult{
 			Success:    true,
 			InProgress: false,
@@ -549,14 +1024,123 @@ func (h *ExtendedHandlers) PinList() gohttp.HandlerFunc {
 	})
 }
 
+// pinListPage writes a single page of the pinner index.
+func pinListPage(
+	w gohttp.ResponseWriter,
+	r *gohttp.Request,
+	pinner *com.WrappedPinner,
+	recursive bool,
+	opts *com.PageOptions,
+	annotate func(ctx context.Context, batch []*CidCount),
+) {
+	page, err := pinner.ListPage(r.Context(), recursive, opts)
+	if err != nil {
+		code := gohttp.StatusInternalServerError
+		if errors.Is(err, com.ErrInvalidIndexKey) {
+			code = gohttp.StatusBadRequest
+		} else if errors.Is(err, com.ErrPageUnsupported) {
+			code = gohttp.StatusNotImplemented
+		}
+		writeJSON(
+			w,
+			code,
+			&PinListResult{
+				Success:    false,
+				InProgress: false,
+				Message:    fmt.Sprintf("error listing pins: %v", err),
+			},
+		)
+		return
+	}
+
+	batch := make([]*CidCount, 0, len(page.Pins))
+	for _, v := range page.Pins {
+		batch = append(batch, &CidCount{
+			Cid:   v.Cid.String(),
+			Count: int(v.Count),
+		})
+	}
+	annotate(r.Context(), batch)
+	res := &PinListResult{
+		Success:    true,
+		InProgress: false,
+		Batch:      batch,
+		Cursor:     page.Next,
+	}
+	if page.Total >= 0 {
+		res.Total = &page.Total
+	}
+	writeJSON(w, gohttp.StatusOK, res)
+}
+
+// streamPinList emits each batch as a progress event followed by a done
+// event, or an error event if the index read fails.
+func streamPinList(
+	ctx context.Context,
+	w gohttp.ResponseWriter,
+	format streamFormat,
+	ch <-chan *rcpinner.StreamedCidWithCount,
+	opts *com.PageOptions,
+	annotate func(ctx context.Context, batch []*CidCount),
+) {
+	setStreamHeaders(w.Header(), format)
+	w.WriteHeader(gohttp.StatusOK)
+
+	var batch []*CidCount
+	for v := range ch {
+		if v.Cid.Err != nil {
+			ev := newStreamEvent(ctx, EventError, 0)
+			ev.Message = fmt.Sprintf("pinner index error: %v", v.Cid.Err)
+			writeEvent(w, format, ev)
+			return
+		}
+		if !opts.Match(v.Cid.C, v.Count) {
+			continue
+		}
+
+		batch = append(batch, &CidCount{
+			Cid:   v.Cid.C.String(),
+			Count: int(v.Count),
+		})
+
+		if len(batch) >= cidBatchSize {
+			annotate(ctx, batch)
+			ev := newStreamEvent(ctx, EventProgress, 0)
+			ev.Result = &PinListResult{
+				Success:    false,
+				InProgress: true,
+				Batch:      batch,
+			}
+			if err := writeEvent(w, format, ev); err != nil {
+				return
+			}
+			batch = nil
+		}
+	}
+
+	annotate(ctx, batch)
+	ev := newStreamEvent(ctx, EventDone, 0)
+	ev.Result = &PinListResult{
+		Success:    true,
+		InProgress: false,
+		Batch:      batch,
+	}
+	writeEvent(w, format, ev)
+}
+
 type PinnedCountResult struct {
-	Success bool   `json:"success"`
-	Count   int    `json:"count"`
-	Message string `json:"message"`
+	Success bool           `json:"success"`
+	Count   int            `json:"count"`
+	Meta    []*com.PinMeta `json:"meta,omitempty"`
+	Message string         `json:"message"`
 }
 
 func (h *ExtendedHandlers) PinnedCount() gohttp.HandlerFunc {
 	return gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		ctx, span := tracing.Span(r.Context(), "Handlers", "PinnedCount")
+		defer span.End()
+		r = r.WithContext(ctx)
+
 		c, recursive, err := parsePinParams(r)
 		if err != nil {
 			writeJSON(
@@ -571,6 +1155,20 @@ func (h *ExtendedHandlers) PinnedCount() gohttp.HandlerFunc {
 			return
 		}
 
+		withMeta, err := parseMetaParam(r)
+		if err != nil {
+			writeJSON(
+				w,
+				gohttp.StatusBadRequest,
+				&PinnedCountResult{
+					Success: false,
+					Count:   0,
+					Message: fmt.Sprintf("error parsing params: %v", err),
+				},
+			)
+			return
+		}
+
 		pinner := com.GetRcPinner(h.nd.Pinning)
 		if pinner == nil {
 			writeJSON(
@@ -599,14 +1197,28 @@ func (h *ExtendedHandlers) PinnedCount() gohttp.HandlerFunc {
 			return
 		}
 
-		writeJSON(
-			w,
-			gohttp.StatusOK,
-			&PinnedCountResult{
-				Success: true,
-				Count:   int(count),
-			},
-		)
+		res := &PinnedCountResult{
+			Success: true,
+			Count:   int(count),
+		}
+		if withMeta {
+			metas, err := pinner.ListMeta(r.Context(), c, recursive)
+			if err != nil && !errors.Is(err, com.ErrMetaUnsupported) {
+				writeJSON(
+					w,
+					gohttp.StatusInternalServerError,
+					&PinnedCountResult{
+						Success: false,
+						Count:   0,
+						Message: fmt.Sprintf("error listing metadata: %v", err),
+					},
+				)
+				return
+			}
+			res.Meta = metas
+		}
+
+		writeJSON(w, gohttp.StatusOK, res)
 	})
 }
 
@@ -644,3 +1256,177 @@ func parseConcurrencyParam(r *gohttp.Request) (int, error) {
 
 	return int(v), nil
 }
+
+func parseAsyncParam(r *gohttp.Request) (bool, error) {
+	str := strings.TrimSpace(r.URL.Query().Get("async"))
+	if str == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(str)
+}
+
+// parseDryRunParam parses whether to preview an update without applying.
+func parseDryRunParam(r *gohttp.Request) (bool, error) {
+	str := strings.TrimSpace(r.URL.Query().Get("dry_run"))
+	if str == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(str)
+}
+
+// parsePinListOptions parses paging and filter params of pin/ls. It
+// returns true if a page is requested.
+func parsePinListOptions(r *gohttp.Request) (*com.PageOptions, bool, error) {
+	query := r.URL.Query()
+	opts := &com.PageOptions{
+		After:  strings.TrimSpace(query.Get("cursor")),
+		Limit:  cidBatchSize,
+		Prefix: strings.TrimSpace(query.Get("prefix")),
+	}
+	paged := query.Has("limit") || opts.After != ""
+
+	if str := strings.TrimSpace(query.Get("limit")); str != "" {
+		v, err := strconv.Atoi(str)
+		if err != nil || v < 1 || v > maxPinListLimit {
+			return nil, false, ErrInvalidLimit
+		}
+		opts.Limit = v
+	}
+
+	for k, cnt := range map[string]*uint16{
+		"min_count": &opts.MinCount,
+		"max_count": &opts.MaxCount,
+	} {
+		if str := strings.TrimSpace(query.Get(k)); str != "" {
+			v, err := strconv.ParseUint(str, 10, 16)
+			if err != nil {
+				return nil, false, ErrInvalidCount
+			}
+			*cnt = uint16(v)
+		}
+	}
+
+	if str := strings.TrimSpace(query.Get("total")); str != "" {
+		v, err := strconv.ParseBool(str)
+		if err != nil {
+			return nil, false, err
+		}
+		opts.Total = v
+	}
+
+	return opts, paged, nil
+}
+
+func countKey(c cid.Cid, recursive bool) string {
+	return fmt.Sprintf("%v_%v", c, recursive)
+}
+
+// childrenCounts queries current counts of updating children.
+func childrenCounts(
+	ctx context.Context,
+	pinner *com.WrappedPinner,
+	incs []*rcpinner.UpdateCount,
+	decs []*rcpinner.UpdateCount,
+) (map[string]uint16, error) {
+	counts := map[string]uint16{}
+	for _, updates := range [][]*rcpinner.UpdateCount{incs, decs} {
+		for _, u := range updates {
+			cnt, err := pinner.GetCount(ctx, u.CID, u.Recursive)
+			if err != nil {
+				return nil, err
+			}
+			counts[countKey(u.CID, u.Recursive)] = cnt
+		}
+	}
+	return counts, nil
+}
+
+// checkChildrenCounts checks expected counts of updates against current
+// counts.
+func checkChildrenCounts(
+	r *PinChildrenUpdateRequest,
+	counts map[string]uint16,
+) error {
+	for _, updates := range [][]*PinChildrenUpdate{r.Incs, r.Decs} {
+		for _, u := range updates {
+			if u.Count == nil {
+				continue
+			}
+			c, err := cid.Parse(u.Cid)
+			if err != nil {
+				return ErrInvalidCID
+			}
+			if cnt := counts[countKey(c, u.Recursive)]; cnt != *u.Count {
+				return fmt.Errorf(
+					"%w: %v has count %v, expected %v",
+					ErrCountMismatch,
+					u.Cid,
+					cnt,
+					*u.Count,
+				)
+			}
+		}
+	}
+	return nil
+}
+
+// previewChildrenUpdate checks updates as the pinner does without
+// applying them.
+func previewChildrenUpdate(
+	decs []*rcpinner.UpdateCount,
+	counts map[string]uint16,
+) error {
+	for _, u := range decs {
+		if counts[countKey(u.CID, u.Recursive)] == 0 {
+			return pinneriface.ErrNotPinned
+		}
+	}
+	return nil
+}
+
+// applyChildrenUpdate returns counts after updates.
+func applyChildrenUpdate(
+	incs []*rcpinner.UpdateCount,
+	decs []*rcpinner.UpdateCount,
+	counts map[string]uint16,
+) map[string]uint16 {
+	res := map[string]uint16{}
+	for k, v := range counts {
+		res[k] = v
+	}
+	for _, u := range incs {
+		res[countKey(u.CID, u.Recursive)]++
+	}
+	for _, u := range decs {
+		if k := countKey(u.CID, u.Recursive); res[k] > 0 {
+			res[k]--
+		}
+	}
+	return res
+}
+
+// decodeLinks decodes a block with the IPLD codec of its CID and returns
+// all links found in the node, including nested ones.
+func decodeLinks(c cid.Cid, data []byte) ([]cid.Cid, error) {
+	dec, err := multicodec.LookupDecoder(c.Prefix().Codec)
+	if err != nil {
+		return nil, err
+	}
+
+	nb := basicnode.Prototype.Any.NewBuilder()
+	if err := dec(nb, bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	lnks, err := traversal.SelectLinks(nb.Build())
+	if err != nil {
+		return nil, err
+	}
+
+	var links []cid.Cid
+	for _, l := range lnks {
+		if cl, ok := l.(cidlink.Link); ok {
+			links = append(links, cl.Cid)
+		}
+	}
+	return links, nil
+}
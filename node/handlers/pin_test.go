@@ -17,9 +17,19 @@ import (
 	"github.com/ipfs/boxo/ipld/merkledag"
 	pinneriface "github.com/ipfs/boxo/pinning/pinner"
 	util "github.com/ipfs/boxo/util"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
 	ds "github.com/ipfs/go-datastore"
 	dssync "github.com/ipfs/go-datastore/sync"
+	ipld "github.com/ipfs/go-ipld-format"
 	"github.com/ipfs/kubo/core"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/fluent/qp"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	ipldmulticodec "github.com/ipld/go-ipld-prime/multicodec"
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
 
 	"github.com/photon-storage/go-common/testing/require"
 	"github.com/photon-storage/go-gw3/common/http"
@@ -192,6 +202,151 @@ func TestPinListMultiBatches(t *testing.T) {
 	}
 }
 
+func TestPinListPage(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	bstore := blockstore.NewBlockstore(dstore)
+	bserv := bs.New(bstore, offline.Exchange(bstore))
+	dserv := merkledag.NewDAGService(bserv)
+	rcp, err := rcpinner.New(ctx, dstore, dserv)
+	require.NoError(t, err)
+	pinner := &com.WrappedPinner{
+		Pinner:    rcp,
+		Datastore: dstore,
+	}
+
+	h := New(
+		&core.IpfsNode{
+			Pinning: pinner,
+		},
+		nil,
+		nil,
+	)
+
+	// Every third node is pinned twice.
+	var nodes []*merkledag.ProtoNode
+	for i := 0; i < 25; i++ {
+		nd := rndNode(t)
+		require.NoError(t, dserv.Add(ctx, nd))
+		require.NoError(t, pinner.Pin(ctx, nd, true))
+		if i%3 == 0 {
+			require.NoError(t, pinner.Pin(ctx, nd, true))
+		}
+		nodes = append(nodes, nd)
+	}
+
+	list := func(params string) (int, *PinListResult) {
+		r := httptest.NewRequest(
+			gohttp.MethodGet,
+			"/api/v0/pin/ls?recursive=1&"+params,
+			nil,
+		)
+		w := httptest.NewRecorder()
+		h.PinList()(w, r)
+		var res PinListResult
+		decodeResp(t, w, &res)
+		return w.Code, &res
+	}
+
+	cases := []struct {
+		name   string
+		params string
+		code   int
+		count  int
+		total  int64
+	}{
+		{
+			name:   "first page with total",
+			params: "limit=10&total=true",
+			code:   gohttp.StatusOK,
+			count:  10,
+			total:  25,
+		},
+		{
+			name:   "min count",
+			params: "limit=100&min_count=2&total=true",
+			code:   gohttp.StatusOK,
+			count:  9,
+			total:  9,
+		},
+		{
+			name:   "max count",
+			params: "limit=100&max_count=1&total=true",
+			code:   gohttp.StatusOK,
+			count:  16,
+			total:  16,
+		},
+		{
+			name: "prefix",
+			params: "limit=100&total=true&prefix=" +
+				nodes[0].Cid().String(),
+			code:  gohttp.StatusOK,
+			count: 1,
+			total: 1,
+		},
+		{
+			name:   "invalid limit",
+			params: "limit=1001",
+			code:   gohttp.StatusBadRequest,
+		},
+		{
+			name:   "invalid count",
+			params: "limit=10&min_count=-1",
+			code:   gohttp.StatusBadRequest,
+		},
+		{
+			name:   "invalid cursor",
+			params: "cursor=invalid",
+			code:   gohttp.StatusBadRequest,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			code, res := list(c.params)
+			require.Equal(t, c.code, code)
+			if c.code != gohttp.StatusOK {
+				require.False(t, res.Success)
+				return
+			}
+			require.True(t, res.Success)
+			require.Equal(t, c.count, len(res.Batch))
+			require.Equal(t, c.total, *res.Total)
+		})
+	}
+
+	// Paging through the index while pins change.
+	code, res := list("limit=10")
+	require.Equal(t, gohttp.StatusOK, code)
+	require.Nil(t, res.Total)
+	require.NotEqual(t, "", res.Cursor)
+	listed := batchMap(res.Batch)
+
+	added := rndNode(t)
+	require.NoError(t, dserv.Add(ctx, added))
+	require.NoError(t, pinner.Pin(ctx, added, true))
+	removed, err := cid.Decode(res.Batch[0].Cid)
+	require.NoError(t, err)
+	for i := 0; i < res.Batch[0].Count; i++ {
+		require.NoError(t, pinner.Unpin(ctx, removed, true))
+	}
+
+	for res.Cursor != "" {
+		code, res = list("limit=10&cursor=" + res.Cursor)
+		require.Equal(t, gohttp.StatusOK, code)
+		for _, v := range res.Batch {
+			_, ok := listed[v.Cid]
+			require.False(t, ok)
+			listed[v.Cid] = v.Count
+		}
+	}
+	for _, nd := range nodes {
+		_, ok := listed[nd.Cid().String()]
+		require.True(t, ok)
+	}
+}
+
 func TestPinnedCount(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -588,6 +743,334 @@ func TestPinChildrenUpdate(t *testing.T) {
 	}
 }
 
+func TestPinChildrenUpdateDryRun(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	bstore := blockstore.NewBlockstore(dstore)
+	bserv := bs.New(bstore, offline.Exchange(bstore))
+	dserv := merkledag.NewDAGService(bserv)
+	rcp, err := rcpinner.New(ctx, dstore, dserv)
+	require.NoError(t, err)
+	pinner := &com.WrappedPinner{
+		Pinner: rcp,
+	}
+
+	// A{B,C}
+	a := rndNode(t)
+	b := rndNode(t)
+	c := rndNode(t)
+	require.NoError(t, a.AddNodeLink("c0", b))
+	require.NoError(t, a.AddNodeLink("c1", c))
+	require.NoError(t, dserv.Add(ctx, a))
+	require.NoError(t, dserv.Add(ctx, b))
+	require.NoError(t, dserv.Add(ctx, c))
+	require.NoError(t, pinner.Pin(ctx, a, true))
+	require.NoError(t, pinner.Pin(ctx, b, true))
+	require.NoError(t, pinner.Pin(ctx, c, false))
+
+	h := New(
+		&core.IpfsNode{
+			Pinning: pinner,
+		},
+		&mockAPI{
+			dag: &mockAPIDag{
+				DAGService: dserv,
+			},
+			block: newMockAPIBlock(a),
+		},
+		nil,
+	)
+
+	call := func(
+		dryRun bool,
+		incs []*PinChildrenUpdate,
+		decs []*PinChildrenUpdate,
+	) (int, *PinChildrenUpdateResult) {
+		data, err := json.Marshal(&PinChildrenUpdateRequest{
+			Root: a.Cid().String(),
+			Incs: incs,
+			Decs: decs,
+		})
+		require.NoError(t, err)
+
+		r, err := gohttp.NewRequest(
+			gohttp.MethodGet,
+			fmt.Sprintf("/api/v0/pin/children_update?dry_run=%v", dryRun),
+			bytes.NewReader(data),
+		)
+		require.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		h.PinChildrenUpdate()(w, r)
+		var res PinChildrenUpdateResult
+		decodeResp(t, w, &res)
+		return w.Code, &res
+	}
+	count := func(nd *merkledag.ProtoNode, recursive bool) uint16 {
+		cnt, err := pinner.GetCount(ctx, nd.Cid(), recursive)
+		require.NoError(t, err)
+		return cnt
+	}
+	expect := func(v uint16) *uint16 {
+		return &v
+	}
+	sz := func(nd *merkledag.ProtoNode) int64 {
+		return int64(len(nd.RawData()))
+	}
+
+	// Preview does not change counts.
+	code, res := call(
+		true,
+		[]*PinChildrenUpdate{
+			&PinChildrenUpdate{Cid: b.Cid().String(), Recursive: true},
+		},
+		[]*PinChildrenUpdate{
+			&PinChildrenUpdate{Cid: c.Cid().String(), Recursive: false},
+		},
+	)
+	require.Equal(t, gohttp.StatusOK, code)
+	require.True(t, res.Success)
+	require.True(t, res.DryRun)
+	require.Equal(t, uint16(1), count(b, true))
+	require.Equal(t, uint16(1), count(c, false))
+	require.Equal(t, 2, len(res.Deltas))
+	require.DeepEqual(t, &PinChildrenUpdateDelta{
+		Cid:                   b.Cid().String(),
+		Recursive:             true,
+		Count:                 1,
+		NewCount:              2,
+		DeduplicatedSize:      sz(b),
+		DeduplicatedNumBlocks: 1,
+		TotalSize:             sz(b),
+		TotalNumBlocks:        1,
+	}, res.Deltas[0])
+	require.DeepEqual(t, &PinChildrenUpdateDelta{
+		Cid:                   c.Cid().String(),
+		Recursive:             false,
+		Count:                 1,
+		NewCount:              0,
+		DeduplicatedSize:      -sz(c),
+		DeduplicatedNumBlocks: -1,
+		TotalSize:             -sz(c),
+		TotalNumBlocks:        -1,
+	}, res.Deltas[1])
+	require.DeepEqual(t, &PinChildrenUpdateStats{
+		DeduplicatedSize:      sz(b) - sz(c),
+		DeduplicatedNumBlocks: 0,
+		TotalSize:             sz(b) - sz(c),
+		TotalNumBlocks:        0,
+		UnreferencedSize:      sz(c),
+		UnreferencedNumBlocks: 1,
+	}, res.Stats)
+
+	// Preview fails as the update does.
+	code, res = call(
+		true,
+		nil,
+		[]*PinChildrenUpdate{
+			&PinChildrenUpdate{Cid: c.Cid().String(), Recursive: true},
+		},
+	)
+	require.Equal(t, gohttp.StatusBadRequest, code)
+	require.False(t, res.Success)
+	require.True(t, strings.Contains(res.Message, pinneriface.ErrNotPinned.Error()))
+
+	// Expected count mismatch.
+	for _, dryRun := range []bool{true, false} {
+		code, res = call(
+			dryRun,
+			[]*PinChildrenUpdate{
+				&PinChildrenUpdate{
+					Cid:       b.Cid().String(),
+					Recursive: true,
+					Count:     expect(2),
+				},
+			},
+			nil,
+		)
+		require.Equal(t, gohttp.StatusConflict, code)
+		require.False(t, res.Success)
+		require.True(t, strings.Contains(res.Message, ErrCountMismatch.Error()))
+		require.Equal(t, uint16(1), count(b, true))
+	}
+
+	// Expected count matches.
+	code, res = call(
+		false,
+		[]*PinChildrenUpdate{
+			&PinChildrenUpdate{
+				Cid:       b.Cid().String(),
+				Recursive: true,
+				Count:     expect(1),
+			},
+		},
+		[]*PinChildrenUpdate{
+			&PinChildrenUpdate{
+				Cid:       c.Cid().String(),
+				Recursive: false,
+				Count:     expect(1),
+			},
+		},
+	)
+	require.Equal(t, gohttp.StatusOK, code)
+	require.True(t, res.Success)
+	require.False(t, res.DryRun)
+	require.Equal(t, uint16(2), count(b, true))
+	require.Equal(t, uint16(0), count(c, false))
+	require.Equal(t, sz(c), res.Stats.UnreferencedSize)
+}
+
+func TestPinChildrenUpdateCodecs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	bstore := blockstore.NewBlockstore(dstore)
+	bserv := bs.New(bstore, offline.Exchange(bstore))
+	dserv := merkledag.NewDAGService(bserv)
+	rcp, err := rcpinner.New(ctx, dstore, dserv)
+	require.NoError(t, err)
+	pinner := &com.WrappedPinner{
+		Pinner: rcp,
+	}
+
+	b := rndNode(t)
+	c := rndNode(t)
+	d := rndNode(t)
+	require.NoError(t, dserv.Add(ctx, b))
+	require.NoError(t, dserv.Add(ctx, c))
+	require.NoError(t, dserv.Add(ctx, d))
+
+	for _, codec := range []uint64{cid.DagCBOR, cid.DagJSON} {
+		t.Run(multicodec.Code(codec).String(), func(t *testing.T) {
+			a := linkedNode(t, ctx, bstore, dserv, codec, b.Cid(), c.Cid())
+			require.NoError(t, pinner.Pin(ctx, a, true))
+
+			h := New(
+				&core.IpfsNode{
+					Pinning: pinner,
+				},
+				&mockAPI{
+					dag: &mockAPIDag{
+						DAGService: dserv,
+					},
+					block: newMockAPIBlock(a),
+				},
+				nil,
+			)
+
+			call := func(child *merkledag.ProtoNode) (int, *PinChildrenUpdateResult) {
+				data, err := json.Marshal(&PinChildrenUpdateRequest{
+					Root: a.Cid().String(),
+					Incs: []*PinChildrenUpdate{
+						&PinChildrenUpdate{
+							Cid:       child.Cid().String(),
+							Recursive: true,
+						},
+					},
+				})
+				require.NoError(t, err)
+
+				r, err := gohttp.NewRequest(
+					gohttp.MethodGet,
+					"/api/v0/pin/children_update",
+					bytes.NewReader(data),
+				)
+				require.NoError(t, err)
+
+				w := httptest.NewRecorder()
+				h.PinChildrenUpdate()(w, r)
+				var res PinChildrenUpdateResult
+				decodeResp(t, w, &res)
+				return w.Code, &res
+			}
+
+			// Links nested in maps and lists are children.
+			for _, child := range []*merkledag.ProtoNode{b, c} {
+				code, res := call(child)
+				require.Equal(t, gohttp.StatusOK, code)
+				require.True(t, res.Success)
+				require.Equal(
+					t,
+					uint64(len(child.RawData())),
+					res.Sizes[child.Cid().String()],
+				)
+			}
+
+			code, res := call(d)
+			require.Equal(t, gohttp.StatusBadRequest, code)
+			require.False(t, res.Success)
+			require.True(t, strings.Contains(res.Message, ErrCIDNotChild.Error()))
+		})
+	}
+
+	cnt, err := pinner.GetCount(ctx, b.Cid(), true)
+	require.NoError(t, err)
+	require.Equal(t, uint16(2), cnt)
+	cnt, err = pinner.GetCount(ctx, d.Cid(), true)
+	require.NoError(t, err)
+	require.Equal(t, uint16(0), cnt)
+}
+
+// linkedNode encodes a node with the codec linking to children. Links are
+// nested in a list of maps.
+func linkedNode(
+	t require.TestingTB,
+	ctx context.Context,
+	bstore blockstore.Blockstore,
+	dserv ipld.DAGService,
+	codec uint64,
+	children ...cid.Cid,
+) ipld.Node {
+	n, err := qp.BuildMap(
+		basicnode.Prototype.Any,
+		2,
+		func(ma datamodel.MapAssembler) {
+			qp.MapEntry(ma, "name", qp.String("root"))
+			qp.MapEntry(ma, "children", qp.List(
+				int64(len(children)),
+				func(la datamodel.ListAssembler) {
+					for _, c := range children {
+						qp.ListEntry(la, qp.Map(
+							1,
+							func(ma datamodel.MapAssembler) {
+								qp.MapEntry(
+									ma,
+									"link",
+									qp.Link(cidlink.Link{Cid: c}),
+								)
+							},
+						))
+					}
+				},
+			))
+		},
+	)
+	require.NoError(t, err)
+
+	enc, err := ipldmulticodec.LookupEncoder(codec)
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	require.NoError(t, enc(n, &buf))
+
+	c, err := cid.Prefix{
+		Version:  1,
+		Codec:    codec,
+		MhType:   multihash.SHA2_256,
+		MhLength: -1,
+	}.Sum(buf.Bytes())
+	require.NoError(t, err)
+	blk, err := blocks.NewBlockWithCid(buf.Bytes(), c)
+	require.NoError(t, err)
+	require.NoError(t, bstore.Put(ctx, blk))
+
+	nd, err := dserv.Get(ctx, c)
+	require.NoError(t, err)
+	return nd
+}
+
 func batchMap(b []*CidCount) map[string]int {
 	m := map[string]int{}
 	for _, v := range b {
@@ -0,0 +1,405 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	gohttp "net/http"
+
+	"github.com/ipfs/boxo/coreiface/options"
+	"github.com/ipfs/boxo/coreiface/path"
+	"github.com/ipfs/boxo/ipld/merkledag"
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	"go.uber.org/atomic"
+
+	"github.com/photon-storage/go-common/log"
+	"github.com/photon-storage/go-gw3/common/http"
+	rcpinner "github.com/photon-storage/go-rc-pinner"
+
+	"github.com/photon-storage/falcon/node/com"
+	"github.com/photon-storage/falcon/node/tracing"
+)
+
+const (
+	maxPinBatchSize = 1000
+)
+
+var (
+	ErrEmptyBatch    = errors.New("empty batch")
+	ErrBatchTooLarge = errors.New("batch too large")
+)
+
+type PinBatchItem struct {
+	Cid       string `json:"cid"`
+	Recursive bool   `json:"recursive"`
+}
+
+type PinBatchRequest struct {
+	Pins []*PinBatchItem `json:"pins"`
+}
+
+type PinBatchItemResult struct {
+	Cid                   string `json:"cid"`
+	Recursive             bool   `json:"recursive"`
+	Success               bool   `json:"success"`
+	DeduplicatedSize      int64  `json:"duplicated_size"`
+	DeduplicatedNumBlocks int64  `json:"duplicated_num_blocks"`
+	TotalSize             int64  `json:"total_size"`
+	TotalNumBlocks        int64  `json:"total_num_blocks"`
+	Message               string `json:"message"`
+}
+
+// PinBatchResult reports per-item results. Success is true only if all
+// items succeed. DAG stats are aggregated from successful items.
+type PinBatchResult struct {
+	Success               bool                  `json:"success"`
+	DeduplicatedSize      int64                 `json:"duplicated_size"`
+	DeduplicatedNumBlocks int64                 `json:"duplicated_num_blocks"`
+	TotalSize             int64                 `json:"total_size"`
+	TotalNumBlocks        int64                 `json:"total_num_blocks"`
+	Results               []*PinBatchItemResult `json:"results"`
+	Message               string                `json:"message"`
+}
+
+type pinBatchItem struct {
+	cid       cid.Cid
+	recursive bool
+	// Set on unpin if the account had pinned the item.
+	owned bool
+}
+
+// PinAddBatch pins a list of CIDs in one request. Items are pinned in
+// order with a shared fetch session and concurrency budget. A failed item
+// does not abort the rest of the batch.
+func (h *ExtendedHandlers) PinAddBatch() gohttp.HandlerFunc {
+	return gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		ctx, span := tracing.Span(r.Context(), "Handlers", "PinAddBatch")
+		defer span.End()
+		r = r.WithContext(ctx)
+
+		items, err := parsePinBatch(r)
+		if err != nil {
+			writeJSON(
+				w,
+				gohttp.StatusBadRequest,
+				&PinBatchResult{
+					Success: false,
+					Message: fmt.Sprintf("error parsing params: %v", err),
+				},
+			)
+			return
+		}
+
+		cc, err := parseConcurrencyParam(r)
+		if err != nil {
+			writeJSON(
+				w,
+				gohttp.StatusBadRequest,
+				&PinBatchResult{
+					Success: false,
+					Message: fmt.Sprintf("error parsing params: %v", err),
+				},
+			)
+			return
+		}
+		if cc == 0 {
+			cc = 32
+		}
+		ctx = rcpinner.WithConcurrency(r.Context(), cc)
+
+		args, err := parseSignedArgs(r)
+		if err != nil {
+			writeJSON(
+				w,
+				gohttp.StatusBadRequest,
+				&PinBatchResult{
+					Success: false,
+					Message: fmt.Sprintf("error parsing params: %v", err),
+				},
+			)
+			return
+		}
+
+		meta, err := parsePinMeta(r, args)
+		if err != nil {
+			writeJSON(
+				w,
+				gohttp.StatusBadRequest,
+				&PinBatchResult{
+					Success: false,
+					Message: fmt.Sprintf("error parsing params: %v", err),
+				},
+			)
+			return
+		}
+		pinner := com.GetRcPinner(h.nd.Pinning)
+
+		account := args.GetArg(http.ArgP3AcctID)
+		ctx, guard, err := h.quotas.enforce(ctx, account, int64(len(items)))
+		if err != nil {
+			writeJSON(
+				w,
+				quotaErrorCode(err),
+				&PinBatchResult{
+					Success: false,
+					Message: fmt.Sprintf("error checking quota: %v", err),
+				},
+			)
+			return
+		}
+		defer guard.stop()
+
+		// Blocks are fetched through a shared session first, which counts
+		// the size against the quota guard. Pinning walks the local DAG
+		// afterwards and is not counted again.
+		pinCtx := rcpinner.WithDagSize(ctx, (*atomic.Uint64)(nil))
+		sess := &sessionDAG{
+			DAGService: h.dagService(),
+		}
+		sess.ng = merkledag.NewSession(ctx, sess.DAGService)
+
+		writeJSON(
+			w,
+			gohttp.StatusOK,
+			h.runPinBatch(
+				ctx,
+				account,
+				items,
+				func(ctx context.Context, it *pinBatchItem) error {
+					depth := 0
+					if it.recursive {
+						depth = -1
+					}
+					if err := rcpinner.FetchGraphWithDepthLimit(
+						ctx,
+						it.cid,
+						depth,
+						sess,
+					); err != nil {
+						if guard.isExceeded() {
+							return ErrQuotaExceeded
+						}
+						return fmt.Errorf("error fetching: %w", err)
+					}
+					if err := h.api.Pin().Add(
+						pinCtx,
+						path.IpfsPath(it.cid),
+						options.Pin.Recursive(it.recursive),
+					); err != nil {
+						if guard.isExceeded() {
+							return ErrQuotaExceeded
+						}
+						return fmt.Errorf("error pinning: %w", err)
+					}
+					addPinMeta(ctx, pinner, it.cid, it.recursive, meta)
+					return nil
+				},
+				false,
+			),
+		)
+	})
+}
+
+// PinRmBatch unpins a list of CIDs in one request.
+func (h *ExtendedHandlers) PinRmBatch() gohttp.HandlerFunc {
+	return gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		ctx, span := tracing.Span(r.Context(), "Handlers", "PinRmBatch")
+		defer span.End()
+		r = r.WithContext(ctx)
+
+		items, err := parsePinBatch(r)
+		if err != nil {
+			writeJSON(
+				w,
+				gohttp.StatusBadRequest,
+				&PinBatchResult{
+					Success: false,
+					Message: fmt.Sprintf("error parsing params: %v", err),
+				},
+			)
+			return
+		}
+
+		args, err := parseSignedArgs(r)
+		if err != nil {
+			writeJSON(
+				w,
+				gohttp.StatusBadRequest,
+				&PinBatchResult{
+					Success: false,
+					Message: fmt.Sprintf("error parsing params: %v", err),
+				},
+			)
+			return
+		}
+		owner := args.GetArg(http.ArgP3AcctID)
+		pinner := com.GetRcPinner(h.nd.Pinning)
+
+		writeJSON(
+			w,
+			gohttp.StatusOK,
+			h.runPinBatch(
+				r.Context(),
+				owner,
+				items,
+				func(ctx context.Context, it *pinBatchItem) error {
+					if err := h.api.Pin().Rm(
+						ctx,
+						path.IpfsPath(it.cid),
+						options.Pin.RmRecursive(it.recursive),
+					); err != nil {
+						return fmt.Errorf("error unpinning: %w", err)
+					}
+					it.owned = removePinMeta(
+						ctx,
+						pinner,
+						it.cid,
+						it.recursive,
+						owner,
+					)
+					consumePinExpiry(ctx, h.ttl, it.cid, it.recursive, owner)
+					return nil
+				},
+				true,
+			),
+		)
+	})
+}
+
+// runPinBatch applies fn to each item and collects DAG stats. Stats are
+// added to or subtracted from the request DagStats so usage is reported
+// as a single record. The account quota usage is updated per item.
+func (h *ExtendedHandlers) runPinBatch(
+	ctx context.Context,
+	account string,
+	items []*pinBatchItem,
+	fn func(ctx context.Context, it *pinBatchItem) error,
+	remove bool,
+) *PinBatchResult {
+	aggrDs := getDagStatsFromCtx(ctx)
+	total := NewDagStats()
+	res := &PinBatchResult{
+		Success: true,
+		Message: "ok",
+	}
+	for _, it := range items {
+		ir := &PinBatchItemResult{
+			Cid:       it.cid.String(),
+			Recursive: it.recursive,
+		}
+		res.Results = append(res.Results, ir)
+
+		if err := ctx.Err(); err != nil {
+			ir.Message = err.Error()
+			res.Success = false
+			continue
+		}
+		if err := fn(ctx, it); err != nil {
+			ir.Message = err.Error()
+			res.Success = false
+			continue
+		}
+
+		ds := NewDagStats()
+		if err := h.dagCache.Calculate(
+			ctx,
+			h.api,
+			it.cid,
+			it.recursive,
+			ds,
+		); err != nil {
+			// Ignore stats error.
+			log.Error("Error calculating dag stats",
+				"error", err,
+				"cid", it.cid.String(),
+				"source", "pin batch",
+			)
+		}
+		if aggrDs != nil {
+			if remove {
+				aggrDs.Sub(ds)
+			} else {
+				aggrDs.Add(ds)
+			}
+		}
+		total.Add(ds)
+		// Only unpins of the account pins are credited.
+		if !remove || it.owned {
+			h.quotas.record(ctx, account, ds, remove)
+		}
+
+		ir.Success = true
+		ir.DeduplicatedSize = ds.DeduplicatedSize.Load()
+		ir.DeduplicatedNumBlocks = ds.DeduplicatedNumBlocks.Load()
+		ir.TotalSize = ds.TotalSize.Load()
+		ir.TotalNumBlocks = ds.TotalNumBlocks.Load()
+	}
+
+	if !res.Success {
+		res.Message = "some items failed"
+	}
+	res.DeduplicatedSize = total.DeduplicatedSize.Load()
+	res.DeduplicatedNumBlocks = total.DeduplicatedNumBlocks.Load()
+	res.TotalSize = total.TotalSize.Load()
+	res.TotalNumBlocks = total.TotalNumBlocks.Load()
+	return res
+}
+
+func parsePinBatch(r *gohttp.Request) ([]*pinBatchItem, error) {
+	var data []byte
+	if r.Body != nil {
+		data, _ = io.ReadAll(r.Body)
+	}
+	var req PinBatchRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return nil, err
+	}
+	if len(req.Pins) == 0 {
+		return nil, ErrEmptyBatch
+	}
+	if len(req.Pins) > maxPinBatchSize {
+		return nil, ErrBatchTooLarge
+	}
+
+	items := make([]*pinBatchItem, 0, len(req.Pins))
+	for _, p := range req.Pins {
+		c, err := cid.Decode(p.Cid)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidCID, p.Cid)
+		}
+		items = append(items, &pinBatchItem{
+			cid:       c,
+			recursive: p.Recursive,
+		})
+	}
+	return items, nil
+}
+
+// dagService returns the node DAG service which supports fetch sessions,
+// falling back to the core API one.
+func (h *ExtendedHandlers) dagService() ipld.DAGService {
+	if h.nd != nil && h.nd.DAG != nil {
+		return h.nd.DAG
+	}
+	return h.api.Dag()
+}
+
+// sessionDAG serves reads of a DAG service from a shared session.
+type sessionDAG struct {
+	ipld.DAGService
+	ng ipld.NodeGetter
+}
+
+func (s *sessionDAG) Get(ctx context.Context, c cid.Cid) (ipld.Node, error) {
+	return s.ng.Get(ctx, c)
+}
+
+func (s *sessionDAG) GetMany(
+	ctx context.Context,
+	cs []cid.Cid,
+) <-chan *ipld.NodeOption {
+	return s.ng.GetMany(ctx, cs)
+}
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	gohttp "net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	bs "github.com/ipfs/boxo/blockservice"
+	"github.com/ipfs/boxo/blockstore"
+	"github.com/ipfs/boxo/exchange/offline"
+	"github.com/ipfs/boxo/ipld/merkledag"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipfs/kubo/core"
+
+	"github.com/photon-storage/go-common/testing/require"
+	rcpinner "github.com/photon-storage/go-rc-pinner"
+
+	"github.com/photon-storage/falcon/node/com"
+)
+
+func TestPinBatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	bstore := blockstore.NewBlockstore(dstore)
+	bserv := bs.New(bstore, offline.Exchange(bstore))
+	dserv := merkledag.NewDAGService(bserv)
+	rcp, err := rcpinner.New(ctx, dstore, dserv)
+	require.NoError(t, err)
+	pinner := &com.WrappedPinner{
+		Pinner: rcp,
+	}
+
+	// A{B,C}
+	a := rndNode(t)
+	b := rndNode(t)
+	c := rndNode(t)
+	missing := rndNode(t)
+	require.NoError(t, a.AddNodeLink("c0", b))
+	require.NoError(t, a.AddNodeLink("c1", c))
+	require.NoError(t, dserv.Add(ctx, a))
+	require.NoError(t, dserv.Add(ctx, b))
+	require.NoError(t, dserv.Add(ctx, c))
+
+	h := New(
+		&core.IpfsNode{
+			Pinning: pinner,
+		},
+		&mockAPI{
+			dag: &mockAPIDag{
+				DAGService: dserv,
+			},
+			pin: &mockAPIPin{
+				dag:    dserv,
+				pinner: pinner,
+			},
+		},
+		nil,
+	)
+
+	call := func(
+		handler gohttp.Handler,
+		body string,
+		stats *DagStats,
+	) (int, *PinBatchResult) {
+		r := httptest.NewRequest(
+			gohttp.MethodPost,
+			"/api/v0/pin/add/batch",
+			strings.NewReader(body),
+		)
+		r = r.WithContext(WithDagStat(r.Context(), stats))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		var res PinBatchResult
+		decodeResp(t, w, &res)
+		return w.Code, &res
+	}
+	encode := func(items ...*PinBatchItem) string {
+		var buf bytes.Buffer
+		require.NoError(t, json.NewEncoder(&buf).Encode(&PinBatchRequest{
+			Pins: items,
+		}))
+		return buf.String()
+	}
+	count := func(k *merkledag.ProtoNode, recursive bool) uint16 {
+		cnt, err := pinner.GetCount(ctx, k.Cid(), recursive)
+		require.NoError(t, err)
+		return cnt
+	}
+
+	cases := []struct {
+		name string
+		body string
+	}{
+		{
+			name: "invalid json",
+			body: "{",
+		},
+		{
+			name: "empty",
+			body: encode(),
+		},
+		{
+			name: "invalid cid",
+			body: encode(&PinBatchItem{Cid: "bad"}),
+		},
+		{
+			name: "too large",
+			body: encode(make([]*PinBatchItem, maxPinBatchSize+1)...),
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			code, res := call(h.PinAddBatch(), tc.body, nil)
+			require.Equal(t, gohttp.StatusBadRequest, code)
+			require.False(t, res.Success)
+			code, res = call(h.PinRmBatch(), tc.body, nil)
+			require.Equal(t, gohttp.StatusBadRequest, code)
+			require.False(t, res.Success)
+		})
+	}
+
+	// A failed item does not abort the batch.
+	stats := NewDagStats()
+	code, res := call(h.PinAddBatch(), encode(
+		&PinBatchItem{Cid: a.Cid().String(), Recursive: true},
+		&PinBatchItem{Cid: missing.Cid().String(), Recursive: true},
+		&PinBatchItem{Cid: b.Cid().String(), Recursive: false},
+	), stats)
+	require.Equal(t, gohttp.StatusOK, code)
+	require.False(t, res.Success)
+	require.Equal(t, 3, len(res.Results))
+	require.True(t, res.Results[0].Success)
+	require.Equal(t, totalSize(a, b, c), res.Results[0].TotalSize)
+	require.False(t, res.Results[1].Success)
+	require.True(t, res.Results[2].Success)
+	require.Equal(t, totalSize(b), res.Results[2].TotalSize)
+	require.Equal(t, totalSize(a, b, c, b), res.TotalSize)
+	require.Equal(t, int64(4), res.TotalNumBlocks)
+	require.Equal(t, res.TotalSize, stats.TotalSize.Load())
+	require.Equal(t, uint16(1), count(a, true))
+	require.Equal(t, uint16(1), count(b, false))
+	require.Equal(t, uint16(0), count(missing, true))
+
+	// Removal subtracts stats.
+	stats = NewDagStats()
+	code, res = call(h.PinRmBatch(), encode(
+		&PinBatchItem{Cid: a.Cid().String(), Recursive: true},
+		&PinBatchItem{Cid: b.Cid().String(), Recursive: false},
+	), stats)
+	require.Equal(t, gohttp.StatusOK, code)
+	require.True(t, res.Success)
+	require.Equal(t, totalSize(a, b, c, b), res.TotalSize)
+	require.Equal(t, -res.TotalSize, stats.TotalSize.Load())
+	require.Equal(t, uint16(0), count(a, true))
+	require.Equal(t, uint16(0), count(b, false))
+}
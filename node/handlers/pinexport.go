@@ -0,0 +1,397 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	gohttp "net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ipfs/boxo/blockstore"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/storage"
+	mh "github.com/multiformats/go-multihash"
+
+	"github.com/photon-storage/go-common/log"
+
+	"github.com/photon-storage/falcon/node/com"
+	"github.com/photon-storage/falcon/node/tracing"
+)
+
+const (
+	mediaTypeCAR = "application/vnd.ipld.car"
+
+	pinImportBatchSize = 256
+)
+
+var (
+	ErrInvalidPinExport = errors.New("invalid pin export")
+
+	// The pin index is stored as a raw JSON block, which is the only
+	// root of an export.
+	pinIndexPrefix = cid.Prefix{
+		Version:  1,
+		Codec:    cid.Raw,
+		MhType:   mh.SHA2_256,
+		MhLength: -1,
+	}
+)
+
+// PinExportResult summarizes an export.
+type PinExportResult struct {
+	Recursive int `json:"recursive"`
+	Direct    int `json:"direct"`
+	NumBlocks int `json:"num_blocks"`
+}
+
+// PinImportResult summarizes an import. Pins whose DAG is incomplete
+// after import are not restored and reported in Failed.
+type PinImportResult struct {
+	Success   bool               `json:"success"`
+	NumBlocks int                `json:"num_blocks"`
+	Recursive int                `json:"recursive"`
+	Direct    int                `json:"direct"`
+	Failed    []*PinVerifyResult `json:"failed,omitempty"`
+	Message   string             `json:"message"`
+}
+
+// ExportPins writes the pinner index as a CARv1 rooted at the index
+// block. Blocks of pinned DAGs are appended if withBlocks is set.
+func ExportPins(
+	ctx context.Context,
+	w io.Writer,
+	pinner *com.WrappedPinner,
+	bstore blockstore.Blockstore,
+	withBlocks bool,
+) (*PinExportResult, error) {
+	idx, err := pinner.ExportIndex(ctx, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return nil, err
+	}
+	root, err := pinIndexPrefix.Sum(data)
+	if err != nil {
+		return nil, err
+	}
+
+	car, err := storage.NewWritable(
+		w,
+		[]cid.Cid{root},
+		carv2.WriteAsCarV1(true),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := car.Put(ctx, root.KeyString(), data); err != nil {
+		return nil, err
+	}
+
+	res := &PinExportResult{
+		Recursive: len(idx.Recursive),
+		Direct:    len(idx.Direct),
+	}
+	if withBlocks {
+		visited := map[cid.Cid]bool{}
+		for _, recursive := range []bool{true, false} {
+			entries := idx.Recursive
+			if !recursive {
+				entries = idx.Direct
+			}
+			for _, e := range entries {
+				c, err := cid.Decode(e.Cid)
+				if err != nil {
+					return nil, err
+				}
+				n, err := exportDAG(ctx, car, bstore, c, recursive, visited)
+				if err != nil {
+					return nil, fmt.Errorf("error exporting %v: %w", c, err)
+				}
+				res.NumBlocks += n
+			}
+		}
+	}
+
+	if err := car.Finalize(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// exportDAG writes blocks of a DAG not visited yet. Only the root is
+// written for direct pins.
+func exportDAG(
+	ctx context.Context,
+	car storage.WritableCar,
+	bstore blockstore.Blockstore,
+	root cid.Cid,
+	recursive bool,
+	visited map[cid.Cid]bool,
+) (int, error) {
+	if visited[root] {
+		return 0, nil
+	}
+
+	n := 0
+	visited[root] = true
+	stack := []cid.Cid{root}
+	for len(stack) > 0 {
+		c := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		blk, err := bstore.Get(ctx, c)
+		if err != nil {
+			return n, err
+		}
+		if err := car.Put(ctx, c.KeyString(), blk.RawData()); err != nil {
+			return n, err
+		}
+		n++
+		if !recursive {
+			continue
+		}
+
+		links, err := decodeLinks(c, blk.RawData())
+		if err != nil {
+			return n, err
+		}
+		for _, l := range links {
+			if !visited[l] {
+				visited[l] = true
+				stack = append(stack, l)
+			}
+		}
+	}
+	return n, nil
+}
+
+// ImportPins reads an export written by ExportPins. Bundled blocks are
+// written to the blockstore, then each pinned DAG is verified before its
+// count is restored. Missing blocks are fetched from the network if fetch
+// is set.
+func ImportPins(
+	ctx context.Context,
+	r io.Reader,
+	pinner *com.WrappedPinner,
+	bstore blockstore.Blockstore,
+	verifier *PinVerifier,
+	fetch bool,
+) (*PinImportResult, error) {
+	br, err := carv2.NewBlockReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPinExport, err)
+	}
+	if len(br.Roots) != 1 {
+		return nil, fmt.Errorf("%w: expect one root", ErrInvalidPinExport)
+	}
+
+	res := &PinImportResult{}
+	var idx *com.PinIndex
+	var batch []blocks.Block
+	for {
+		blk, err := br.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPinExport, err)
+		}
+
+		if blk.Cid().Equals(br.Roots[0]) {
+			idx = &com.PinIndex{}
+			if err := json.Unmarshal(blk.RawData(), idx); err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidPinExport, err)
+			}
+			continue
+		}
+
+		batch = append(batch, blk)
+		if len(batch) >= pinImportBatchSize {
+			if err := bstore.PutMany(ctx, batch); err != nil {
+				return nil, err
+			}
+			batch = nil
+		}
+		res.NumBlocks++
+	}
+	if len(batch) > 0 {
+		if err := bstore.PutMany(ctx, batch); err != nil {
+			return nil, err
+		}
+	}
+
+	if idx == nil {
+		return nil, fmt.Errorf("%w: index not found", ErrInvalidPinExport)
+	}
+	if idx.Version != com.PinIndexVersion {
+		return nil, fmt.Errorf(
+			"%w: %v",
+			com.ErrUnsupportedIndexVersion,
+			idx.Version,
+		)
+	}
+
+	for _, recursive := range []bool{true, false} {
+		entries := idx.Recursive
+		if !recursive {
+			entries = idx.Direct
+		}
+		for _, e := range entries {
+			c, err := cid.Decode(e.Cid)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidCID, e.Cid)
+			}
+
+			vr := verifier.Verify(ctx, c, recursive, fetch)
+			if !vr.Ok {
+				res.Failed = append(res.Failed, vr)
+				continue
+			}
+			if _, err := pinner.RestoreCount(
+				ctx,
+				c,
+				recursive,
+				e.Count,
+				e.Meta,
+			); err != nil {
+				vr.Ok = false
+				vr.Message = err.Error()
+				res.Failed = append(res.Failed, vr)
+				continue
+			}
+
+			if recursive {
+				res.Recursive++
+			} else {
+				res.Direct++
+			}
+		}
+	}
+
+	res.Success = len(res.Failed) == 0
+	res.Message = "ok"
+	if !res.Success {
+		res.Message = "some pins failed"
+	}
+	return res, nil
+}
+
+// PinExport streams the pinner index as a CAR, bundling blocks of pinned
+// DAGs if blocks is set.
+func (h *ExtendedHandlers) PinExport() gohttp.HandlerFunc {
+	return gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		ctx, span := tracing.Span(r.Context(), "Handlers", "PinExport")
+		defer span.End()
+
+		withBlocks, err := parsePinExportBoolParam(r, "blocks")
+		if err != nil {
+			writeJSON(
+				w,
+				gohttp.StatusBadRequest,
+				&PinImportResult{
+					Success: false,
+					Message: fmt.Sprintf("error parsing params: %v", err),
+				},
+			)
+			return
+		}
+
+		w.Header().Set("Content-Type", mediaTypeCAR)
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		res, err := ExportPins(
+			ctx,
+			w,
+			com.GetRcPinner(h.nd.Pinning),
+			h.nd.Blockstore,
+			withBlocks,
+		)
+		if err != nil {
+			// The response may have been partially written.
+			log.Error("Error exporting pins", "error", err)
+			return
+		}
+		log.Info("Pins exported",
+			"recursive", res.Recursive,
+			"direct", res.Direct,
+			"blocks", res.NumBlocks,
+		)
+	})
+}
+
+// PinImport restores pins from an export in the request body. Missing
+// blocks are fetched from the network if fetch is set.
+func (h *ExtendedHandlers) PinImport() gohttp.HandlerFunc {
+	return gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		ctx, span := tracing.Span(r.Context(), "Handlers", "PinImport")
+		defer span.End()
+
+		if h.verifier == nil {
+			writeJSON(
+				w,
+				gohttp.StatusNotImplemented,
+				&PinImportResult{
+					Success: false,
+					Message: "pin verification is not enabled",
+				},
+			)
+			return
+		}
+
+		fetch, err := parsePinExportBoolParam(r, "fetch")
+		if err != nil {
+			writeJSON(
+				w,
+				gohttp.StatusBadRequest,
+				&PinImportResult{
+					Success: false,
+					Message: fmt.Sprintf("error parsing params: %v", err),
+				},
+			)
+			return
+		}
+
+		res, err := ImportPins(
+			ctx,
+			r.Body,
+			com.GetRcPinner(h.nd.Pinning),
+			h.nd.Blockstore,
+			h.verifier,
+			fetch,
+		)
+		if err != nil {
+			code := gohttp.StatusInternalServerError
+			if errors.Is(err, ErrInvalidPinExport) ||
+				errors.Is(err, ErrInvalidCID) ||
+				errors.Is(err, com.ErrUnsupportedIndexVersion) {
+				code = gohttp.StatusBadRequest
+			}
+			writeJSON(
+				w,
+				code,
+				&PinImportResult{
+					Success: false,
+					Message: fmt.Sprintf("error importing pins: %v", err),
+				},
+			)
+			return
+		}
+
+		writeJSON(w, gohttp.StatusOK, res)
+	})
+}
+
+func parsePinExportBoolParam(r *gohttp.Request, key string) (bool, error) {
+	str := strings.TrimSpace(r.URL.Query().Get(key))
+	if str == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(str)
+}
@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	gohttp "net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	bs "github.com/ipfs/boxo/blockservice"
+	"github.com/ipfs/boxo/blockstore"
+	"github.com/ipfs/boxo/exchange/offline"
+	"github.com/ipfs/boxo/ipld/merkledag"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipfs/kubo/core"
+
+	"github.com/photon-storage/go-common/testing/require"
+	rcpinner "github.com/photon-storage/go-rc-pinner"
+
+	"github.com/photon-storage/falcon/node/com"
+)
+
+func newTestPinNode(
+	t *testing.T,
+	ctx context.Context,
+) (blockstore.Blockstore, *com.WrappedPinner) {
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	bstore := blockstore.NewBlockstore(dstore)
+	dserv := merkledag.NewDAGService(bs.New(bstore, offline.Exchange(bstore)))
+	rcp, err := rcpinner.New(ctx, dstore, dserv)
+	require.NoError(t, err)
+	return bstore, &com.WrappedPinner{
+		Pinner:    rcp,
+		Datastore: dstore,
+	}
+}
+
+func TestPinExportImport(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srcBstore, src := newTestPinNode(t, ctx)
+
+	// A{B, C} recursive twice, D direct.
+	a := rndNode(t)
+	b := rndNode(t)
+	c := rndNode(t)
+	d := rndNode(t)
+	require.NoError(t, a.AddNodeLink("c0", b))
+	require.NoError(t, a.AddNodeLink("c1", c))
+	for _, nd := range []*merkledag.ProtoNode{a, b, c, d} {
+		require.NoError(t, srcBstore.Put(ctx, nd))
+	}
+	for i := 0; i < 2; i++ {
+		require.NoError(t, src.Pin(ctx, a, true))
+		require.NoError(t, src.AddMeta(ctx, a.Cid(), true, &com.PinMeta{
+			Owner:     "alice",
+			CreatedAt: int64(i + 1),
+		}))
+	}
+	require.NoError(t, src.Pin(ctx, d, false))
+
+	export := func(withBlocks bool) []byte {
+		var buf bytes.Buffer
+		res, err := ExportPins(ctx, &buf, src, srcBstore, withBlocks)
+		require.NoError(t, err)
+		require.Equal(t, 1, res.Recursive)
+		require.Equal(t, 1, res.Direct)
+		if withBlocks {
+			require.Equal(t, 4, res.NumBlocks)
+		} else {
+			require.Equal(t, 0, res.NumBlocks)
+		}
+		return buf.Bytes()
+	}
+	count := func(
+		p *com.WrappedPinner,
+		nd *merkledag.ProtoNode,
+		recursive bool,
+	) uint16 {
+		cnt, err := p.GetCount(ctx, nd.Cid(), recursive)
+		require.NoError(t, err)
+		return cnt
+	}
+
+	// Import with bundled blocks.
+	data := export(true)
+	dstBstore, dst := newTestPinNode(t, ctx)
+	imp := func(
+		bstore blockstore.Blockstore,
+		p *com.WrappedPinner,
+	) *PinImportResult {
+		res, err := ImportPins(
+			ctx,
+			bytes.NewReader(data),
+			p,
+			bstore,
+			NewPinVerifier(bstore, nil, p),
+			false,
+		)
+		require.NoError(t, err)
+		return res
+	}
+	res := imp(dstBstore, dst)
+	require.True(t, res.Success)
+	require.Equal(t, 4, res.NumBlocks)
+	require.Equal(t, 1, res.Recursive)
+	require.Equal(t, 1, res.Direct)
+	require.Equal(t, uint16(2), count(dst, a, true))
+	require.Equal(t, uint16(1), count(dst, d, false))
+	metas, err := dst.ListMeta(ctx, a.Cid(), true)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(metas))
+	require.Equal(t, "alice", metas[0].Owner)
+
+	// Importing twice does not change counts or metadata.
+	res = imp(dstBstore, dst)
+	require.True(t, res.Success)
+	require.Equal(t, uint16(2), count(dst, a, true))
+	metas, err = dst.ListMeta(ctx, a.Cid(), true)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(metas))
+
+	// Pins without blocks are not restored.
+	emptyBstore, empty := newTestPinNode(t, ctx)
+	require.NoError(t, emptyBstore.Put(ctx, d))
+	data = export(false)
+	res = imp(emptyBstore, empty)
+	require.False(t, res.Success)
+	require.Equal(t, 0, res.Recursive)
+	require.Equal(t, 1, res.Direct)
+	require.Equal(t, 1, len(res.Failed))
+	require.Equal(t, a.Cid().String(), res.Failed[0].Cid)
+	require.DeepEqual(t, []string{a.Cid().String()}, res.Failed[0].Missing)
+	require.Equal(t, uint16(0), count(empty, a, true))
+
+	// Missing blocks are fetched if requested.
+	srcDserv := merkledag.NewDAGService(
+		bs.New(srcBstore, offline.Exchange(srcBstore)),
+	)
+	res, err = ImportPins(
+		ctx,
+		bytes.NewReader(data),
+		empty,
+		emptyBstore,
+		NewPinVerifier(emptyBstore, srcDserv, empty),
+		true,
+	)
+	require.NoError(t, err)
+	require.True(t, res.Success)
+	require.Equal(t, uint16(2), count(empty, a, true))
+
+	// Invalid export.
+	_, err = ImportPins(
+		ctx,
+		strings.NewReader("invalid"),
+		empty,
+		emptyBstore,
+		NewPinVerifier(emptyBstore, nil, empty),
+		false,
+	)
+	require.ErrorIs(t, ErrInvalidPinExport, err)
+
+	// Export and import through the API.
+	h := New(
+		&core.IpfsNode{
+			Pinning: src,
+			Blockstore: blockstore.NewGCBlockstore(
+				srcBstore,
+				blockstore.NewGCLocker(),
+			),
+		},
+		nil,
+		nil,
+	)
+	w := httptest.NewRecorder()
+	h.PinExport()(
+		w,
+		httptest.NewRequest(
+			gohttp.MethodGet,
+			"/api/v0/pin/export?blocks=true",
+			nil,
+		),
+	)
+	require.Equal(t, gohttp.StatusOK, w.Code)
+	require.Equal(t, mediaTypeCAR, w.Header().Get("Content-Type"))
+
+	apiBstore, api := newTestPinNode(t, ctx)
+	h = New(
+		&core.IpfsNode{
+			Pinning: api,
+			Blockstore: blockstore.NewGCBlockstore(
+				apiBstore,
+				blockstore.NewGCLocker(),
+			),
+		},
+		nil,
+		nil,
+	)
+	h.SetPinVerifier(NewPinVerifier(apiBstore, nil, api))
+	r := httptest.NewRequest(gohttp.MethodPost, "/api/v0/pin/import", w.Body)
+	w = httptest.NewRecorder()
+	h.PinImport()(w, r)
+	require.Equal(t, gohttp.StatusOK, w.Code)
+	var ires PinImportResult
+	decodeResp(t, w, &ires)
+	require.True(t, ires.Success)
+	require.Equal(t, uint16(2), count(api, a, true))
+
+	r = httptest.NewRequest(
+		gohttp.MethodPost,
+		"/api/v0/pin/import",
+		strings.NewReader("invalid"),
+	)
+	w = httptest.NewRecorder()
+	h.PinImport()(w, r)
+	require.Equal(t, gohttp.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,915 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	gohttp "net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	coreiface "github.com/ipfs/boxo/coreiface"
+	"github.com/ipfs/boxo/coreiface/options"
+	"github.com/ipfs/boxo/coreiface/path"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"go.uber.org/atomic"
+
+	"github.com/photon-storage/go-common/log"
+	"github.com/photon-storage/go-common/metrics"
+	"github.com/photon-storage/go-gw3/common/http"
+	"github.com/photon-storage/go-gw3/common/reporting"
+	rcpinner "github.com/photon-storage/go-rc-pinner"
+
+	"github.com/photon-storage/falcon/node/com"
+	"github.com/photon-storage/falcon/node/tracing"
+)
+
+type PinJobState string
+
+const (
+	PinJobQueued   PinJobState = "queued"
+	PinJobRunning  PinJobState = "running"
+	PinJobDone     PinJobState = "done"
+	PinJobFailed   PinJobState = "failed"
+	PinJobCanceled PinJobState = "canceled"
+
+	defaultPinJobWorkers   = 4
+	defaultPinJobRetention = 7 * 24 * time.Hour
+
+	// Signed P3 arg granting access to jobs and pinning service requests
+	// of all accounts.
+	argP3AdminScope = "arg-admin-scope"
+)
+
+var (
+	ErrPinJobNotFound    = errors.New("pin job not found")
+	ErrPinJobFinished    = errors.New("pin job already finished")
+	ErrPinJobUnavailable = errors.New("async pin job is not enabled")
+	ErrPinJobSizeCap     = errors.New("dag size exceeds cap")
+
+	pinJobPrefix = datastore.NewKey("/falcon/pinjobs")
+)
+
+// PinJob is an asynchronous pin add request persisted in repo datastore.
+type PinJob struct {
+	ID                    string            `json:"id"`
+	Cid                   string            `json:"cid"`
+	Recursive             bool              `json:"recursive"`
+	Concurrency           int               `json:"concurrency"`
+	MaxSize               int               `json:"max_size"`
+	TTL                   int64             `json:"ttl,omitempty"`
+	Meta                  *com.PinMeta      `json:"meta,omitempty"`
+	AccountID             string            `json:"account_id,omitempty"`
+	State                 PinJobState       `json:"state"`
+	Message               string            `json:"message,omitempty"`
+	DeduplicatedSize      int64             `json:"duplicated_size"`
+	DeduplicatedNumBlocks int64             `json:"duplicated_num_blocks"`
+	TotalSize             int64             `json:"total_size"`
+	TotalNumBlocks        int64             `json:"total_num_blocks"`
+	CreatedAt             int64             `json:"created_at"`
+	UpdatedAt             int64             `json:"updated_at"`
+	Req                   reporting.AuthReq `json:"req"`
+}
+
+func (j *PinJob) finished() bool {
+	return j.State == PinJobDone ||
+		j.State == PinJobFailed ||
+		j.State == PinJobCanceled
+}
+
+// PinJobReporter reports usage of a finished pin job. p2pIngr is the
+// size of DAG traversed by the pinner, which is reported whatever the
+// final state is. pinned is set only if the pin is done and kept.
+type PinJobReporter func(
+	ctx context.Context,
+	job *PinJob,
+	pinned bool,
+	p2pIngr uint64,
+) error
+
+// PinJobHook is called when a worker finishes a job. Usage of the job is
+// not reported if the hook returns false, e.g. the pin is reverted.
+type PinJobHook func(ctx context.Context, job *PinJob) bool
+
+// PinJobQueue runs pin jobs with a pool of workers. Jobs are persisted
+// in the datastore so unfinished jobs resume after daemon restarts.
+type PinJobQueue struct {
+	ds        datastore.Datastore
+	api       coreiface.CoreAPI
+	report    PinJobReporter
+	workers   int
+	retention time.Duration
+	pin       func(ctx context.Context, c cid.Cid, recursive bool) error
+	hook      PinJobHook
+	dagCache  *DagStatsCache
+	ttl       *PinTTL
+	pinner    *com.WrappedPinner
+	quotas    *Quotas
+
+	mu      sync.Mutex
+	pending []string
+	cancels map[string]context.CancelFunc
+	notify  chan struct{}
+}
+
+func NewPinJobQueue(
+	ds datastore.Datastore,
+	api coreiface.CoreAPI,
+	report PinJobReporter,
+	workers int,
+	retention time.Duration,
+) *PinJobQueue {
+	if workers <= 0 {
+		workers = defaultPinJobWorkers
+	}
+	if retention <= 0 {
+		retention = defaultPinJobRetention
+	}
+
+	return &PinJobQueue{
+		ds:        ds,
+		api:       api,
+		report:    report,
+		workers:   workers,
+		retention: retention,
+		pin: func(ctx context.Context, c cid.Cid, recursive bool) error {
+			return api.Pin().Add(
+				ctx,
+				path.IpfsPath(c),
+				options.Pin.Recursive(recursive),
+			)
+		},
+		cancels: map[string]context.CancelFunc{},
+		notify:  make(chan struct{}, 1),
+	}
+}
+
+func RegisterPinJobMetrics() {
+	metrics.NewCounter("pin_job_enqueued_total")
+	for _, st := range []PinJobState{
+		PinJobDone,
+		PinJobFailed,
+		PinJobCanceled,
+	} {
+		metrics.NewCounter(fmt.Sprintf("pin_job_finished_total.state#%v", st))
+	}
+	metrics.NewGauge("pin_job_pending_total")
+}
+
+// OnFinish sets the hook called when a worker finishes a job. It must be
+// set before Start.
+func (q *PinJobQueue) OnFinish(hook PinJobHook) {
+	q.hook = hook
+}
+
+// SetDagStatsCache sets the cache used for DAG stats of pinned jobs.
+func (q *PinJobQueue) SetDagStatsCache(c *DagStatsCache) {
+	q.dagCache = c
+}
+
+// SetPinTTL sets the index of expiries of pinned jobs with TTL.
+func (q *PinJobQueue) SetPinTTL(t *PinTTL) {
+	q.ttl = t
+}
+
+// SetPinner sets the pinner storing metadata of pinned jobs.
+func (q *PinJobQueue) SetPinner(p *com.WrappedPinner) {
+	q.pinner = p
+}
+
+// SetQuotas sets the account quotas enforced when running jobs.
+func (q *PinJobQueue) SetQuotas(qs *Quotas) {
+	q.quotas = qs
+}
+
+// Start resumes unfinished jobs, purges expired jobs and launches workers.
+func (q *PinJobQueue) Start(ctx context.Context) error {
+	jobs, err := q.ListAll(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, j := range jobs {
+		if j.finished() {
+			continue
+		}
+		// Running jobs are interrupted by restart and start over.
+		if j.State == PinJobRunning {
+			j.State = PinJobQueued
+			if err := q.put(ctx, j); err != nil {
+				return err
+			}
+		}
+		q.push(j.ID)
+	}
+	if err := q.purge(ctx); err != nil {
+		return err
+	}
+
+	for i := 0; i < q.workers; i++ {
+		go q.work(ctx)
+	}
+
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+
+			case <-ticker.C:
+				if err := q.purge(ctx); err != nil {
+					log.Error("Error purging pin jobs", "error", err)
+				}
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Enqueue persists the job and schedules it.
+func (q *PinJobQueue) Enqueue(ctx context.Context, j *PinJob) error {
+	now := time.Now().Unix()
+	j.ID = uuid.New().String()
+	j.State = PinJobQueued
+	j.CreatedAt = now
+	j.UpdatedAt = now
+	if err := q.put(ctx, j); err != nil {
+		return err
+	}
+
+	metrics.CounterInc("pin_job_enqueued_total")
+	q.push(j.ID)
+	return nil
+}
+
+func (q *PinJobQueue) Get(ctx context.Context, id string) (*PinJob, error) {
+	data, err := q.ds.Get(ctx, pinJobKey(id))
+	if err != nil {
+		if errors.Is(err, datastore.ErrNotFound) {
+			return nil, ErrPinJobNotFound
+		}
+		return nil, err
+	}
+
+	var j PinJob
+	if err := json.Unmarshal(data, &j); err != nil {
+		return nil, err
+	}
+	return &j, nil
+}
+
+// List returns jobs of an account sorted by creation time. The account
+// must match exactly.
+func (q *PinJobQueue) List(
+	ctx context.Context,
+	acctID string,
+) ([]*PinJob, error) {
+	return q.list(ctx, func(j *PinJob) bool {
+		return j.AccountID == acctID
+	})
+}
+
+// ListAll returns jobs of all accounts sorted by creation time.
+func (q *PinJobQueue) ListAll(ctx context.Context) ([]*PinJob, error) {
+	return q.list(ctx, func(*PinJob) bool {
+		return true
+	})
+}
+
+func (q *PinJobQueue) list(
+	ctx context.Context,
+	match func(j *PinJob) bool,
+) ([]*PinJob, error) {
+	res, err := q.ds.Query(ctx, query.Query{
+		Prefix: pinJobPrefix.String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var jobs []*PinJob
+	for e := range res.Next() {
+		if e.Error != nil {
+			return nil, e.Error
+		}
+
+		var j PinJob
+		if err := json.Unmarshal(e.Value, &j); err != nil {
+			return nil, err
+		}
+		if !match(&j) {
+			continue
+		}
+		jobs = append(jobs, &j)
+	}
+
+	sort.Slice(jobs, func(i, j int) bool {
+		if jobs[i].CreatedAt == jobs[j].CreatedAt {
+			return jobs[i].ID < jobs[j].ID
+		}
+		return jobs[i].CreatedAt < jobs[j].CreatedAt
+	})
+
+	return jobs, nil
+}
+
+// Cancel cancels a queued or running job.
+func (q *PinJobQueue) Cancel(ctx context.Context, id string) (*PinJob, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	j, err := q.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if j.finished() {
+		return j, ErrPinJobFinished
+	}
+
+	if cancel, ok := q.cancels[id]; ok {
+		// The worker marks the job canceled when it returns.
+		cancel()
+		return j, nil
+	}
+
+	if err := q.finish(ctx, j, PinJobCanceled, "canceled by user"); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+func (q *PinJobQueue) push(id string) {
+	q.mu.Lock()
+	q.pending = append(q.pending, id)
+	metrics.GaugeSet("pin_job_pending_total", float64(len(q.pending)))
+	q.mu.Unlock()
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// pop returns the next queued job and marks it running.
+func (q *PinJobQueue) pop(
+	ctx context.Context,
+) (*PinJob, context.Context, context.CancelFunc) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.pending) > 0 {
+		id := q.pending[0]
+		q.pending = q.pending[1:]
+		metrics.GaugeSet("pin_job_pending_total", float64(len(q.pending)))
+
+		j, err := q.Get(ctx, id)
+		if err != nil {
+			log.Error("Error loading pin job", "id", id, "error", err)
+			continue
+		}
+		// Canceled while queued.
+		if j.State != PinJobQueued {
+			continue
+		}
+
+		j.State = PinJobRunning
+		j.UpdatedAt = time.Now().Unix()
+		if err := q.put(ctx, j); err != nil {
+			log.Error("Error updating pin job", "id", id, "error", err)
+			continue
+		}
+
+		jctx, cancel := context.WithCancel(ctx)
+		q.cancels[id] = cancel
+		// Wake another worker as more jobs are pending.
+		if len(q.pending) > 0 {
+			select {
+			case q.notify <- struct{}{}:
+			default:
+			}
+		}
+		return j, jctx, cancel
+	}
+
+	return nil, nil, nil
+}
+
+func (q *PinJobQueue) work(ctx context.Context) {
+	for {
+		j, jctx, cancel := q.pop(ctx)
+		if j == nil {
+			select {
+			case <-ctx.Done():
+				return
+
+			case <-q.notify:
+				continue
+			}
+		}
+
+		q.run(ctx, jctx, j)
+		cancel()
+	}
+}
+
+func (q *PinJobQueue) run(ctx context.Context, jctx context.Context, j *PinJob) {
+	jctx, span := tracing.Span(jctx, "Handlers", "PinJob")
+	defer span.End()
+
+	state, msg, p2pIngr := q.exec(jctx, j)
+	if ctx.Err() != nil {
+		// Daemon shutting down. The job remains running in datastore
+		// and is resumed on restart.
+		q.mu.Lock()
+		delete(q.cancels, j.ID)
+		q.mu.Unlock()
+		return
+	}
+
+	q.mu.Lock()
+	delete(q.cancels, j.ID)
+	if state == PinJobFailed && jctx.Err() == context.Canceled {
+		state = PinJobCanceled
+		msg = "canceled by user"
+	}
+	if err := q.finish(ctx, j, state, msg); err != nil {
+		log.Error("Error updating pin job", "id", j.ID, "error", err)
+	}
+	q.mu.Unlock()
+
+	report := true
+	if q.hook != nil {
+		report = q.hook(ctx, j)
+	}
+	if report && state == PinJobDone {
+		if c, err := cid.Decode(j.Cid); err == nil {
+			addPinMeta(ctx, q.pinner, c, j.Recursive, j.Meta)
+		}
+		ds := NewDagStats()
+		ds.TotalCount.Store(1)
+		ds.TotalSize.Store(j.TotalSize)
+		q.quotas.record(ctx, j.AccountID, ds, false)
+	}
+	if report && state == PinJobDone && j.TTL > 0 && q.ttl != nil {
+		if err := q.ttl.Add(ctx, &PinExpiry{
+			Cid:       j.Cid,
+			Recursive: j.Recursive,
+			ExpireAt:  time.Now().Unix() + j.TTL,
+			AccountID: j.AccountID,
+			Req:       j.Req,
+		}); err != nil {
+			log.Error("Error adding pin expiry", "id", j.ID, "error", err)
+		}
+	}
+	pinned := report && state == PinJobDone
+	if q.report != nil && (pinned || p2pIngr > 0) {
+		if err := q.report(ctx, j, pinned, p2pIngr); err != nil {
+			log.Error("Error reporting pin job usage",
+				"id", j.ID,
+				"error", err,
+			)
+		}
+	}
+}
+
+// exec pins the job CID and calculates its DAG stats.
+func (q *PinJobQueue) exec(
+	ctx context.Context,
+	j *PinJob,
+) (PinJobState, string, uint64) {
+	c, err := cid.Decode(j.Cid)
+	if err != nil {
+		return PinJobFailed, ErrInvalidCID.Error(), 0
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	p2pIngr := atomic.NewUint64(0)
+	ctx = rcpinner.WithDagSize(ctx, p2pIngr)
+	cc := j.Concurrency
+	if cc == 0 {
+		cc = 32
+	}
+	ctx = rcpinner.WithConcurrency(ctx, cc)
+
+	ctx, guard, err := q.quotas.enforce(ctx, j.AccountID, 1)
+	if err != nil {
+		return PinJobFailed, fmt.Sprintf("error checking quota: %v", err), 0
+	}
+	defer guard.stop()
+
+	// Enforce the signed size cap as the monitor does for sync requests.
+	capped := atomic.NewBool(false)
+	if j.MaxSize > 0 {
+		go func() {
+			ticker := time.NewTicker(time.Second)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+
+				case <-ticker.C:
+					if p2pIngr.Load() > uint64(j.MaxSize) {
+						capped.Store(true)
+						cancel()
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	if err := q.pin(ctx, c, j.Recursive); err != nil {
+		if capped.Load() {
+			err = ErrPinJobSizeCap
+		} else if guard.isExceeded() {
+			err = ErrQuotaExceeded
+		}
+		return PinJobFailed, fmt.Sprintf("error pinning: %v", err), p2pIngr.Load()
+	}
+
+	ds := NewDagStats()
+	if err := q.dagCache.Calculate(ctx, q.api, c, j.Recursive, ds); err != nil {
+		// Ignore stats error.
+		log.Error("Error calculating dag stats",
+			"error", err,
+			"cid", c.String(),
+			"source", "pin job",
+		)
+	}
+	j.DeduplicatedSize = ds.DeduplicatedSize.Load()
+	j.DeduplicatedNumBlocks = ds.DeduplicatedNumBlocks.Load()
+	j.TotalSize = ds.TotalSize.Load()
+	j.TotalNumBlocks = ds.TotalNumBlocks.Load()
+
+	return PinJobDone, "", p2pIngr.Load()
+}
+
+func (q *PinJobQueue) finish(
+	ctx context.Context,
+	j *PinJob,
+	state PinJobState,
+	msg string,
+) error {
+	j.State = state
+	j.Message = msg
+	j.UpdatedAt = time.Now().Unix()
+	metrics.CounterInc(fmt.Sprintf("pin_job_finished_total.state#%v", state))
+	return q.put(ctx, j)
+}
+
+// purge deletes finished jobs beyond retention.
+func (q *PinJobQueue) purge(ctx context.Context) error {
+	jobs, err := q.ListAll(ctx)
+	if err != nil {
+		return err
+	}
+
+	expiry := time.Now().Add(-q.retention).Unix()
+	for _, j := range jobs {
+		if j.finished() && j.UpdatedAt < expiry {
+			if err := q.ds.Delete(ctx, pinJobKey(j.ID)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (q *PinJobQueue) put(ctx context.Context, j *PinJob) error {
+	data, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	if err := q.ds.Put(ctx, pinJobKey(j.ID), data); err != nil {
+		return err
+	}
+	return q.ds.Sync(ctx, pinJobKey(j.ID))
+}
+
+func pinJobKey(id string) datastore.Key {
+	return pinJobPrefix.ChildString(id)
+}
+
+// SetPinJobQueue enables asynchronous pin jobs.
+func (h *ExtendedHandlers) SetPinJobQueue(q *PinJobQueue) {
+	h.jobs = q
+}
+
+type PinJobResult struct {
+	Success bool    `json:"success"`
+	Job     *PinJob `json:"job,omitempty"`
+	Message string  `json:"message"`
+}
+
+type PinJobListResult struct {
+	Success bool      `json:"success"`
+	Jobs    []*PinJob `json:"jobs"`
+	Message string    `json:"message"`
+}
+
+// pinAddAsync enqueues a pin job and responds with the job ID.
+func (h *ExtendedHandlers) pinAddAsync(
+	w gohttp.ResponseWriter,
+	r *gohttp.Request,
+	c cid.Cid,
+	recursive bool,
+	cc int,
+	meta *com.PinMeta,
+) {
+	if h.jobs == nil {
+		writeJSON(
+			w,
+			gohttp.StatusNotImplemented,
+			&PinJobResult{
+				Success: false,
+				Message: ErrPinJobUnavailable.Error(),
+			},
+		)
+		return
+	}
+
+	args, err := parseSignedArgs(r)
+	if err != nil {
+		writeJSON(
+			w,
+			gohttp.StatusBadRequest,
+			&PinJobResult{
+				Success: false,
+				Message: fmt.Sprintf("error parsing params: %v", err),
+			},
+		)
+		return
+	}
+
+	// Reject early if the account is out of quota. The job enforces and
+	// reserves the quota again when it runs.
+	res, _, err := h.quotas.Check(
+		r.Context(),
+		args.GetArg(http.ArgP3AcctID),
+		1,
+	)
+	res.release()
+	if err != nil {
+		writeJSON(
+			w,
+			quotaErrorCode(err),
+			&PinJobResult{
+				Success: false,
+				Message: fmt.Sprintf("error checking quota: %v", err),
+			},
+		)
+		return
+	}
+
+	j := newPinJob(r, args, c, recursive, cc)
+	j.Meta = meta
+	if err := h.jobs.Enqueue(r.Context(), j); err != nil {
+		writeJSON(
+			w,
+			gohttp.StatusInternalServerError,
+			&PinJobResult{
+				Success: false,
+				Message: fmt.Sprintf("error enqueuing job: %v", err),
+			},
+		)
+		return
+	}
+
+	writeJSON(
+		w,
+		gohttp.StatusAccepted,
+		&PinJobResult{
+			Success: true,
+			Job:     j,
+			Message: "ok",
+		},
+	)
+}
+
+// newPinJob creates a job carrying the signed request for usage reporting.
+func newPinJob(
+	r *gohttp.Request,
+	args *http.Args,
+	c cid.Cid,
+	recursive bool,
+	cc int,
+) *PinJob {
+	maxSize := 0
+	if str := args.GetArg(http.ArgP3Size); str != "" {
+		if sz, err := strconv.ParseInt(str, 10, 64); err == nil {
+			maxSize = int(sz)
+		}
+	}
+
+	// An invalid TTL is ignored like the size cap.
+	ttl, _ := parseTTLArg(args)
+
+	query := r.URL.Query()
+	return &PinJob{
+		Cid:         c.String(),
+		Recursive:   recursive,
+		Concurrency: cc,
+		MaxSize:     maxSize,
+		TTL:         int64(ttl / time.Second),
+		AccountID:   args.GetArg(http.ArgP3AcctID),
+		Req: reporting.AuthReq{
+			Method: r.Method,
+			Host:   r.Host,
+			URI:    r.URL.Path,
+			Args:   query.Get(http.ParamP3Args),
+			Sig:    query.Get(http.ParamP3Sig),
+		},
+	}
+}
+
+// PinJobStatus returns the job given by arg.
+func (h *ExtendedHandlers) PinJobStatus() gohttp.HandlerFunc {
+	return h.pinJobHandler("PinJobStatus", func(
+		ctx context.Context,
+		id string,
+	) (*PinJob, error) {
+		return h.jobs.Get(ctx, id)
+	})
+}
+
+// PinJobCancel cancels the job given by arg.
+func (h *ExtendedHandlers) PinJobCancel() gohttp.HandlerFunc {
+	return h.pinJobHandler("PinJobCancel", func(
+		ctx context.Context,
+		id string,
+	) (*PinJob, error) {
+		return h.jobs.Cancel(ctx, id)
+	})
+}
+
+func (h *ExtendedHandlers) pinJobHandler(
+	name string,
+	fn func(ctx context.Context, id string) (*PinJob, error),
+) gohttp.HandlerFunc {
+	return gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		ctx, span := tracing.Span(r.Context(), "Handlers", name)
+		defer span.End()
+		r = r.WithContext(ctx)
+
+		if h.jobs == nil {
+			writeJSON(
+				w,
+				gohttp.StatusNotImplemented,
+				&PinJobResult{
+					Success: false,
+					Message: ErrPinJobUnavailable.Error(),
+				},
+			)
+			return
+		}
+
+		args, err := parseSignedArgs(r)
+		if err != nil {
+			writeJSON(
+				w,
+				gohttp.StatusBadRequest,
+				&PinJobResult{
+					Success: false,
+					Message: fmt.Sprintf("error parsing params: %v", err),
+				},
+			)
+			return
+		}
+
+		id := r.URL.Query().Get(http.ParamIPFSArg)
+		j, err := h.jobs.Get(r.Context(), id)
+		if err == nil && !jobAccessible(j, args) {
+			err = ErrPinJobNotFound
+		}
+		if err == nil {
+			j, err = fn(r.Context(), id)
+		}
+		if err != nil {
+			code := gohttp.StatusInternalServerError
+			if errors.Is(err, ErrPinJobNotFound) {
+				code = gohttp.StatusNotFound
+			} else if errors.Is(err, ErrPinJobFinished) {
+				code = gohttp.StatusConflict
+			}
+			writeJSON(
+				w,
+				code,
+				&PinJobResult{
+					Success: false,
+					Job:     j,
+					Message: err.Error(),
+				},
+			)
+			return
+		}
+
+		writeJSON(
+			w,
+			gohttp.StatusOK,
+			&PinJobResult{
+				Success: true,
+				Job:     j,
+				Message: "ok",
+			},
+		)
+	})
+}
+
+// PinJobList lists jobs of the requesting account.
+func (h *ExtendedHandlers) PinJobList() gohttp.HandlerFunc {
+	return gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		ctx, span := tracing.Span(r.Context(), "Handlers", "PinJobList")
+		defer span.End()
+		r = r.WithContext(ctx)
+
+		if h.jobs == nil {
+			writeJSON(
+				w,
+				gohttp.StatusNotImplemented,
+				&PinJobListResult{
+					Success: false,
+					Message: ErrPinJobUnavailable.Error(),
+				},
+			)
+			return
+		}
+
+		args, err := parseSignedArgs(r)
+		if err != nil {
+			writeJSON(
+				w,
+				gohttp.StatusBadRequest,
+				&PinJobListResult{
+					Success: false,
+					Message: fmt.Sprintf("error parsing params: %v", err),
+				},
+			)
+			return
+		}
+
+		var jobs []*PinJob
+		if acctID, all := accountScope(args); all {
+			jobs, err = h.jobs.ListAll(r.Context())
+		} else {
+			jobs, err = h.jobs.List(r.Context(), acctID)
+		}
+		if err != nil {
+			writeJSON(
+				w,
+				gohttp.StatusInternalServerError,
+				&PinJobListResult{
+					Success: false,
+					Message: fmt.Sprintf("error listing jobs: %v", err),
+				},
+			)
+			return
+		}
+
+		writeJSON(
+			w,
+			gohttp.StatusOK,
+			&PinJobListResult{
+				Success: true,
+				Jobs:    jobs,
+				Message: "ok",
+			},
+		)
+	})
+}
+
+// jobAccessible checks if the job belongs to the requesting account.
+func jobAccessible(j *PinJob, args *http.Args) bool {
+	acctID, all := accountScope(args)
+	return all || j.AccountID == acctID
+}
+
+// accountScope returns the signed account of a request, or all if the
+// admin scope is signed. A request without an account only accesses
+// records without an account.
+func accountScope(args *http.Args) (string, bool) {
+	all, _ := strconv.ParseBool(args.GetArg(argP3AdminScope))
+	return args.GetArg(http.ArgP3AcctID), all
+}
+
+// parseSignedArgs decodes P3 args kept in query by auth. Empty args are
+// returned if the request carries none.
+func parseSignedArgs(r *gohttp.Request) (*http.Args, error) {
+	str := r.URL.Query().Get(http.ParamP3Args)
+	if str == "" {
+		return http.NewArgs(), nil
+	}
+	return http.DecodeArgs(str)
+}
@@ -0,0 +1,249 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	gohttp "net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	bs "github.com/ipfs/boxo/blockservice"
+	"github.com/ipfs/boxo/blockstore"
+	"github.com/ipfs/boxo/exchange/offline"
+	"github.com/ipfs/boxo/ipld/merkledag"
+	"github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipfs/kubo/core"
+
+	"github.com/photon-storage/go-common/testing/require"
+	"github.com/photon-storage/go-gw3/common/http"
+	rcpinner "github.com/photon-storage/go-rc-pinner"
+
+	"github.com/photon-storage/falcon/node/com"
+)
+
+func waitPinJob(
+	t *testing.T,
+	q *PinJobQueue,
+	id string,
+	state PinJobState,
+) *PinJob {
+	for i := 0; i < 100; i++ {
+		j, err := q.Get(context.Background(), id)
+		require.NoError(t, err)
+		if j.State == state {
+			return j
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("pin job %v not in %v state", id, state)
+	return nil
+}
+
+func TestPinJobQueue(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	bstore := blockstore.NewBlockstore(dstore)
+	bserv := bs.New(bstore, offline.Exchange(bstore))
+	dserv := merkledag.NewDAGService(bserv)
+	rcp, err := rcpinner.New(ctx, dstore, dserv)
+	require.NoError(t, err)
+	pinner := &com.WrappedPinner{
+		Pinner: rcp,
+	}
+
+	// A{B,C}
+	a := rndNode(t)
+	b := rndNode(t)
+	c := rndNode(t)
+	require.NoError(t, a.AddNodeLink("c0", b))
+	require.NoError(t, a.AddNodeLink("c1", c))
+	require.NoError(t, dserv.Add(ctx, a))
+	require.NoError(t, dserv.Add(ctx, b))
+	require.NoError(t, dserv.Add(ctx, c))
+
+	api := &mockAPI{
+		dag: &mockAPIDag{
+			DAGService: dserv,
+		},
+	}
+	block := make(chan struct{})
+	newQueue := func(reported chan<- *PinJob) *PinJobQueue {
+		q := NewPinJobQueue(
+			dstore,
+			api,
+			func(_ context.Context, j *PinJob, _ bool, _ uint64) error {
+				reported <- j
+				return nil
+			},
+			1,
+			0,
+		)
+		q.pin = func(ctx context.Context, k cid.Cid, recursive bool) error {
+			if k == b.Cid() {
+				// Block the worker until canceled.
+				select {
+				case <-block:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			nd, err := dserv.Get(ctx, k)
+			if err != nil {
+				return err
+			}
+			return pinner.Pin(ctx, nd, recursive)
+		}
+		return q
+	}
+
+	reported := make(chan *PinJob, 8)
+	q := newQueue(reported)
+	qctx, qcancel := context.WithCancel(ctx)
+	require.NoError(t, q.Start(qctx))
+
+	h := New(&core.IpfsNode{Pinning: pinner}, api, nil)
+	h.SetPinJobQueue(q)
+
+	args := http.NewArgs().SetArg(http.ArgP3AcctID, "acct")
+	enqueue := func(k cid.Cid) *PinJob {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(gohttp.MethodPost, "/api/v0/pin/add", nil)
+		query := r.URL.Query()
+		query.Set(http.ParamIPFSArg, k.String())
+		query.Set(http.ParamIPFSRecursive, "true")
+		query.Set("async", "true")
+		query.Set(http.ParamP3Args, args.Encode())
+		r.URL.RawQuery = query.Encode()
+		h.PinAdd().ServeHTTP(w, r)
+		require.Equal(t, gohttp.StatusAccepted, w.Code)
+
+		var res PinJobResult
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+		require.True(t, res.Success)
+		require.Equal(t, PinJobQueued, res.Job.State)
+		require.Equal(t, "acct", res.Job.AccountID)
+		return res.Job
+	}
+
+	// Pin job completes with stats and usage reported.
+	j := enqueue(a.Cid())
+	j = waitPinJob(t, q, j.ID, PinJobDone)
+	require.Equal(t, int64(3), j.TotalNumBlocks)
+	cnt, err := pinner.GetCount(ctx, a.Cid(), true)
+	require.NoError(t, err)
+	require.Equal(t, uint16(1), cnt)
+	require.Equal(t, j.ID, (<-reported).ID)
+
+	// Running job is canceled.
+	jb := enqueue(b.Cid())
+	waitPinJob(t, q, jb.ID, PinJobRunning)
+	// Queued job is canceled.
+	jc := enqueue(c.Cid())
+	_, err = q.Cancel(ctx, jc.ID)
+	require.NoError(t, err)
+	require.Equal(t, PinJobCanceled, waitPinJob(t, q, jc.ID, PinJobCanceled).State)
+	_, err = q.Cancel(ctx, jb.ID)
+	require.NoError(t, err)
+	waitPinJob(t, q, jb.ID, PinJobCanceled)
+	_, err = q.Cancel(ctx, jb.ID)
+	require.ErrorIs(t, ErrPinJobFinished, err)
+
+	// Job interrupted by shutdown resumes after restart.
+	jb = enqueue(b.Cid())
+	waitPinJob(t, q, jb.ID, PinJobRunning)
+	qcancel()
+	time.Sleep(50 * time.Millisecond)
+	waitPinJob(t, q, jb.ID, PinJobRunning)
+
+	close(block)
+	q = newQueue(reported)
+	require.NoError(t, q.Start(ctx))
+	waitPinJob(t, q, jb.ID, PinJobDone)
+	require.Equal(t, jb.ID, (<-reported).ID)
+
+	// Jobs are listed by account.
+	jobs, err := q.List(ctx, "acct")
+	require.NoError(t, err)
+	require.Equal(t, 4, len(jobs))
+	jobs, err = q.List(ctx, "other")
+	require.NoError(t, err)
+	require.Equal(t, 0, len(jobs))
+
+	// Job of other account is not found, even without an account. The
+	// signed admin scope accesses jobs of all accounts.
+	h.SetPinJobQueue(q)
+	status := func(args *http.Args) int {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(gohttp.MethodGet, "/api/v0/pin/jobs/status", nil)
+		query := r.URL.Query()
+		query.Set(http.ParamIPFSArg, j.ID)
+		query.Set(http.ParamP3Args, args.Encode())
+		r.URL.RawQuery = query.Encode()
+		h.PinJobStatus().ServeHTTP(w, r)
+		return w.Code
+	}
+	require.Equal(
+		t,
+		gohttp.StatusNotFound,
+		status(http.NewArgs().SetArg(http.ArgP3AcctID, "other")),
+	)
+	require.Equal(t, gohttp.StatusNotFound, status(http.NewArgs()))
+	require.Equal(
+		t,
+		gohttp.StatusOK,
+		status(http.NewArgs().SetArg(argP3AdminScope, "true")),
+	)
+	jobs, err = q.ListAll(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 4, len(jobs))
+}
+
+func TestPinJobQueueIdle(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	bstore := blockstore.NewBlockstore(dstore)
+	bserv := bs.New(bstore, offline.Exchange(bstore))
+	dserv := merkledag.NewDAGService(bserv)
+
+	a := rndNode(t)
+	require.NoError(t, dserv.Add(ctx, a))
+
+	q := NewPinJobQueue(
+		dstore,
+		&mockAPI{
+			dag: &mockAPIDag{
+				DAGService: dserv,
+			},
+		},
+		func(context.Context, *PinJob, bool, uint64) error {
+			return nil
+		},
+		4,
+		0,
+	)
+	q.pin = func(context.Context, cid.Cid, bool) error {
+		return nil
+	}
+	require.NoError(t, q.Start(ctx))
+
+	j := &PinJob{
+		Cid:       a.Cid().String(),
+		Recursive: true,
+	}
+	require.NoError(t, q.Enqueue(ctx, j))
+	waitPinJob(t, q, j.ID, PinJobDone)
+
+	// Idle workers block on the signal instead of passing it around.
+	time.Sleep(10 * time.Millisecond)
+	for i := 0; i < 1000; i++ {
+		require.Equal(t, 0, len(q.notify))
+		time.Sleep(100 * time.Microsecond)
+	}
+}
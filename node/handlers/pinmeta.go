@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	gohttp "net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+
+	"github.com/photon-storage/go-common/log"
+	"github.com/photon-storage/go-gw3/common/http"
+
+	"github.com/photon-storage/falcon/node/com"
+)
+
+var (
+	ErrInvalidTags = errors.New("invalid tags")
+)
+
+// parsePinMeta parses metadata of a pin increment from name and tags
+// params. The owner is the account signed in P3 args and the request ID
+// is tagged by auth.
+func parsePinMeta(
+	r *gohttp.Request,
+	args *http.Args,
+) (*com.PinMeta, error) {
+	query := r.URL.Query()
+	m := &com.PinMeta{
+		Name:      strings.TrimSpace(query.Get("name")),
+		Owner:     args.GetArg(http.ArgP3AcctID),
+		RequestID: GetRequestIDFromCtx(r.Context()),
+	}
+	if str := strings.TrimSpace(query.Get("tags")); str != "" {
+		if err := json.Unmarshal([]byte(str), &m.Tags); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidTags, err)
+		}
+	}
+	return m, nil
+}
+
+// parseMetaParam parses whether to list metadata of pins.
+func parseMetaParam(r *gohttp.Request) (bool, error) {
+	str := strings.TrimSpace(r.URL.Query().Get("meta"))
+	if str == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(str)
+}
+
+// addPinMeta stores a copy of metadata for a pinned increment. Metadata
+// is informational so errors are logged only.
+func addPinMeta(
+	ctx context.Context,
+	pinner *com.WrappedPinner,
+	c cid.Cid,
+	recursive bool,
+	m *com.PinMeta,
+) {
+	if pinner == nil || m == nil {
+		return
+	}
+
+	v := *m
+	if err := pinner.AddMeta(ctx, c, recursive, &v); err != nil &&
+		!errors.Is(err, com.ErrMetaUnsupported) {
+		log.Error("Error adding pin metadata",
+			"error", err,
+			"cid", c.String(),
+		)
+	}
+}
+
+// removePinMeta removes metadata of an unpinned increment of the owner.
+// It returns whether the owner had pinned the CID, which gates crediting
+// the unpin to the owner quota.
+func removePinMeta(
+	ctx context.Context,
+	pinner *com.WrappedPinner,
+	c cid.Cid,
+	recursive bool,
+	owner string,
+) bool {
+	if pinner == nil {
+		return false
+	}
+
+	owned, err := pinner.RemoveMeta(ctx, c, recursive, owner)
+	if err != nil && !errors.Is(err, com.ErrMetaUnsupported) {
+		log.Error("Error removing pin metadata",
+			"error", err,
+			"cid", c.String(),
+		)
+	}
+	return owned
+}
+
+// annotateMeta sets metadata of listed pins. A pin failing to list its
+// metadata is left without it.
+func annotateMeta(
+	ctx context.Context,
+	pinner *com.WrappedPinner,
+	recursive bool,
+	batch []*CidCount,
+) {
+	for _, v := range batch {
+		c, err := cid.Decode(v.Cid)
+		if err != nil {
+			continue
+		}
+		metas, err := pinner.ListMeta(ctx, c, recursive)
+		if errors.Is(err, com.ErrMetaUnsupported) {
+			return
+		}
+		if err != nil {
+			log.Error("Error listing pin metadata",
+				"error", err,
+				"cid", v.Cid,
+			)
+			continue
+		}
+		v.Meta = metas
+	}
+}
+
+// pinListOwner lists pins of an owner with metadata. A page of the owner
+// index is listed if paged.
+func pinListOwner(
+	w gohttp.ResponseWriter,
+	r *gohttp.Request,
+	pinner *com.WrappedPinner,
+	recursive bool,
+	owner string,
+	opts *com.PageOptions,
+	paged bool,
+	annotate func(ctx context.Context, batch []*CidCount),
+) {
+	if opts.Total {
+		writeJSON(
+			w,
+			gohttp.StatusBadRequest,
+			&PinListResult{
+				Success:    false,
+				InProgress: false,
+				Message:    "error parsing params: total is not supported with owner",
+			},
+		)
+		return
+	}
+	if !paged {
+		opts.Limit = 0
+	}
+
+	page, err := pinner.ListOwnerPage(r.Context(), owner, recursive, opts)
+	if err != nil {
+		code := gohttp.StatusInternalServerError
+		if errors.Is(err, com.ErrInvalidIndexKey) {
+			code = gohttp.StatusBadRequest
+		} else if errors.Is(err, com.ErrMetaUnsupported) {
+			code = gohttp.StatusNotImplemented
+		}
+		writeJSON(
+			w,
+			code,
+			&PinListResult{
+				Success:    false,
+				InProgress: false,
+				Message:    fmt.Sprintf("error listing pins: %v", err),
+			},
+		)
+		return
+	}
+
+	batch := make([]*CidCount, 0, len(page.Pins))
+	for _, p := range page.Pins {
+		batch = append(batch, &CidCount{
+			Cid:   p.Cid.String(),
+			Count: int(p.Count),
+			Meta:  p.Metas,
+		})
+	}
+	annotate(r.Context(), batch)
+	writeJSON(
+		w,
+		gohttp.StatusOK,
+		&PinListResult{
+			Success:    true,
+			InProgress: false,
+			Batch:      batch,
+			Cursor:     page.Next,
+		},
+	)
+}
@@ -0,0 +1,277 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	gohttp "net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	bs "github.com/ipfs/boxo/blockservice"
+	"github.com/ipfs/boxo/blockstore"
+	"github.com/ipfs/boxo/exchange/offline"
+	"github.com/ipfs/boxo/ipld/merkledag"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipfs/kubo/core"
+
+	"github.com/photon-storage/go-common/testing/require"
+	"github.com/photon-storage/go-gw3/common/http"
+	rcpinner "github.com/photon-storage/go-rc-pinner"
+
+	"github.com/photon-storage/falcon/node/com"
+)
+
+func TestParsePinMeta(t *testing.T) {
+	cases := []struct {
+		name  string
+		query string
+		meta  *com.PinMeta
+		err   error
+	}{
+		{
+			name:  "empty",
+			query: "",
+			meta: &com.PinMeta{
+				Owner:     "acct",
+				RequestID: "req",
+			},
+		},
+		{
+			name:  "name and tags",
+			query: "name=%20photo%20&tags=%7B%22k%22%3A%22v%22%7D",
+			meta: &com.PinMeta{
+				Name:      "photo",
+				Owner:     "acct",
+				RequestID: "req",
+				Tags:      map[string]string{"k": "v"},
+			},
+		},
+		{
+			name:  "invalid tags",
+			query: "tags=invalid",
+			err:   ErrInvalidTags,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := gohttp.NewRequest(
+				gohttp.MethodPost,
+				"/api/v0/pin/add?"+tc.query,
+				nil,
+			)
+			require.NoError(t, err)
+			r = r.WithContext(WithRequestID(r.Context(), "req"))
+			args := http.NewArgs()
+			args.SetArg(http.ArgP3AcctID, "acct")
+
+			m, err := parsePinMeta(r, args)
+			if tc.err != nil {
+				require.ErrorIs(t, tc.err, err)
+				return
+			}
+			require.NoError(t, err)
+			require.DeepEqual(t, tc.meta, m)
+		})
+	}
+}
+
+func TestPinMeta(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	bstore := blockstore.NewBlockstore(dstore)
+	bserv := bs.New(bstore, offline.Exchange(bstore))
+	dserv := merkledag.NewDAGService(bserv)
+	rcp, err := rcpinner.New(ctx, dstore, dserv)
+	require.NoError(t, err)
+	pinner := &com.WrappedPinner{
+		Pinner:    rcp,
+		Datastore: dstore,
+	}
+
+	a := rndNode(t)
+	b := rndNode(t)
+	require.NoError(t, dserv.Add(ctx, a))
+	require.NoError(t, dserv.Add(ctx, b))
+
+	pin := func(
+		nd *merkledag.ProtoNode,
+		recursive bool,
+		owner string,
+		createdAt int64,
+	) {
+		require.NoError(t, pinner.Pin(ctx, nd, recursive))
+		require.NoError(t, pinner.AddMeta(
+			ctx,
+			nd.Cid(),
+			recursive,
+			&com.PinMeta{
+				Name:      fmt.Sprintf("%v-%v", owner, createdAt),
+				Owner:     owner,
+				CreatedAt: createdAt,
+			},
+		))
+	}
+	unpin := func(
+		nd *merkledag.ProtoNode,
+		recursive bool,
+		owner string,
+	) {
+		require.NoError(t, pinner.Unpin(ctx, nd.Cid(), recursive))
+		_, err := pinner.RemoveMeta(ctx, nd.Cid(), recursive, owner)
+		require.NoError(t, err)
+	}
+	names := func(metas []*com.PinMeta) []string {
+		var res []string
+		for _, m := range metas {
+			res = append(res, m.Name)
+		}
+		return res
+	}
+
+	pin(a, true, "alice", 2)
+	pin(a, true, "bob", 1)
+	pin(a, true, "alice", 3)
+	pin(a, false, "alice", 4)
+	pin(b, true, "bob", 5)
+
+	// Entries are listed in creation order per mode.
+	metas, err := pinner.ListMeta(ctx, a.Cid(), true)
+	require.NoError(t, err)
+	require.DeepEqual(t, []string{"bob-1", "alice-2", "alice-3"}, names(metas))
+	require.NotEmpty(t, metas[0].ID)
+	metas, err = pinner.ListMeta(ctx, a.Cid(), false)
+	require.NoError(t, err)
+	require.DeepEqual(t, []string{"alice-4"}, names(metas))
+
+	owned, err := pinner.ListMetaByOwner(ctx, "bob", true)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(owned))
+	for _, p := range owned {
+		require.True(t, p.Recursive)
+		require.Equal(t, "bob", p.Meta.Owner)
+		require.True(t, p.Cid.Equals(a.Cid()) || p.Cid.Equals(b.Cid()))
+	}
+	owned, err = pinner.ListMetaByOwner(ctx, "carol", true)
+	require.NoError(t, err)
+	require.Equal(t, 0, len(owned))
+
+	// Latest entry of the owner is removed.
+	unpin(a, true, "alice")
+	metas, err = pinner.ListMeta(ctx, a.Cid(), true)
+	require.NoError(t, err)
+	require.DeepEqual(t, []string{"bob-1", "alice-2"}, names(metas))
+
+	h := New(
+		&core.IpfsNode{
+			Pinning: pinner,
+		},
+		nil,
+		nil,
+	)
+
+	// pin/count with metadata.
+	r, err := gohttp.NewRequest(
+		gohttp.MethodGet,
+		fmt.Sprintf(
+			"/api/v0/pin/count?%s=%s&recursive=1&meta=true",
+			http.ParamIPFSArg,
+			a.Cid().String(),
+		),
+		nil,
+	)
+	require.NoError(t, err)
+	w := httptest.NewRecorder()
+	h.PinnedCount()(w, r)
+	require.Equal(t, gohttp.StatusOK, w.Code)
+	var cres PinnedCountResult
+	decodeResp(t, w, &cres)
+	require.True(t, cres.Success)
+	require.Equal(t, 2, cres.Count)
+	require.DeepEqual(t, []string{"bob-1", "alice-2"}, names(cres.Meta))
+
+	// pin/ls with metadata.
+	r, err = gohttp.NewRequest(
+		gohttp.MethodGet,
+		"/api/v0/pin/ls?recursive=true&meta=true&limit=10",
+		nil,
+	)
+	require.NoError(t, err)
+	w = httptest.NewRecorder()
+	h.PinList()(w, r)
+	require.Equal(t, gohttp.StatusOK, w.Code)
+	var lres PinListResult
+	decodeResp(t, w, &lres)
+	require.True(t, lres.Success)
+	require.Equal(t, 2, len(lres.Batch))
+	for _, v := range lres.Batch {
+		switch v.Cid {
+		case a.Cid().String():
+			require.DeepEqual(t, []string{"bob-1", "alice-2"}, names(v.Meta))
+		case b.Cid().String():
+			require.DeepEqual(t, []string{"bob-5"}, names(v.Meta))
+		default:
+			t.Fatalf("unexpected cid %v", v.Cid)
+		}
+	}
+
+	// pin/ls by owner.
+	r, err = gohttp.NewRequest(
+		gohttp.MethodGet,
+		"/api/v0/pin/ls?recursive=true&owner=alice",
+		nil,
+	)
+	require.NoError(t, err)
+	w = httptest.NewRecorder()
+	h.PinList()(w, r)
+	require.Equal(t, gohttp.StatusOK, w.Code)
+	lres = PinListResult{}
+	decodeResp(t, w, &lres)
+	require.True(t, lres.Success)
+	require.Equal(t, 1, len(lres.Batch))
+	require.Equal(t, a.Cid().String(), lres.Batch[0].Cid)
+	require.Equal(t, 2, lres.Batch[0].Count)
+	require.DeepEqual(t, []string{"alice-2"}, names(lres.Batch[0].Meta))
+
+	// pin/ls by owner is paged with limit and cursor.
+	listOwner := func(query string) *PinListResult {
+		r, err := gohttp.NewRequest(
+			gohttp.MethodGet,
+			"/api/v0/pin/ls?recursive=true&owner=bob&"+query,
+			nil,
+		)
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		h.PinList()(w, r)
+		require.Equal(t, gohttp.StatusOK, w.Code)
+		var res PinListResult
+		decodeResp(t, w, &res)
+		require.True(t, res.Success)
+		return &res
+	}
+	lres0 := listOwner("limit=1")
+	require.Equal(t, 1, len(lres0.Batch))
+	require.NotEmpty(t, lres0.Cursor)
+	lres1 := listOwner("limit=1&cursor=" + url.QueryEscape(lres0.Cursor))
+	require.Equal(t, 1, len(lres1.Batch))
+	require.Equal(t, "", lres1.Cursor)
+	require.NotEqual(t, lres0.Batch[0].Cid, lres1.Batch[0].Cid)
+	require.Equal(t, 2, len(listOwner("limit=2").Batch))
+
+	// All entries are removed once unpinned.
+	unpin(a, true, "bob")
+	unpin(a, true, "carol")
+	metas, err = pinner.ListMeta(ctx, a.Cid(), true)
+	require.NoError(t, err)
+	require.Equal(t, 0, len(metas))
+	owned, err = pinner.ListMetaByOwner(ctx, "alice", true)
+	require.NoError(t, err)
+	require.Equal(t, 0, len(owned))
+
+	// Metadata is not supported without datastore.
+	_, err = (&com.WrappedPinner{Pinner: rcp}).ListMeta(ctx, a.Cid(), true)
+	require.ErrorIs(t, com.ErrMetaUnsupported, err)
+}
@@ -0,0 +1,532 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	gohttp "net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	coreiface "github.com/ipfs/boxo/coreiface"
+	"github.com/ipfs/boxo/coreiface/options"
+	"github.com/ipfs/boxo/coreiface/path"
+	pinneriface "github.com/ipfs/boxo/pinning/pinner"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+
+	"github.com/photon-storage/go-common/log"
+	"github.com/photon-storage/go-common/metrics"
+	"github.com/photon-storage/go-gw3/common/http"
+	"github.com/photon-storage/go-gw3/common/reporting"
+
+	"github.com/photon-storage/falcon/node/com"
+)
+
+const (
+	// Signed P3 arg setting pin expiry in seconds.
+	argP3TTL = "arg-ttl"
+
+	defaultPinTTLSweepInterval = time.Minute
+
+	// Longest pin expiry accepted, which keeps the expiry time from
+	// overflowing.
+	maxPinTTLSecs = int64(10 * 365 * 24 * 60 * 60)
+)
+
+var (
+	ErrInvalidTTL = errors.New("invalid pin ttl")
+
+	pinTTLExpPrefix = datastore.NewKey("/falcon/pinttl/exp")
+	pinTTLCidPrefix = datastore.NewKey("/falcon/pinttl/cid")
+)
+
+// PinExpiry is a pin which is unpinned once expired. It carries the
+// signed request which pinned the CID for usage reporting.
+type PinExpiry struct {
+	ID        string            `json:"id"`
+	Cid       string            `json:"cid"`
+	Recursive bool              `json:"recursive"`
+	ExpireAt  int64             `json:"expire_at"`
+	AccountID string            `json:"account_id,omitempty"`
+	Req       reporting.AuthReq `json:"req"`
+}
+
+// PinExpiryReporter reports an expired pin being unpinned. stats is the
+// DAG stats of the unpinned CID.
+type PinExpiryReporter func(ctx context.Context, e *PinExpiry, stats *DagStats) error
+
+// PinTTL indexes pin expiries in the datastore by expiry time and by CID.
+// A sweeper decrements the pinner count of expired pins. An unpin by the
+// account consumes one of its expiries of the CID so the sweeper never
+// decrements a count held by others. If the count has already dropped to
+// zero, the expiry is dropped silently.
+type PinTTL struct {
+	ds       datastore.Datastore
+	api      coreiface.CoreAPI
+	report   PinExpiryReporter
+	dagCache *DagStatsCache
+	pinner   *com.WrappedPinner
+	quotas   *Quotas
+
+	mu sync.Mutex
+}
+
+func NewPinTTL(
+	ds datastore.Datastore,
+	api coreiface.CoreAPI,
+	report PinExpiryReporter,
+) *PinTTL {
+	return &PinTTL{
+		ds:     ds,
+		api:    api,
+		report: report,
+	}
+}
+
+func RegisterPinTTLMetrics() {
+	metrics.NewCounter("pin_ttl_added_total")
+	metrics.NewCounter("pin_ttl_expired_total")
+	metrics.NewCounter("pin_ttl_dropped_total")
+	metrics.NewCounter("pin_ttl_consumed_total")
+	metrics.NewCounter("pin_ttl_expire_err_total")
+}
+
+// SetPinTTL enables pin expiry.
+func (h *ExtendedHandlers) SetPinTTL(t *PinTTL) {
+	h.ttl = t
+}
+
+// SetDagStatsCache sets the cache used for DAG stats of expired pins.
+func (t *PinTTL) SetDagStatsCache(c *DagStatsCache) {
+	t.dagCache = c
+}
+
+// SetPinner sets the pinner storing metadata of expired pins.
+func (t *PinTTL) SetPinner(p *com.WrappedPinner) {
+	t.pinner = p
+}
+
+// SetQuotas sets the account quotas released by expired pins.
+func (t *PinTTL) SetQuotas(q *Quotas) {
+	t.quotas = q
+}
+
+// Start sweeps expired pins periodically.
+func (t *PinTTL) Start(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultPinTTLSweepInterval
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+
+			case <-ticker.C:
+				if err := t.Sweep(ctx, time.Now()); err != nil &&
+					ctx.Err() == nil {
+					log.Error("Error sweeping expired pins", "error", err)
+				}
+			}
+		}
+	}()
+}
+
+// Add indexes a pin expiry, assigning its ID.
+func (t *PinTTL) Add(ctx context.Context, e *PinExpiry) error {
+	e.ID = uuid.New().String()
+	if err := t.put(ctx, e); err != nil {
+		return err
+	}
+
+	metrics.CounterInc("pin_ttl_added_total")
+	return nil
+}
+
+// Consume deletes the latest expiry of the CID pinned by the account, as
+// the account unpinned one of its increments. It returns false if the
+// account has no expiry of the CID. A nil PinTTL consumes nothing.
+func (t *PinTTL) Consume(
+	ctx context.Context,
+	c cid.Cid,
+	recursive bool,
+	account string,
+) (bool, error) {
+	if t == nil {
+		return false, nil
+	}
+
+	// Expiries are claimed by the sweeper under the same lock, so an
+	// expiry is either consumed here or expired, never both.
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	expiries, err := t.list(ctx, c, recursive)
+	if err != nil {
+		return false, err
+	}
+
+	var latest *PinExpiry
+	for _, e := range expiries {
+		if e.AccountID != account {
+			continue
+		}
+		if latest == nil || e.ExpireAt > latest.ExpireAt {
+			latest = e
+		}
+	}
+	if latest == nil {
+		return false, nil
+	}
+	if err := t.deleteLocked(ctx, latest); err != nil {
+		return false, err
+	}
+	metrics.CounterInc("pin_ttl_consumed_total")
+	return true, nil
+}
+
+// list returns expiries of the CID.
+func (t *PinTTL) list(
+	ctx context.Context,
+	c cid.Cid,
+	recursive bool,
+) ([]*PinExpiry, error) {
+	res, err := t.ds.Query(ctx, query.Query{
+		Prefix: pinTTLCidPrefix.
+			ChildString(pinTTLMode(recursive)).
+			ChildString(c.String()).
+			String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var expiries []*PinExpiry
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		var e PinExpiry
+		if err := json.Unmarshal(r.Value, &e); err != nil {
+			return nil, err
+		}
+		expiries = append(expiries, &e)
+	}
+	return expiries, nil
+}
+
+// Remaining returns seconds until the earliest expiry of the CID. It
+// returns false if the CID has no pin with expiry.
+func (t *PinTTL) Remaining(
+	ctx context.Context,
+	c cid.Cid,
+	recursive bool,
+	now time.Time,
+) (int64, bool, error) {
+	expiries, err := t.list(ctx, c, recursive)
+	if err != nil {
+		return 0, false, err
+	}
+
+	var earliest int64
+	found := false
+	for _, e := range expiries {
+		if !found || e.ExpireAt < earliest {
+			earliest = e.ExpireAt
+			found = true
+		}
+	}
+	if !found {
+		return 0, false, nil
+	}
+
+	remaining := earliest - now.Unix()
+	if remaining < 0 {
+		remaining = 0
+	}
+	return remaining, true, nil
+}
+
+// Sweep unpins pins expired at now and reports the unpins. An expiry
+// failing to unpin is kept for the next sweep and does not block
+// expiries after it.
+func (t *PinTTL) Sweep(ctx context.Context, now time.Time) error {
+	expired, err := t.expired(ctx, now)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range expired {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := t.expire(ctx, e); err != nil {
+			metrics.CounterInc("pin_ttl_expire_err_total")
+			log.Error("Error expiring pin",
+				"id", e.ID,
+				"cid", e.Cid,
+				"error", err,
+			)
+		}
+	}
+	return nil
+}
+
+// expired lists expiries due at now in expiry order.
+func (t *PinTTL) expired(
+	ctx context.Context,
+	now time.Time,
+) ([]*PinExpiry, error) {
+	res, err := t.ds.Query(ctx, query.Query{
+		Prefix: pinTTLExpPrefix.String(),
+		Orders: []query.Order{query.OrderByKey{}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var expired []*PinExpiry
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		var e PinExpiry
+		if err := json.Unmarshal(r.Value, &e); err != nil {
+			return nil, err
+		}
+		if e.ExpireAt > now.Unix() {
+			break
+		}
+		expired = append(expired, &e)
+	}
+	return expired, nil
+}
+
+func (t *PinTTL) expire(ctx context.Context, e *PinExpiry) error {
+	c, err := cid.Decode(e.Cid)
+	if err != nil {
+		return t.delete(ctx, e)
+	}
+
+	// The expiry is deleted before unpinning so an unpin by the account
+	// consuming it meanwhile does not decrement the count twice.
+	claimed, err := t.claim(ctx, e)
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		return nil
+	}
+
+	ds := NewDagStats()
+	if err := t.dagCache.Calculate(ctx, t.api, c, e.Recursive, ds); err != nil {
+		// Ignore stats error.
+		log.Error("Error calculating dag stats",
+			"error", err,
+			"cid", e.Cid,
+			"source", "pin ttl",
+		)
+	}
+
+	unpinned := true
+	if err := t.api.Pin().Rm(
+		ctx,
+		path.IpfsPath(c),
+		options.Pin.RmRecursive(e.Recursive),
+	); err != nil {
+		if !errors.Is(err, pinneriface.ErrNotPinned) {
+			// Keep the expiry for the next sweep.
+			if perr := t.put(ctx, e); perr != nil {
+				log.Error("Error restoring pin expiry",
+					"id", e.ID,
+					"cid", e.Cid,
+					"error", perr,
+				)
+			}
+			return err
+		}
+		unpinned = false
+	}
+
+	if !unpinned {
+		metrics.CounterInc("pin_ttl_dropped_total")
+		return nil
+	}
+	metrics.CounterInc("pin_ttl_expired_total")
+	removePinMeta(ctx, t.pinner, c, e.Recursive, e.AccountID)
+	t.quotas.record(ctx, e.AccountID, ds, true)
+
+	if t.report != nil {
+		if err := t.report(ctx, e, ds); err != nil {
+			log.Error("Error reporting expired pin",
+				"id", e.ID,
+				"cid", e.Cid,
+				"error", err,
+			)
+		}
+	}
+	return nil
+}
+
+// claim deletes the expiry if it is still indexed. It returns false if
+// the expiry has been consumed since it was listed.
+func (t *PinTTL) claim(ctx context.Context, e *PinExpiry) (bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ok, err := t.ds.Has(ctx, pinTTLExpKey(e))
+	if err != nil || !ok {
+		return false, err
+	}
+	if err := t.deleteLocked(ctx, e); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (t *PinTTL) put(ctx context.Context, e *PinExpiry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err := t.ds.Put(ctx, pinTTLCidKey(e), data); err != nil {
+		return err
+	}
+	return t.ds.Put(ctx, pinTTLExpKey(e), data)
+}
+
+func (t *PinTTL) delete(ctx context.Context, e *PinExpiry) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.deleteLocked(ctx, e)
+}
+
+func (t *PinTTL) deleteLocked(ctx context.Context, e *PinExpiry) error {
+	if err := t.ds.Delete(ctx, pinTTLExpKey(e)); err != nil {
+		return err
+	}
+	return t.ds.Delete(ctx, pinTTLCidKey(e))
+}
+
+// consumePinExpiry consumes an expiry of an unpinned increment of the
+// owner. Errors are logged as the unpin has been done.
+func consumePinExpiry(
+	ctx context.Context,
+	t *PinTTL,
+	c cid.Cid,
+	recursive bool,
+	owner string,
+) {
+	if _, err := t.Consume(ctx, c, recursive, owner); err != nil {
+		log.Error("Error consuming pin expiry",
+			"error", err,
+			"cid", c.String(),
+		)
+	}
+}
+
+// annotate sets remaining TTL of listed pins. Errors are logged as TTL is
+// informational.
+func (t *PinTTL) annotate(
+	ctx context.Context,
+	recursive bool,
+	batch []*CidCount,
+) {
+	if t == nil {
+		return
+	}
+
+	now := time.Now()
+	for _, v := range batch {
+		c, err := cid.Decode(v.Cid)
+		if err != nil {
+			continue
+		}
+		remaining, ok, err := t.Remaining(ctx, c, recursive, now)
+		if err != nil {
+			log.Error("Error querying pin ttl", "cid", v.Cid, "error", err)
+			continue
+		}
+		if ok {
+			v.TTL = &remaining
+		}
+	}
+}
+
+// newPinExpiry creates an expiry carrying the signed request. It returns
+// nil if ttl is zero.
+func newPinExpiry(
+	r *gohttp.Request,
+	args *http.Args,
+	c cid.Cid,
+	recursive bool,
+	ttl time.Duration,
+) *PinExpiry {
+	if ttl <= 0 {
+		return nil
+	}
+
+	query := r.URL.Query()
+	return &PinExpiry{
+		Cid:       c.String(),
+		Recursive: recursive,
+		ExpireAt:  time.Now().Add(ttl).Unix(),
+		AccountID: args.GetArg(http.ArgP3AcctID),
+		Req: reporting.AuthReq{
+			Method: r.Method,
+			Host:   r.Host,
+			URI:    r.URL.Path,
+			Args:   query.Get(http.ParamP3Args),
+			Sig:    query.Get(http.ParamP3Sig),
+		},
+	}
+}
+
+// parseTTLArg parses pin expiry in seconds from signed args. Zero means
+// no expiry. Expiry beyond maxPinTTLSecs is rejected.
+func parseTTLArg(args *http.Args) (time.Duration, error) {
+	str := args.GetArg(argP3TTL)
+	if str == "" {
+		return 0, nil
+	}
+
+	secs, err := strconv.ParseInt(str, 10, 64)
+	if err != nil || secs <= 0 || secs > maxPinTTLSecs {
+		return 0, fmt.Errorf("%w: %v", ErrInvalidTTL, str)
+	}
+	return time.Duration(secs) * time.Second, nil
+}
+
+func pinTTLMode(recursive bool) string {
+	if recursive {
+		return "r"
+	}
+	return "d"
+}
+
+func pinTTLExpKey(e *PinExpiry) datastore.Key {
+	return pinTTLExpPrefix.
+		ChildString(fmt.Sprintf("%020d", e.ExpireAt)).
+		ChildString(e.ID)
+}
+
+func pinTTLCidKey(e *PinExpiry) datastore.Key {
+	return pinTTLCidPrefix.
+		ChildString(pinTTLMode(e.Recursive)).
+		ChildString(e.Cid).
+		ChildString(e.ID)
+}
@@ -0,0 +1,321 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	gohttp "net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	bs "github.com/ipfs/boxo/blockservice"
+	"github.com/ipfs/boxo/blockstore"
+	"github.com/ipfs/boxo/exchange/offline"
+	"github.com/ipfs/boxo/ipld/merkledag"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipfs/kubo/core"
+
+	"github.com/photon-storage/go-common/testing/require"
+	"github.com/photon-storage/go-gw3/common/http"
+	rcpinner "github.com/photon-storage/go-rc-pinner"
+
+	"github.com/photon-storage/falcon/node/com"
+)
+
+func TestParseTTLArg(t *testing.T) {
+	cases := []struct {
+		name string
+		val  string
+		ttl  time.Duration
+		err  error
+	}{
+		{
+			name: "absent",
+		},
+		{
+			name: "seconds",
+			val:  "60",
+			ttl:  time.Minute,
+		},
+		{
+			name: "zero",
+			val:  "0",
+			err:  ErrInvalidTTL,
+		},
+		{
+			name: "invalid",
+			val:  "1h",
+			err:  ErrInvalidTTL,
+		},
+		{
+			name: "max",
+			val:  strconv.FormatInt(maxPinTTLSecs, 10),
+			ttl:  time.Duration(maxPinTTLSecs) * time.Second,
+		},
+		{
+			name: "overflow",
+			val:  "9223372036854775807",
+			err:  ErrInvalidTTL,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			args := http.NewArgs()
+			if tc.val != "" {
+				args.SetArg(argP3TTL, tc.val)
+			}
+			ttl, err := parseTTLArg(args)
+			if tc.err != nil {
+				require.ErrorIs(t, tc.err, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.ttl, ttl)
+		})
+	}
+}
+
+func TestPinTTL(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	bstore := blockstore.NewBlockstore(dstore)
+	bserv := bs.New(bstore, offline.Exchange(bstore))
+	dserv := merkledag.NewDAGService(bserv)
+	rcp, err := rcpinner.New(ctx, dstore, dserv)
+	require.NoError(t, err)
+	pinner := &com.WrappedPinner{
+		Pinner: rcp,
+	}
+
+	// A{B}
+	a := rndNode(t)
+	b := rndNode(t)
+	c := rndNode(t)
+	require.NoError(t, a.AddNodeLink("c0", b))
+	require.NoError(t, dserv.Add(ctx, a))
+	require.NoError(t, dserv.Add(ctx, b))
+	require.NoError(t, dserv.Add(ctx, c))
+	require.NoError(t, pinner.Pin(ctx, a, true))
+	require.NoError(t, pinner.Pin(ctx, a, true))
+	require.NoError(t, pinner.Pin(ctx, b, false))
+
+	api := &mockAPI{
+		dag: &mockAPIDag{
+			DAGService: dserv,
+		},
+		pin: &mockAPIPin{
+			dag:    dserv,
+			pinner: pinner,
+		},
+	}
+	type reported struct {
+		e     *PinExpiry
+		stats *DagStats
+	}
+	var reports []*reported
+	ttl := NewPinTTL(
+		dstore,
+		api,
+		func(_ context.Context, e *PinExpiry, stats *DagStats) error {
+			reports = append(reports, &reported{e: e, stats: stats})
+			return nil
+		},
+	)
+
+	now := time.Now()
+	add := func(
+		nd *merkledag.ProtoNode,
+		recursive bool,
+		expireAt time.Time,
+	) *PinExpiry {
+		e := &PinExpiry{
+			Cid:       nd.Cid().String(),
+			Recursive: recursive,
+			ExpireAt:  expireAt.Unix(),
+		}
+		require.NoError(t, ttl.Add(ctx, e))
+		return e
+	}
+	count := func(nd *merkledag.ProtoNode, recursive bool) uint16 {
+		cnt, err := pinner.GetCount(ctx, nd.Cid(), recursive)
+		require.NoError(t, err)
+		return cnt
+	}
+
+	ea := add(a, true, now.Add(time.Minute))
+	add(a, true, now.Add(time.Hour))
+	add(b, false, now.Add(2*time.Minute))
+	// Not pinned, dropped without report.
+	add(c, true, now.Add(time.Minute))
+
+	remaining, ok, err := ttl.Remaining(ctx, a.Cid(), true, now)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, int64(60), remaining)
+	_, ok, err = ttl.Remaining(ctx, a.Cid(), false, now)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	batch := []*CidCount{
+		&CidCount{Cid: a.Cid().String(), Count: 2},
+		&CidCount{Cid: c.Cid().String(), Count: 0},
+		&CidCount{Cid: b.Cid().String(), Count: 1},
+	}
+	ttl.annotate(ctx, true, batch)
+	require.NotNil(t, batch[0].TTL)
+	require.NotNil(t, batch[1].TTL)
+	require.Nil(t, batch[2].TTL)
+
+	// Nothing expires yet.
+	require.NoError(t, ttl.Sweep(ctx, now))
+	require.Equal(t, 0, len(reports))
+	require.Equal(t, uint16(2), count(a, true))
+
+	// Expired pins are unpinned in expiry order.
+	require.NoError(t, ttl.Sweep(ctx, now.Add(5*time.Minute)))
+	require.Equal(t, 2, len(reports))
+	require.Equal(t, ea.ID, reports[0].e.ID)
+	require.Equal(t, totalSize(a, b), reports[0].stats.TotalSize.Load())
+	require.Equal(t, b.Cid().String(), reports[1].e.Cid)
+	require.Equal(t, uint16(1), count(a, true))
+	require.Equal(t, uint16(0), count(b, false))
+
+	remaining, ok, err = ttl.Remaining(ctx, a.Cid(), true, now)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, int64(3600), remaining)
+	_, ok, err = ttl.Remaining(ctx, c.Cid(), true, now)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	require.NoError(t, ttl.Sweep(ctx, now.Add(2*time.Hour)))
+	require.Equal(t, 3, len(reports))
+	require.Equal(t, uint16(0), count(a, true))
+	_, ok, err = ttl.Remaining(ctx, a.Cid(), true, now)
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func TestPinTTLConsumedByUnpin(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	bstore := blockstore.NewBlockstore(dstore)
+	bserv := bs.New(bstore, offline.Exchange(bstore))
+	dserv := merkledag.NewDAGService(bserv)
+	rcp, err := rcpinner.New(ctx, dstore, dserv)
+	require.NoError(t, err)
+	pinner := &com.WrappedPinner{
+		Pinner: rcp,
+	}
+
+	api := &mockAPI{
+		dag: &mockAPIDag{
+			DAGService: dserv,
+		},
+		pin: &mockAPIPin{
+			dag:    dserv,
+			pinner: pinner,
+		},
+	}
+	reports := 0
+	ttl := NewPinTTL(
+		dstore,
+		api,
+		func(context.Context, *PinExpiry, *DagStats) error {
+			reports++
+			return nil
+		},
+	)
+	h := New(
+		&core.IpfsNode{
+			Pinning: pinner,
+		},
+		api,
+		nil,
+	)
+	h.SetPinTTL(ttl)
+
+	// Alice pins X with a TTL and Bob pins X permanently.
+	x := rndNode(t)
+	require.NoError(t, dserv.Add(ctx, x))
+	require.NoError(t, pinner.Pin(ctx, x, true))
+	now := time.Now()
+	require.NoError(t, ttl.Add(ctx, &PinExpiry{
+		Cid:       x.Cid().String(),
+		Recursive: true,
+		ExpireAt:  now.Add(time.Minute).Unix(),
+		AccountID: "alice",
+	}))
+	require.NoError(t, pinner.Pin(ctx, x, true))
+
+	unpin := func(account string) {
+		var buf bytes.Buffer
+		require.NoError(t, json.NewEncoder(&buf).Encode(&PinBatchRequest{
+			Pins: []*PinBatchItem{{Cid: x.Cid().String(), Recursive: true}},
+		}))
+		query := url.Values{}
+		query.Set(
+			http.ParamP3Args,
+			http.NewArgs().SetArg(http.ArgP3AcctID, account).Encode(),
+		)
+		r := httptest.NewRequest(
+			gohttp.MethodPost,
+			"/api/v0/pin/rm/batch?"+query.Encode(),
+			&buf,
+		)
+		w := httptest.NewRecorder()
+		h.PinRmBatch().ServeHTTP(w, r)
+		require.Equal(t, gohttp.StatusOK, w.Code)
+	}
+	count := func() uint16 {
+		cnt, err := pinner.GetCount(ctx, x.Cid(), true)
+		require.NoError(t, err)
+		return cnt
+	}
+
+	// An unpin by another account keeps the expiry of Alice.
+	consumed, err := ttl.Consume(ctx, x.Cid(), true, "bob")
+	require.NoError(t, err)
+	require.False(t, consumed)
+	_, ok, err := ttl.Remaining(ctx, x.Cid(), true, now)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	// Alice unpins X, consuming her expiry.
+	unpin("alice")
+	require.Equal(t, uint16(1), count())
+	_, ok, err = ttl.Remaining(ctx, x.Cid(), true, now)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	// The permanent pin of Bob survives the expiry time.
+	require.NoError(t, ttl.Sweep(ctx, now.Add(time.Hour)))
+	require.Equal(t, 0, reports)
+	require.Equal(t, uint16(1), count())
+
+	// An expiry consumed after the sweeper listed it is not expired.
+	require.NoError(t, pinner.Pin(ctx, x, true))
+	require.NoError(t, ttl.Add(ctx, &PinExpiry{
+		Cid:       x.Cid().String(),
+		Recursive: true,
+		ExpireAt:  now.Add(time.Minute).Unix(),
+		AccountID: "alice",
+	}))
+	expired, err := ttl.expired(ctx, now.Add(time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 1, len(expired))
+	consumed, err = ttl.Consume(ctx, x.Cid(), true, "alice")
+	require.NoError(t, err)
+	require.True(t, consumed)
+	require.NoError(t, ttl.expire(ctx, expired[0]))
+	require.Equal(t, 0, reports)
+	require.Equal(t, uint16(2), count())
+}
@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	gohttp "net/http"
+	"strconv"
+	"strings"
+
+	pinneriface "github.com/ipfs/boxo/pinning/pinner"
+	"github.com/ipfs/go-cid"
+
+	"github.com/photon-storage/go-common/log"
+	"github.com/photon-storage/go-gw3/common/http"
+
+	"github.com/photon-storage/falcon/node/com"
+	"github.com/photon-storage/falcon/node/tracing"
+)
+
+// PinUpdateResult reports DAG stats applied to usage. With unpin, stats
+// are the delta between the two DAGs and may be negative. Otherwise they
+// are stats of the new root as a new pin.
+type PinUpdateResult struct {
+	Success               bool     `json:"success"`
+	Pins                  []string `json:"pins"`
+	AddedSize             int64    `json:"added_size"`
+	AddedNumBlocks        int64    `json:"added_num_blocks"`
+	RemovedSize           int64    `json:"removed_size"`
+	RemovedNumBlocks      int64    `json:"removed_num_blocks"`
+	DeduplicatedSize      int64    `json:"duplicated_size"`
+	DeduplicatedNumBlocks int64    `json:"duplicated_num_blocks"`
+	TotalSize             int64    `json:"total_size"`
+	TotalNumBlocks        int64    `json:"total_num_blocks"`
+	Message               string   `json:"message"`
+}
+
+// PinUpdate moves a recursive pin from one root to another. Only blocks
+// not linked from the old root are fetched. The reference count of the
+// old root is decremented unless unpin is false, and metadata of the
+// owner moves to the new root.
+func (h *ExtendedHandlers) PinUpdate() gohttp.HandlerFunc {
+	return gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		ctx, span := tracing.Span(r.Context(), "Handlers", "PinUpdate")
+		defer span.End()
+		r = r.WithContext(ctx)
+
+		from, to, unpin, err := parsePinUpdateParams(r)
+		if err != nil {
+			writeJSON(
+				w,
+				gohttp.StatusBadRequest,
+				&PinUpdateResult{
+					Success: false,
+					Message: fmt.Sprintf("error parsing params: %v", err),
+				},
+			)
+			return
+		}
+
+		args, err := parseSignedArgs(r)
+		if err != nil {
+			writeJSON(
+				w,
+				gohttp.StatusBadRequest,
+				&PinUpdateResult{
+					Success: false,
+					Message: fmt.Sprintf("error parsing params: %v", err),
+				},
+			)
+			return
+		}
+
+		meta, err := parsePinMeta(r, args)
+		if err != nil {
+			writeJSON(
+				w,
+				gohttp.StatusBadRequest,
+				&PinUpdateResult{
+					Success: false,
+					Message: fmt.Sprintf("error parsing params: %v", err),
+				},
+			)
+			return
+		}
+
+		pinner := com.GetRcPinner(h.nd.Pinning)
+		if pinner == nil {
+			writeJSON(
+				w,
+				gohttp.StatusNotImplemented,
+				&PinUpdateResult{
+					Success: false,
+					Message: "pin update requires the rc pinner",
+				},
+			)
+			return
+		}
+
+		// A moved pin does not add to the pinned count.
+		account := args.GetArg(http.ArgP3AcctID)
+		count := int64(1)
+		if unpin {
+			count = 0
+		}
+		ctx, guard, err := h.quotas.enforce(r.Context(), account, count)
+		if err != nil {
+			writeJSON(
+				w,
+				quotaErrorCode(err),
+				&PinUpdateResult{
+					Success: false,
+					Message: fmt.Sprintf("error checking quota: %v", err),
+				},
+			)
+			return
+		}
+		defer guard.stop()
+
+		delta, err := pinner.UpdateWithDelta(ctx, from, to, unpin)
+		if err != nil {
+			code := gohttp.StatusInternalServerError
+			switch {
+			case guard.isExceeded():
+				code = gohttp.StatusInsufficientStorage
+				err = ErrQuotaExceeded
+			case errors.Is(err, pinneriface.ErrNotPinned):
+				code = gohttp.StatusBadRequest
+			}
+			writeJSON(
+				w,
+				code,
+				&PinUpdateResult{
+					Success: false,
+					Message: fmt.Sprintf("error updating pin: %v", err),
+				},
+			)
+			return
+		}
+
+		// Only a moved pin of the account is charged the delta. Otherwise
+		// the new root is charged in full as if pinned alone.
+		owned := false
+		if unpin {
+			owned = removePinMeta(ctx, pinner, from, true, account)
+			consumePinExpiry(ctx, h.ttl, from, true, account)
+		}
+
+		ds := NewDagStats()
+		if owned {
+			size := delta.AddedSize - delta.RemovedSize
+			blocks := delta.AddedNumBlocks - delta.RemovedNumBlocks
+			ds.DeduplicatedSize.Store(size)
+			ds.DeduplicatedNumBlocks.Store(blocks)
+			ds.TotalSize.Store(size)
+			ds.TotalNumBlocks.Store(blocks)
+		} else if err := h.dagCache.Calculate(
+			ctx,
+			h.api,
+			to,
+			true,
+			ds,
+		); err != nil {
+			// Ignore stats error.
+			log.Error("Error calculating dag stats",
+				"error", err,
+				"cid", to.String(),
+				"source", "pin update",
+			)
+		}
+		if aggrDs := getDagStatsFromCtx(ctx); aggrDs != nil {
+			aggrDs.Add(ds)
+		}
+		h.quotas.record(ctx, account, ds, false)
+		addPinMeta(ctx, pinner, to, true, meta)
+
+		writeJSON(
+			w,
+			gohttp.StatusOK,
+			&PinUpdateResult{
+				Success:               true,
+				Pins:                  []string{from.String(), to.String()},
+				AddedSize:             delta.AddedSize,
+				AddedNumBlocks:        delta.AddedNumBlocks,
+				RemovedSize:           delta.RemovedSize,
+				RemovedNumBlocks:      delta.RemovedNumBlocks,
+				DeduplicatedSize:      ds.DeduplicatedSize.Load(),
+				DeduplicatedNumBlocks: ds.DeduplicatedNumBlocks.Load(),
+				TotalSize:             ds.TotalSize.Load(),
+				TotalNumBlocks:        ds.TotalNumBlocks.Load(),
+				Message:               "ok",
+			},
+		)
+	})
+}
+
+// parsePinUpdateParams parses the old and new roots from two arg params.
+// Unpin defaults to true as in Kubo.
+func parsePinUpdateParams(r *gohttp.Request) (cid.Cid, cid.Cid, bool, error) {
+	query := r.URL.Query()
+	vals := query[http.ParamIPFSArg]
+	if len(vals) != 2 {
+		return cid.Undef, cid.Undef, false, ErrInvalidCID
+	}
+	from, err := cid.Decode(vals[0])
+	if err != nil {
+		return cid.Undef, cid.Undef, false, ErrInvalidCID
+	}
+	to, err := cid.Decode(vals[1])
+	if err != nil {
+		return cid.Undef, cid.Undef, false, ErrInvalidCID
+	}
+
+	unpin := true
+	if str := strings.TrimSpace(query.Get("unpin")); str != "" {
+		if unpin, err = strconv.ParseBool(str); err != nil {
+			return cid.Undef, cid.Undef, false, err
+		}
+	}
+	return from, to, unpin, nil
+}
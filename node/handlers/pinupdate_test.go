@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"context"
+	gohttp "net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	bs "github.com/ipfs/boxo/blockservice"
+	"github.com/ipfs/boxo/blockstore"
+	"github.com/ipfs/boxo/exchange/offline"
+	"github.com/ipfs/boxo/ipld/merkledag"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipfs/kubo/core"
+
+	"github.com/photon-storage/go-common/testing/require"
+	"github.com/photon-storage/go-gw3/common/http"
+	rcpinner "github.com/photon-storage/go-rc-pinner"
+
+	"github.com/photon-storage/falcon/node/com"
+)
+
+func TestPinUpdate(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	bstore := blockstore.NewBlockstore(dstore)
+	dserv := merkledag.NewDAGService(bs.New(bstore, offline.Exchange(bstore)))
+	rcp, err := rcpinner.New(ctx, dstore, dserv)
+	require.NoError(t, err)
+	pinner := &com.WrappedPinner{
+		Pinner:    rcp,
+		Datastore: dstore,
+		DAG:       dserv,
+	}
+
+	// A{x: B, y: C} is updated to A2{x: B2{D}, y: C, z: B}. B moves so
+	// only A2, B2 and D are new, and only A is removed.
+	a := rndNode(t)
+	b := rndNode(t)
+	c := rndNode(t)
+	a2 := rndNode(t)
+	b2 := rndNode(t)
+	d := rndNode(t)
+	require.NoError(t, a.AddNodeLink("x", b))
+	require.NoError(t, a.AddNodeLink("y", c))
+	require.NoError(t, b2.AddNodeLink("d", d))
+	require.NoError(t, a2.AddNodeLink("x", b2))
+	require.NoError(t, a2.AddNodeLink("y", c))
+	require.NoError(t, a2.AddNodeLink("z", b))
+	for _, nd := range []*merkledag.ProtoNode{a, b, c, a2, b2, d} {
+		require.NoError(t, dserv.Add(ctx, nd))
+	}
+	require.NoError(t, pinner.Pin(ctx, a, true))
+	require.NoError(t, pinner.AddMeta(ctx, a.Cid(), true, &com.PinMeta{
+		Owner: "acct",
+	}))
+
+	h := New(
+		&core.IpfsNode{
+			Pinning: pinner,
+		},
+		&mockAPI{
+			dag: &mockAPIDag{
+				DAGService: dserv,
+			},
+		},
+		nil,
+	)
+
+	callAs := func(
+		acct string,
+		query string,
+		stats *DagStats,
+	) (int, *PinUpdateResult) {
+		args := http.NewArgs().SetArg(http.ArgP3AcctID, acct)
+		r := httptest.NewRequest(
+			gohttp.MethodPost,
+			"/api/v0/pin/update?"+query+"&"+http.ParamP3Args+"="+
+				url.QueryEscape(args.Encode()),
+			nil,
+		)
+		r = r.WithContext(WithDagStat(r.Context(), stats))
+		w := httptest.NewRecorder()
+		h.PinUpdate().ServeHTTP(w, r)
+		var res PinUpdateResult
+		decodeResp(t, w, &res)
+		return w.Code, &res
+	}
+	call := func(query string, stats *DagStats) (int, *PinUpdateResult) {
+		return callAs("acct", query, stats)
+	}
+	count := func(k *merkledag.ProtoNode) uint16 {
+		cnt, err := pinner.GetCount(ctx, k.Cid(), true)
+		require.NoError(t, err)
+		return cnt
+	}
+
+	code, res := call("arg="+a.Cid().String(), nil)
+	require.Equal(t, gohttp.StatusBadRequest, code)
+	require.False(t, res.Success)
+
+	// B is not pinned.
+	code, res = call("arg="+b.Cid().String()+"&arg="+a2.Cid().String(), nil)
+	require.Equal(t, gohttp.StatusBadRequest, code)
+	require.False(t, res.Success)
+	require.Equal(t, uint16(0), count(a2))
+
+	stats := NewDagStats()
+	code, res = call("arg="+a.Cid().String()+"&arg="+a2.Cid().String(), stats)
+	require.Equal(t, gohttp.StatusOK, code)
+	require.True(t, res.Success)
+	added := int64(len(a2.RawData()) + len(b2.RawData()) + len(d.RawData()))
+	removed := int64(len(a.RawData()))
+	require.Equal(t, added, res.AddedSize)
+	require.Equal(t, int64(3), res.AddedNumBlocks)
+	require.Equal(t, removed, res.RemovedSize)
+	require.Equal(t, int64(1), res.RemovedNumBlocks)
+	require.Equal(t, added-removed, res.TotalSize)
+	require.Equal(t, int64(2), res.TotalNumBlocks)
+	require.Equal(t, int64(0), stats.TotalCount.Load())
+	require.Equal(t, added-removed, stats.TotalSize.Load())
+	require.Equal(t, uint16(0), count(a))
+	require.Equal(t, uint16(1), count(a2))
+
+	// Keep the old pin. The new root is pinned again as a new pin.
+	stats = NewDagStats()
+	code, res = call(
+		"arg="+a2.Cid().String()+"&arg="+a.Cid().String()+"&unpin=false",
+		stats,
+	)
+	require.Equal(t, gohttp.StatusOK, code)
+	require.True(t, res.Success)
+	require.Equal(t, int64(1), stats.TotalCount.Load())
+	require.Equal(t, totalSize(a, b, c), stats.TotalSize.Load())
+	require.Equal(t, uint16(1), count(a))
+	require.Equal(t, uint16(1), count(a2))
+
+	// A pin of another account is not credited. The new root is charged
+	// in full.
+	stats = NewDagStats()
+	code, res = callAs(
+		"other",
+		"arg="+a.Cid().String()+"&arg="+a2.Cid().String(),
+		stats,
+	)
+	require.Equal(t, gohttp.StatusOK, code)
+	require.True(t, res.Success)
+	require.Equal(t, int64(1), stats.TotalCount.Load())
+	require.Equal(t, totalSize(a2, b2, d, c, b), stats.TotalSize.Load())
+	require.Equal(t, uint16(0), count(a))
+	require.Equal(t, uint16(2), count(a2))
+}
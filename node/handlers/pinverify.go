@@ -0,0 +1,466 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	gohttp "net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipfs/boxo/blockstore"
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+
+	"github.com/photon-storage/go-common/log"
+	"github.com/photon-storage/go-common/metrics"
+	"github.com/photon-storage/go-gw3/common/http"
+	rcpinner "github.com/photon-storage/go-rc-pinner"
+
+	"github.com/photon-storage/falcon/node/com"
+	"github.com/photon-storage/falcon/node/tracing"
+)
+
+var (
+	ErrRepairRequiresCID = errors.New("repair requires a root CID")
+)
+
+// PinVerifyResult reports missing and corrupt blocks of a pinned root.
+// Blocks refetched by repair are listed in Repaired only.
+type PinVerifyResult struct {
+	Cid       string   `json:"cid"`
+	Recursive bool     `json:"recursive"`
+	Ok        bool     `json:"ok"`
+	NumBlocks int      `json:"num_blocks"`
+	Missing   []string `json:"missing,omitempty"`
+	Corrupt   []string `json:"corrupt,omitempty"`
+	Repaired  []string `json:"repaired,omitempty"`
+	Message   string   `json:"message,omitempty"`
+}
+
+// PinVerifier walks pinned DAGs in the local blockstore, checking that
+// every block is present and matches its CID. With repair enabled, bad
+// blocks are refetched from the network and written back.
+type PinVerifier struct {
+	bstore blockstore.Blockstore
+	fetch  ipld.NodeGetter
+	pinner *com.WrappedPinner
+
+	// Serializes full runs so a slow run is not overlapped.
+	mu sync.Mutex
+}
+
+// NewPinVerifier creates a verifier reading blocks from bstore only.
+// fetch is used for repair and should be backed by the network.
+func NewPinVerifier(
+	bstore blockstore.Blockstore,
+	fetch ipld.NodeGetter,
+	pinner *com.WrappedPinner,
+) *PinVerifier {
+	return &PinVerifier{
+		bstore: bstore,
+		fetch:  fetch,
+		pinner: pinner,
+	}
+}
+
+func RegisterPinVerifyMetrics() {
+	metrics.NewCounter("pin_verify_runs_total")
+	metrics.NewCounter("pin_verify_roots_total")
+	metrics.NewCounter("pin_verify_bad_roots_total")
+	metrics.NewCounter("pin_verify_missing_blocks_total")
+	metrics.NewCounter("pin_verify_corrupt_blocks_total")
+	metrics.NewCounter("pin_verify_repaired_blocks_total")
+	metrics.NewGauge("pin_verify_bad_roots")
+}
+
+// SetPinVerifier enables pin verification.
+func (h *ExtendedHandlers) SetPinVerifier(v *PinVerifier) {
+	h.verifier = v
+}
+
+// Start verifies all pins periodically. A zero interval disables the
+// background verification.
+func (v *PinVerifier) Start(
+	ctx context.Context,
+	interval time.Duration,
+	repair bool,
+) {
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+
+			case <-ticker.C:
+				if err := v.run(ctx, repair); err != nil && ctx.Err() == nil {
+					log.Error("Error verifying pins", "error", err)
+				}
+			}
+		}
+	}()
+}
+
+func (v *PinVerifier) run(ctx context.Context, repair bool) error {
+	if !v.mu.TryLock() {
+		log.Warn("Skip pin verification as previous run is in progress")
+		return nil
+	}
+	defer v.mu.Unlock()
+
+	metrics.CounterInc("pin_verify_runs_total")
+	bad := 0
+	if err := v.VerifyAll(ctx, repair, func(res *PinVerifyResult) error {
+		if !res.Ok {
+			bad++
+			log.Warn("Pinned DAG is damaged",
+				"cid", res.Cid,
+				"recursive", res.Recursive,
+				"missing", len(res.Missing),
+				"corrupt", len(res.Corrupt),
+				"repaired", len(res.Repaired),
+				"message", res.Message,
+			)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	metrics.GaugeSet("pin_verify_bad_roots", float64(bad))
+	return nil
+}
+
+// VerifyAll verifies all recursive and direct pins, calling fn with the
+// result of each root. It stops at the first error returned by fn.
+func (v *PinVerifier) VerifyAll(
+	ctx context.Context,
+	repair bool,
+	fn func(res *PinVerifyResult) error,
+) error {
+	for _, recursive := range []bool{true, false} {
+		// Collect roots first so the pinner index is not held while
+		// walking DAGs.
+		var ch <-chan *rcpinner.StreamedCidWithCount
+		if recursive {
+			ch = v.pinner.RecursiveKeysWithCount(ctx)
+		} else {
+			ch = v.pinner.DirectKeysWithCount(ctx)
+		}
+		var roots []cid.Cid
+		for r := range ch {
+			if r.Cid.Err != nil {
+				return fmt.Errorf("pinner index error: %w", r.Cid.Err)
+			}
+			if r.Count > 0 {
+				roots = append(roots, r.Cid.C)
+			}
+		}
+
+		for _, c := range roots {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := fn(v.Verify(ctx, c, recursive, repair)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Verify walks the DAG of a pinned root in the local blockstore. Only the
+// root block is checked for direct pins.
+func (v *PinVerifier) Verify(
+	ctx context.Context,
+	root cid.Cid,
+	recursive bool,
+	repair bool,
+) *PinVerifyResult {
+	res := &PinVerifyResult{
+		Cid:       root.String(),
+		Recursive: recursive,
+	}
+
+	visited := map[cid.Cid]bool{root: true}
+	stack := []cid.Cid{root}
+	for len(stack) > 0 {
+		if err := ctx.Err(); err != nil {
+			res.Message = err.Error()
+			break
+		}
+
+		c := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		res.NumBlocks++
+
+		data, err := v.check(ctx, c, repair, res)
+		if err != nil {
+			res.Message = fmt.Sprintf("error reading %v: %v", c, err)
+			break
+		}
+		if data == nil || !recursive {
+			continue
+		}
+
+		links, err := decodeLinks(c, data)
+		if err != nil {
+			// The block matches its CID but cannot be decoded, which
+			// cannot be repaired by refetching.
+			res.Corrupt = append(res.Corrupt, c.String())
+			continue
+		}
+		for _, l := range links {
+			if !visited[l] {
+				visited[l] = true
+				stack = append(stack, l)
+			}
+		}
+	}
+
+	res.Ok = len(res.Missing) == 0 &&
+		len(res.Corrupt) == 0 &&
+		res.Message == ""
+
+	metrics.CounterInc("pin_verify_roots_total")
+	metrics.CounterAdd("pin_verify_missing_blocks_total", float64(len(res.Missing)))
+	metrics.CounterAdd("pin_verify_corrupt_blocks_total", float64(len(res.Corrupt)))
+	metrics.CounterAdd("pin_verify_repaired_blocks_total", float64(len(res.Repaired)))
+	if !res.Ok {
+		metrics.CounterInc("pin_verify_bad_roots_total")
+	}
+	return res
+}
+
+// check reads a block and verifies its hash, repairing it if requested.
+// It returns the block data, or nil if the block is bad.
+func (v *PinVerifier) check(
+	ctx context.Context,
+	c cid.Cid,
+	repair bool,
+	res *PinVerifyResult,
+) ([]byte, error) {
+	missing := false
+	blk, err := v.bstore.Get(ctx, c)
+	if ipld.IsNotFound(err) {
+		missing = true
+	} else if err != nil {
+		return nil, err
+	} else {
+		sum, err := c.Prefix().Sum(blk.RawData())
+		if err != nil {
+			return nil, err
+		}
+		if sum.Equals(c) {
+			return blk.RawData(), nil
+		}
+	}
+
+	if repair {
+		data, err := v.repair(ctx, c, missing)
+		if err == nil {
+			res.Repaired = append(res.Repaired, c.String())
+			return data, nil
+		}
+		log.Error("Error repairing block",
+			"cid", c.String(),
+			"error", err,
+		)
+	}
+
+	if missing {
+		res.Missing = append(res.Missing, c.String())
+	} else {
+		res.Corrupt = append(res.Corrupt, c.String())
+	}
+	return nil, nil
+}
+
+// repair refetches a block and writes it to the blockstore. A corrupt
+// block is deleted first as the blockstore skips writing existing keys.
+func (v *PinVerifier) repair(
+	ctx context.Context,
+	c cid.Cid,
+	missing bool,
+) ([]byte, error) {
+	if !missing {
+		if err := v.bstore.DeleteBlock(ctx, c); err != nil {
+			return nil, err
+		}
+	}
+
+	nd, err := v.fetch.Get(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	if err := v.bstore.Put(ctx, nd); err != nil {
+		return nil, err
+	}
+	return nd.RawData(), nil
+}
+
+// PinVerify verifies pinned DAGs and streams one JSON result per root.
+// All pins are verified if no CID is given. Healthy roots are only
+// reported with verbose set.
+func (h *ExtendedHandlers) PinVerify() gohttp.HandlerFunc {
+	return gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		ctx, span := tracing.Span(r.Context(), "Handlers", "PinVerify")
+		defer span.End()
+
+		if h.verifier == nil {
+			writeJSON(
+				w,
+				gohttp.StatusNotImplemented,
+				&PinVerifyResult{
+					Message: "pin verification is not enabled",
+				},
+			)
+			return
+		}
+
+		opts, err := parsePinVerifyParams(r)
+		if err != nil {
+			writeJSON(
+				w,
+				gohttp.StatusBadRequest,
+				&PinVerifyResult{
+					Message: fmt.Sprintf("error parsing params: %v", err),
+				},
+			)
+			return
+		}
+
+		// Only pinned roots are verified, which bounds what repair fetches.
+		if opts.root.Defined() {
+			cnt, err := h.verifier.pinner.GetCount(
+				ctx,
+				opts.root,
+				opts.recursive,
+			)
+			if err != nil {
+				writeJSON(
+					w,
+					gohttp.StatusInternalServerError,
+					&PinVerifyResult{
+						Cid:     opts.root.String(),
+						Message: fmt.Sprintf("error reading pin count: %v", err),
+					},
+				)
+				return
+			}
+			if cnt == 0 {
+				writeJSON(
+					w,
+					gohttp.StatusNotFound,
+					&PinVerifyResult{
+						Cid:     opts.root.String(),
+						Message: "CID is not pinned",
+					},
+				)
+				return
+			}
+		}
+
+		// A full run is rejected while another one is in progress.
+		if !opts.root.Defined() {
+			if !h.verifier.mu.TryLock() {
+				writeJSON(
+					w,
+					gohttp.StatusConflict,
+					&PinVerifyResult{
+						Message: "pin verification is in progress",
+					},
+				)
+				return
+			}
+			defer h.verifier.mu.Unlock()
+		}
+
+		setStreamHeaders(w.Header(), streamNDJSON)
+		w.WriteHeader(gohttp.StatusOK)
+		enc := json.NewEncoder(w)
+		write := func(res *PinVerifyResult) error {
+			if res.Ok && !opts.verbose {
+				return nil
+			}
+			if err := enc.Encode(res); err != nil {
+				return err
+			}
+			if fl, ok := w.(gohttp.Flusher); ok {
+				fl.Flush()
+			}
+			return nil
+		}
+
+		if opts.root.Defined() {
+			if err := write(h.verifier.Verify(
+				ctx,
+				opts.root,
+				opts.recursive,
+				opts.repair,
+			)); err != nil {
+				log.Error("Error writing pin verify result", "error", err)
+			}
+			return
+		}
+
+		if err := h.verifier.VerifyAll(ctx, opts.repair, write); err != nil {
+			log.Error("Error verifying pins", "error", err)
+			_ = enc.Encode(&PinVerifyResult{
+				Message: err.Error(),
+			})
+		}
+	})
+}
+
+type pinVerifyOptions struct {
+	root      cid.Cid
+	recursive bool
+	repair    bool
+	verbose   bool
+}
+
+func parsePinVerifyParams(r *gohttp.Request) (*pinVerifyOptions, error) {
+	query := r.URL.Query()
+	opts := &pinVerifyOptions{
+		recursive: true,
+	}
+
+	if str := strings.TrimSpace(query.Get(http.ParamIPFSArg)); str != "" {
+		c, err := cid.Decode(str)
+		if err != nil {
+			return nil, ErrInvalidCID
+		}
+		opts.root = c
+	}
+
+	for k, v := range map[string]*bool{
+		http.ParamIPFSRecursive: &opts.recursive,
+		"repair":                &opts.repair,
+		"verbose":               &opts.verbose,
+	} {
+		str := strings.TrimSpace(query.Get(k))
+		if str == "" {
+			continue
+		}
+		b, err := strconv.ParseBool(str)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %v: %v", k, str)
+		}
+		*v = b
+	}
+
+	// Repair fetches from the network, so it is limited to one root.
+	if opts.repair && !opts.root.Defined() {
+		return nil, ErrRepairRequiresCID
+	}
+	return opts, nil
+}
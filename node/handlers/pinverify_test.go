@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	gohttp "net/http"
+	"net/http/httptest"
+	"testing"
+
+	bs "github.com/ipfs/boxo/blockservice"
+	"github.com/ipfs/boxo/blockstore"
+	"github.com/ipfs/boxo/datastore/dshelp"
+	"github.com/ipfs/boxo/exchange/offline"
+	"github.com/ipfs/boxo/ipld/merkledag"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipfs/kubo/core"
+
+	"github.com/photon-storage/go-common/testing/require"
+	rcpinner "github.com/photon-storage/go-rc-pinner"
+
+	"github.com/photon-storage/falcon/node/com"
+)
+
+func TestPinVerify(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	bstore := blockstore.NewBlockstore(dstore)
+	bserv := bs.New(bstore, offline.Exchange(bstore))
+	dserv := merkledag.NewDAGService(bserv)
+	rcp, err := rcpinner.New(ctx, dstore, dserv)
+	require.NoError(t, err)
+	pinner := &com.WrappedPinner{
+		Pinner: rcp,
+	}
+
+	// Blocks available from the network.
+	netBstore := blockstore.NewBlockstore(
+		dssync.MutexWrap(ds.NewMapDatastore()),
+	)
+	netDserv := merkledag.NewDAGService(
+		bs.New(netBstore, offline.Exchange(netBstore)),
+	)
+
+	// A{B, C} recursive, D direct.
+	a := rndNode(t)
+	b := rndNode(t)
+	c := rndNode(t)
+	d := rndNode(t)
+	require.NoError(t, a.AddNodeLink("c0", b))
+	require.NoError(t, a.AddNodeLink("c1", c))
+	for _, nd := range []*merkledag.ProtoNode{a, b, c, d} {
+		require.NoError(t, dserv.Add(ctx, nd))
+		require.NoError(t, netDserv.Add(ctx, nd))
+	}
+	require.NoError(t, pinner.Pin(ctx, a, true))
+	require.NoError(t, pinner.Pin(ctx, d, false))
+
+	v := NewPinVerifier(bstore, netDserv, pinner)
+	h := New(
+		&core.IpfsNode{
+			Pinning: pinner,
+		},
+		nil,
+		nil,
+	)
+	h.SetPinVerifier(v)
+
+	serve := func(query string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(
+			gohttp.MethodPost,
+			"/api/v0/pin/verify?"+query,
+			nil,
+		)
+		w := httptest.NewRecorder()
+		h.PinVerify()(w, r)
+		return w
+	}
+	call := func(query string) []*PinVerifyResult {
+		w := serve(query)
+		require.Equal(t, gohttp.StatusOK, w.Code)
+
+		var res []*PinVerifyResult
+		sc := bufio.NewScanner(w.Body)
+		for sc.Scan() {
+			var v PinVerifyResult
+			require.NoError(t, json.Unmarshal(sc.Bytes(), &v))
+			res = append(res, &v)
+		}
+		return res
+	}
+
+	// Healthy pins are only reported with verbose.
+	require.Equal(t, 0, len(call("")))
+	res := call("verbose=true")
+	require.Equal(t, 2, len(res))
+	require.Equal(t, a.Cid().String(), res[0].Cid)
+	require.True(t, res[0].Ok)
+	require.True(t, res[0].Recursive)
+	require.Equal(t, 3, res[0].NumBlocks)
+	require.Equal(t, d.Cid().String(), res[1].Cid)
+	require.True(t, res[1].Ok)
+	require.False(t, res[1].Recursive)
+	require.Equal(t, 1, res[1].NumBlocks)
+
+	// Lose C and corrupt B.
+	require.NoError(t, bstore.DeleteBlock(ctx, c.Cid()))
+	require.NoError(t, dstore.Put(
+		ctx,
+		blockstore.BlockPrefix.Child(dshelp.MultihashToDsKey(b.Cid().Hash())),
+		[]byte("corrupt"),
+	))
+
+	res = call("")
+	require.Equal(t, 1, len(res))
+	require.Equal(t, a.Cid().String(), res[0].Cid)
+	require.False(t, res[0].Ok)
+	require.DeepEqual(t, []string{c.Cid().String()}, res[0].Missing)
+	require.DeepEqual(t, []string{b.Cid().String()}, res[0].Corrupt)
+	require.Equal(t, 0, len(res[0].Repaired))
+
+	// Roots not pinned are not verified.
+	require.Equal(
+		t,
+		gohttp.StatusNotFound,
+		serve("arg="+b.Cid().String()+"&repair=true").Code,
+	)
+	require.Equal(
+		t,
+		gohttp.StatusNotFound,
+		serve("arg="+a.Cid().String()+"&recursive=false").Code,
+	)
+
+	// Direct verification only checks the root block.
+	res = call("arg=" + d.Cid().String() + "&recursive=false&verbose=true")
+	require.Equal(t, 1, len(res))
+	require.True(t, res[0].Ok)
+	require.Equal(t, 1, res[0].NumBlocks)
+
+	// Repair is limited to a root.
+	require.Equal(t, gohttp.StatusBadRequest, serve("repair=true").Code)
+
+	// A full run is rejected while another one is in progress.
+	v.mu.Lock()
+	require.Equal(t, gohttp.StatusConflict, serve("").Code)
+	require.Equal(t, 1, len(call("arg="+a.Cid().String())))
+	v.mu.Unlock()
+
+	// Repair refetches bad blocks.
+	res = call("arg=" + a.Cid().String() + "&repair=true&verbose=true")
+	require.Equal(t, 1, len(res))
+	require.True(t, res[0].Ok)
+	require.Equal(t, 2, len(res[0].Repaired))
+	require.Equal(t, 0, len(res[0].Missing))
+	require.Equal(t, 0, len(res[0].Corrupt))
+	require.Equal(t, 0, len(call("")))
+
+	blk, err := bstore.Get(ctx, b.Cid())
+	require.NoError(t, err)
+	require.DeepEqual(t, b.RawData(), blk.RawData())
+
+	// Blocks unavailable from the network stay missing.
+	require.NoError(t, bstore.DeleteBlock(ctx, d.Cid()))
+	require.NoError(t, netBstore.DeleteBlock(ctx, d.Cid()))
+	r := v.Verify(ctx, d.Cid(), false, true)
+	require.False(t, r.Ok)
+	require.DeepEqual(t, []string{d.Cid().String()}, r.Missing)
+
+	// Verification is not enabled.
+	w := httptest.NewRecorder()
+	New(nil, nil, nil).PinVerify()(
+		w,
+		httptest.NewRequest(gohttp.MethodPost, "/api/v0/pin/verify", nil),
+	)
+	require.Equal(t, gohttp.StatusNotImplemented, w.Code)
+}
@@ -0,0 +1,904 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	gohttp "net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	pin "github.com/ipfs/boxo/pinning/pinner"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+
+	"github.com/photon-storage/go-common/log"
+	"github.com/photon-storage/go-gw3/common/http"
+
+	"github.com/photon-storage/falcon/node/com"
+	"github.com/photon-storage/falcon/node/tracing"
+)
+
+type PSAStatus string
+
+const (
+	PSAQueued  PSAStatus = "queued"
+	PSAPinning PSAStatus = "pinning"
+	PSAPinned  PSAStatus = "pinned"
+	PSAFailed  PSAStatus = "failed"
+
+	psaMatchExact    = "exact"
+	psaMatchIExact   = "iexact"
+	psaMatchPartial  = "partial"
+	psaMatchIPartial = "ipartial"
+
+	psaDefaultLimit = 10
+	psaMaxLimit     = 1000
+	psaMaxCids      = 10
+	psaMaxNameLen   = 255
+	psaPinsPath     = "/pins"
+
+	psaOriginConnectTimeout = 30 * time.Second
+)
+
+var (
+	ErrPSANotFound       = errors.New("pin request not found")
+	ErrPSAUnavailable    = errors.New("pinning service is not enabled")
+	ErrPSAInvalidName    = errors.New("name exceeds 255 characters")
+	ErrPSAInvalidMatch   = errors.New("invalid text matching strategy")
+	ErrPSAInvalidLimit   = errors.New("invalid limit")
+	ErrPSAInvalidStatus  = errors.New("invalid status")
+	ErrPSATooManyCids    = errors.New("too many CIDs")
+	ErrPSAInvalidTime    = errors.New("invalid timestamp")
+	ErrPSAInvalidMeta    = errors.New("invalid meta")
+	ErrPSAInvalidRequest = errors.New("invalid request")
+
+	psaPrefix = datastore.NewKey("/falcon/psa/pins")
+)
+
+// PSAPin is the pin object of the IPFS Pinning Service API.
+type PSAPin struct {
+	Cid     string            `json:"cid"`
+	Name    string            `json:"name,omitempty"`
+	Origins []string          `json:"origins,omitempty"`
+	Meta    map[string]string `json:"meta,omitempty"`
+}
+
+type PSAPinStatus struct {
+	RequestID string            `json:"requestid"`
+	Status    PSAStatus         `json:"status"`
+	Created   time.Time         `json:"created"`
+	Pin       *PSAPin           `json:"pin"`
+	Delegates []string          `json:"delegates"`
+	Info      map[string]string `json:"info,omitempty"`
+}
+
+type PSAPinResults struct {
+	Count   int             `json:"count"`
+	Results []*PSAPinStatus `json:"results"`
+}
+
+type PSAFailure struct {
+	Error PSAError `json:"error"`
+}
+
+type PSAError struct {
+	Reason  string `json:"reason"`
+	Details string `json:"details,omitempty"`
+}
+
+// psaRecord is a pin request persisted in repo datastore. The request ID
+// is the ID of the pin job which pins the CID. A removed record is kept
+// as a tombstone until its running job finishes so the pin could be
+// reverted.
+type psaRecord struct {
+	RequestID string    `json:"requestid"`
+	AccountID string    `json:"account_id,omitempty"`
+	Status    PSAStatus `json:"status"`
+	Message   string    `json:"message,omitempty"`
+	Created   time.Time `json:"created"`
+	Pin       *PSAPin   `json:"pin"`
+	Removed   bool      `json:"removed,omitempty"`
+}
+
+func (rec *psaRecord) finished() bool {
+	return rec.Status == PSAPinned || rec.Status == PSAFailed
+}
+
+// PinService implements the IPFS Pinning Service API on top of the pin
+// job queue, which pins requested CIDs asynchronously and reports usage.
+type PinService struct {
+	ds     datastore.Datastore
+	jobs   *PinJobQueue
+	pinner pin.Pinner
+
+	// Serializes record updates between requests and job hook.
+	mu sync.Mutex
+}
+
+// NewPinService creates a pinning service and hooks it to the job queue.
+// It must be called before the queue starts.
+func NewPinService(
+	ds datastore.Datastore,
+	jobs *PinJobQueue,
+	pinner pin.Pinner,
+) *PinService {
+	s := &PinService{
+		ds:     ds,
+		jobs:   jobs,
+		pinner: pinner,
+	}
+	jobs.OnFinish(s.onJobFinish)
+	return s
+}
+
+// Add enqueues a pin job for the pin object and records the request.
+func (s *PinService) Add(
+	ctx context.Context,
+	p *PSAPin,
+	j *PinJob,
+) (*psaRecord, error) {
+	if err := s.jobs.Enqueue(ctx, j); err != nil {
+		return nil, err
+	}
+
+	rec := &psaRecord{
+		RequestID: j.ID,
+		AccountID: j.AccountID,
+		Status:    PSAQueued,
+		Created:   time.Unix(j.CreatedAt, 0).UTC(),
+		Pin:       p,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// The job could have finished before the record is written.
+	if j, err := s.jobs.Get(ctx, j.ID); err == nil {
+		rec.Status, rec.Message = psaStatus(j)
+	}
+	if err := s.put(ctx, rec); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// Get returns the request with status refreshed from its pin job.
+func (s *PinService) Get(ctx context.Context, id string) (*psaRecord, error) {
+	rec, err := s.load(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if rec.Removed {
+		return nil, ErrPSANotFound
+	}
+	return rec, s.refresh(ctx, rec)
+}
+
+// List returns requests of an account sorted by creation time, most
+// recent first. The account must match exactly.
+func (s *PinService) List(
+	ctx context.Context,
+	acctID string,
+) ([]*psaRecord, error) {
+	return s.list(ctx, func(rec *psaRecord) bool {
+		return rec.AccountID == acctID
+	})
+}
+
+// ListAll returns requests of all accounts sorted by creation time, most
+// recent first.
+func (s *PinService) ListAll(ctx context.Context) ([]*psaRecord, error) {
+	return s.list(ctx, func(*psaRecord) bool {
+		return true
+	})
+}
+
+func (s *PinService) list(
+	ctx context.Context,
+	match func(rec *psaRecord) bool,
+) ([]*psaRecord, error) {
+	res, err := s.ds.Query(ctx, query.Query{
+		Prefix: psaPrefix.String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var recs []*psaRecord
+	for e := range res.Next() {
+		if e.Error != nil {
+			return nil, e.Error
+		}
+
+		var rec psaRecord
+		if err := json.Unmarshal(e.Value, &rec); err != nil {
+			return nil, err
+		}
+		if rec.Removed || !match(&rec) {
+			continue
+		}
+		if err := s.refresh(ctx, &rec); err != nil {
+			return nil, err
+		}
+		recs = append(recs, &rec)
+	}
+
+	sort.Slice(recs, func(i, j int) bool {
+		if recs[i].Created.Equal(recs[j].Created) {
+			return recs[i].RequestID > recs[j].RequestID
+		}
+		return recs[i].Created.After(recs[j].Created)
+	})
+
+	return recs, nil
+}
+
+// Remove deletes the request and unpins its CID if pinned. It returns
+// true if the CID is unpinned. A running job is canceled and the record
+// is kept as a tombstone until the job finishes.
+func (s *PinService) Remove(ctx context.Context, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, err := s.load(ctx, id)
+	if err != nil {
+		return false, err
+	}
+	if rec.Removed {
+		return false, ErrPSANotFound
+	}
+
+	if !rec.finished() {
+		j, err := s.jobs.Get(ctx, id)
+		if err != nil && !errors.Is(err, ErrPinJobNotFound) {
+			return false, err
+		}
+		if err == nil && !j.finished() {
+			if _, err := s.jobs.Cancel(ctx, id); err != nil &&
+				!errors.Is(err, ErrPinJobFinished) {
+				return false, err
+			}
+			// Canceling a queued job finishes it immediately.
+			if j, err = s.jobs.Get(ctx, id); err != nil {
+				return false, err
+			}
+		}
+		if err == nil && !j.finished() {
+			rec.Removed = true
+			return false, s.put(ctx, rec)
+		}
+		if err == nil {
+			rec.Status, rec.Message = psaStatus(j)
+		}
+	}
+
+	unpinned := false
+	if rec.Status == PSAPinned {
+		if err := s.unpin(ctx, rec); err != nil {
+			return false, err
+		}
+		if c, err := cid.Decode(rec.Pin.Cid); err == nil {
+			removePinMeta(
+				ctx,
+				com.GetRcPinner(s.pinner),
+				c,
+				true,
+				rec.AccountID,
+			)
+			if s.jobs != nil {
+				consumePinExpiry(ctx, s.jobs.ttl, c, true, rec.AccountID)
+			}
+		}
+		unpinned = true
+	}
+
+	return unpinned, s.ds.Delete(ctx, psaKey(id))
+}
+
+// onJobFinish records the final status of a request. The pin is reverted
+// if the request has been removed while the job was running.
+func (s *PinService) onJobFinish(ctx context.Context, j *PinJob) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, err := s.load(ctx, j.ID)
+	if err != nil {
+		if !errors.Is(err, ErrPSANotFound) {
+			log.Error("Error loading pin request", "id", j.ID, "error", err)
+		}
+		// Not a pinning service job.
+		return true
+	}
+
+	rec.Status, rec.Message = psaStatus(j)
+	if !rec.Removed {
+		if err := s.put(ctx, rec); err != nil {
+			log.Error("Error updating pin request", "id", j.ID, "error", err)
+		}
+		return true
+	}
+
+	if rec.Status == PSAPinned {
+		if err := s.unpin(ctx, rec); err != nil {
+			log.Error("Error reverting pin request",
+				"id", j.ID,
+				"error", err,
+			)
+		}
+	}
+	if err := s.ds.Delete(ctx, psaKey(j.ID)); err != nil {
+		log.Error("Error deleting pin request", "id", j.ID, "error", err)
+	}
+	return false
+}
+
+// refresh updates status of an unfinished request from its pin job.
+func (s *PinService) refresh(ctx context.Context, rec *psaRecord) error {
+	if rec.finished() {
+		return nil
+	}
+
+	j, err := s.jobs.Get(ctx, rec.RequestID)
+	if err != nil {
+		if errors.Is(err, ErrPinJobNotFound) {
+			rec.Status = PSAFailed
+			rec.Message = "pin job expired"
+			return nil
+		}
+		return err
+	}
+
+	rec.Status, rec.Message = psaStatus(j)
+	return nil
+}
+
+func (s *PinService) unpin(ctx context.Context, rec *psaRecord) error {
+	c, err := cid.Decode(rec.Pin.Cid)
+	if err != nil {
+		return err
+	}
+	if err := s.pinner.Unpin(ctx, c, true); err != nil &&
+		!errors.Is(err, pin.ErrNotPinned) {
+		return err
+	}
+	return s.pinner.Flush(ctx)
+}
+
+func (s *PinService) load(ctx context.Context, id string) (*psaRecord, error) {
+	if id == "" {
+		return nil, ErrPSANotFound
+	}
+	data, err := s.ds.Get(ctx, psaKey(id))
+	if err != nil {
+		if errors.Is(err, datastore.ErrNotFound) {
+			return nil, ErrPSANotFound
+		}
+		return nil, err
+	}
+
+	var rec psaRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+func (s *PinService) put(ctx context.Context, rec *psaRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if err := s.ds.Put(ctx, psaKey(rec.RequestID), data); err != nil {
+		return err
+	}
+	return s.ds.Sync(ctx, psaKey(rec.RequestID))
+}
+
+func psaKey(id string) datastore.Key {
+	return psaPrefix.ChildString(id)
+}
+
+func psaStatus(j *PinJob) (PSAStatus, string) {
+	switch j.State {
+	case PinJobQueued:
+		return PSAQueued, ""
+	case PinJobRunning:
+		return PSAPinning, ""
+	case PinJobDone:
+		return PSAPinned, ""
+	default:
+		return PSAFailed, j.Message
+	}
+}
+
+// SetPinService enables the IPFS Pinning Service API.
+func (h *ExtendedHandlers) SetPinService(s *PinService) {
+	h.psa = s
+}
+
+// PinService serves the IPFS Pinning Service API. The handler is mounted
+// at <endpoint>/pins and <endpoint>/pins/ so the endpoint could be used
+// with `ipfs pin remote service add`.
+func (h *ExtendedHandlers) PinService() gohttp.HandlerFunc {
+	return gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		ctx, span := tracing.Span(r.Context(), "Handlers", "PinService")
+		defer span.End()
+		r = r.WithContext(ctx)
+
+		if h.psa == nil {
+			writePSAError(
+				w,
+				gohttp.StatusNotImplemented,
+				ErrPSAUnavailable,
+			)
+			return
+		}
+
+		args, err := parseSignedArgs(r)
+		if err != nil {
+			writePSAError(
+				w,
+				gohttp.StatusBadRequest,
+				fmt.Errorf("error parsing params: %w", err),
+			)
+			return
+		}
+
+		id := ""
+		if idx := strings.LastIndex(r.URL.Path, psaPinsPath); idx >= 0 {
+			id = strings.Trim(r.URL.Path[idx+len(psaPinsPath):], "/")
+		}
+
+		switch {
+		case id == "" && r.Method == gohttp.MethodGet:
+			h.psaList(w, r, args)
+		case id == "" && r.Method == gohttp.MethodPost:
+			h.psaAdd(w, r, args, "")
+		case id != "" && r.Method == gohttp.MethodGet:
+			h.psaGet(w, r, args, id)
+		case id != "" && r.Method == gohttp.MethodPost:
+			h.psaAdd(w, r, args, id)
+		case id != "" && r.Method == gohttp.MethodDelete:
+			h.psaRemove(w, r, args, id)
+		default:
+			writePSAError(
+				w,
+				gohttp.StatusMethodNotAllowed,
+				ErrPSAInvalidRequest,
+			)
+		}
+	})
+}
+
+// psaAdd adds a pin object. If replace is given, the request is replaced
+// by the new one after it is accepted.
+func (h *ExtendedHandlers) psaAdd(
+	w gohttp.ResponseWriter,
+	r *gohttp.Request,
+	args *http.Args,
+	replace string,
+) {
+	if replace != "" {
+		if _, err := h.psaLoad(r.Context(), args, replace); err != nil {
+			writePSAError(w, psaErrorCode(err), err)
+			return
+		}
+	}
+
+	var p PSAPin
+	if r.Body == nil {
+		writePSAError(w, gohttp.StatusBadRequest, ErrPSAInvalidRequest)
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		writePSAError(
+			w,
+			gohttp.StatusBadRequest,
+			fmt.Errorf("error decoding pin: %w", err),
+		)
+		return
+	}
+	c, err := cid.Decode(p.Cid)
+	if err != nil {
+		writePSAError(w, gohttp.StatusBadRequest, ErrInvalidCID)
+		return
+	}
+	if len(p.Name) > psaMaxNameLen {
+		writePSAError(w, gohttp.StatusBadRequest, ErrPSAInvalidName)
+		return
+	}
+
+	j := newPinJob(r, args, c, true, 0)
+	j.Meta = &com.PinMeta{
+		Name:      p.Name,
+		Owner:     j.AccountID,
+		RequestID: GetRequestIDFromCtx(r.Context()),
+		Tags:      p.Meta,
+	}
+	rec, err := h.psa.Add(r.Context(), &p, j)
+	if err != nil {
+		writePSAError(
+			w,
+			gohttp.StatusInternalServerError,
+			fmt.Errorf("error adding pin: %w", err),
+		)
+		return
+	}
+
+	if replace != "" {
+		if _, err := h.psaRemoveStats(r.Context(), replace); err != nil {
+			log.Error("Error removing replaced pin request",
+				"id", replace,
+				"error", err,
+			)
+		}
+	}
+
+	h.connectOrigins(p.Origins)
+	writeJSON(w, gohttp.StatusAccepted, h.psaPinStatus(rec))
+}
+
+func (h *ExtendedHandlers) psaGet(
+	w gohttp.ResponseWriter,
+	r *gohttp.Request,
+	args *http.Args,
+	id string,
+) {
+	rec, err := h.psaLoad(r.Context(), args, id)
+	if err != nil {
+		writePSAError(w, psaErrorCode(err), err)
+		return
+	}
+
+	writeJSON(w, gohttp.StatusOK, h.psaPinStatus(rec))
+}
+
+func (h *ExtendedHandlers) psaRemove(
+	w gohttp.ResponseWriter,
+	r *gohttp.Request,
+	args *http.Args,
+	id string,
+) {
+	if _, err := h.psaLoad(r.Context(), args, id); err != nil {
+		writePSAError(w, psaErrorCode(err), err)
+		return
+	}
+
+	if _, err := h.psaRemoveStats(r.Context(), id); err != nil {
+		writePSAError(w, psaErrorCode(err), err)
+		return
+	}
+
+	w.WriteHeader(gohttp.StatusAccepted)
+}
+
+// psaRemoveStats removes the request and accounts the unpinned DAG in
+// usage stats and account quota like pin rm does.
+func (h *ExtendedHandlers) psaRemoveStats(
+	ctx context.Context,
+	id string,
+) (bool, error) {
+	rec, err := h.psa.load(ctx, id)
+	if err != nil {
+		return false, err
+	}
+
+	unpinned, err := h.psa.Remove(ctx, id)
+	if err != nil || !unpinned {
+		return unpinned, err
+	}
+
+	c, err := cid.Decode(rec.Pin.Cid)
+	if err != nil {
+		return unpinned, nil
+	}
+	ds := NewDagStats()
+	if err := h.dagCache.Calculate(ctx, h.api, c, true, ds); err != nil {
+		// Ignore stats error.
+		log.Error("Error calculating dag stats",
+			"error", err,
+			"cid", c.String(),
+			"source", "pinning service",
+		)
+	}
+	if aggrDs := getDagStatsFromCtx(ctx); aggrDs != nil {
+		aggrDs.Sub(ds)
+	}
+	h.quotas.record(ctx, rec.AccountID, ds, true)
+	return unpinned, nil
+}
+
+func (h *ExtendedHandlers) psaList(
+	w gohttp.ResponseWriter,
+	r *gohttp.Request,
+	args *http.Args,
+) {
+	f, err := parsePSAFilter(r)
+	if err != nil {
+		writePSAError(w, gohttp.StatusBadRequest, err)
+		return
+	}
+
+	var recs []*psaRecord
+	if acctID, all := accountScope(args); all {
+		recs, err = h.psa.ListAll(r.Context())
+	} else {
+		recs, err = h.psa.List(r.Context(), acctID)
+	}
+	if err != nil {
+		writePSAError(
+			w,
+			gohttp.StatusInternalServerError,
+			fmt.Errorf("error listing pins: %w", err),
+		)
+		return
+	}
+
+	res := &PSAPinResults{
+		Results: []*PSAPinStatus{},
+	}
+	for _, rec := range recs {
+		if !f.match(rec) {
+			continue
+		}
+		res.Count++
+		if len(res.Results) < f.limit {
+			res.Results = append(res.Results, h.psaPinStatus(rec))
+		}
+	}
+
+	writeJSON(w, gohttp.StatusOK, res)
+}
+
+// psaLoad returns the request if it belongs to the requesting account.
+func (h *ExtendedHandlers) psaLoad(
+	ctx context.Context,
+	args *http.Args,
+	id string,
+) (*psaRecord, error) {
+	rec, err := h.psa.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if acctID, all := accountScope(args); !all && rec.AccountID != acctID {
+		return nil, ErrPSANotFound
+	}
+	return rec, nil
+}
+
+func (h *ExtendedHandlers) psaPinStatus(rec *psaRecord) *PSAPinStatus {
+	st := &PSAPinStatus{
+		RequestID: rec.RequestID,
+		Status:    rec.Status,
+		Created:   rec.Created,
+		Pin:       rec.Pin,
+		Delegates: h.delegates(),
+	}
+	if rec.Message != "" {
+		st.Info = map[string]string{
+			"status_details": rec.Message,
+		}
+	}
+	return st
+}
+
+// delegates returns addresses of this node for clients to connect to.
+func (h *ExtendedHandlers) delegates() []string {
+	res := []string{}
+	if h.nd == nil || h.nd.PeerHost == nil {
+		return res
+	}
+
+	pi := peer.AddrInfo{
+		ID:    h.nd.PeerHost.ID(),
+		Addrs: h.nd.PeerHost.Addrs(),
+	}
+	addrs, err := peer.AddrInfoToP2pAddrs(&pi)
+	if err != nil {
+		return res
+	}
+	for _, addr := range addrs {
+		res = append(res, addr.String())
+	}
+	return res
+}
+
+// connectOrigins connects to providers given by the client in background
+// to speed up fetching. Invalid origins are ignored.
+func (h *ExtendedHandlers) connectOrigins(origins []string) {
+	if len(origins) == 0 || h.nd == nil || h.nd.PeerHost == nil {
+		return
+	}
+
+	var addrs []ma.Multiaddr
+	for _, o := range origins {
+		addr, err := ma.NewMultiaddr(o)
+		if err != nil {
+			continue
+		}
+		addrs = append(addrs, addr)
+	}
+	pis, err := peer.AddrInfosFromP2pAddrs(addrs...)
+	if err != nil {
+		return
+	}
+
+	host := h.nd.PeerHost
+	for _, pi := range pis {
+		go func(pi peer.AddrInfo) {
+			ctx, cancel := context.WithTimeout(
+				context.Background(),
+				psaOriginConnectTimeout,
+			)
+			defer cancel()
+			if err := host.Connect(ctx, pi); err != nil {
+				log.Debug("Error connecting to pin origin",
+					"peer", pi.ID.String(),
+					"error", err,
+				)
+			}
+		}(pi)
+	}
+}
+
+type psaFilter struct {
+	cids   map[string]bool
+	name   string
+	strat  string
+	status map[PSAStatus]bool
+	before time.Time
+	after  time.Time
+	limit  int
+	meta   map[string]string
+}
+
+func parsePSAFilter(r *gohttp.Request) (*psaFilter, error) {
+	query := r.URL.Query()
+	f := &psaFilter{
+		name:  query.Get("name"),
+		strat: query.Get("match"),
+		limit: psaDefaultLimit,
+		status: map[PSAStatus]bool{
+			PSAPinned: true,
+		},
+	}
+
+	if str := query.Get("cid"); str != "" {
+		parts := strings.Split(str, ",")
+		if len(parts) > psaMaxCids {
+			return nil, ErrPSATooManyCids
+		}
+		f.cids = map[string]bool{}
+		for _, part := range parts {
+			c, err := cid.Decode(part)
+			if err != nil {
+				return nil, ErrInvalidCID
+			}
+			f.cids[c.String()] = true
+		}
+	}
+
+	if len(f.name) > psaMaxNameLen {
+		return nil, ErrPSAInvalidName
+	}
+	switch f.strat {
+	case "":
+		f.strat = psaMatchExact
+	case psaMatchExact, psaMatchIExact, psaMatchPartial, psaMatchIPartial:
+	default:
+		return nil, ErrPSAInvalidMatch
+	}
+
+	if str := query.Get("status"); str != "" {
+		f.status = map[PSAStatus]bool{}
+		for _, part := range strings.Split(str, ",") {
+			st := PSAStatus(part)
+			switch st {
+			case PSAQueued, PSAPinning, PSAPinned, PSAFailed:
+				f.status[st] = true
+			default:
+				return nil, ErrPSAInvalidStatus
+			}
+		}
+	}
+
+	for k, t := range map[string]*time.Time{
+		"before": &f.before,
+		"after":  &f.after,
+	} {
+		if str := query.Get(k); str != "" {
+			v, err := time.Parse(time.RFC3339, str)
+			if err != nil {
+				return nil, ErrPSAInvalidTime
+			}
+			*t = v
+		}
+	}
+
+	if str := query.Get("limit"); str != "" {
+		v, err := strconv.Atoi(str)
+		if err != nil || v < 1 || v > psaMaxLimit {
+			return nil, ErrPSAInvalidLimit
+		}
+		f.limit = v
+	}
+
+	if str := query.Get("meta"); str != "" {
+		if err := json.Unmarshal([]byte(str), &f.meta); err != nil {
+			return nil, ErrPSAInvalidMeta
+		}
+	}
+
+	return f, nil
+}
+
+func (f *psaFilter) match(rec *psaRecord) bool {
+	if f.cids != nil && !f.cids[rec.Pin.Cid] {
+		return false
+	}
+	if !f.status[rec.Status] {
+		return false
+	}
+	if !f.before.IsZero() && !rec.Created.Before(f.before) {
+		return false
+	}
+	if !f.after.IsZero() && !rec.Created.After(f.after) {
+		return false
+	}
+	if f.name != "" && !matchName(rec.Pin.Name, f.name, f.strat) {
+		return false
+	}
+	for k, v := range f.meta {
+		if rec.Pin.Meta[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func matchName(name string, pattern string, strat string) bool {
+	switch strat {
+	case psaMatchIExact:
+		return strings.EqualFold(name, pattern)
+	case psaMatchPartial:
+		return strings.Contains(name, pattern)
+	case psaMatchIPartial:
+		return strings.Contains(
+			strings.ToLower(name),
+			strings.ToLower(pattern),
+		)
+	default:
+		return name == pattern
+	}
+}
+
+func psaErrorCode(err error) int {
+	if errors.Is(err, ErrPSANotFound) {
+		return gohttp.StatusNotFound
+	}
+	return gohttp.StatusInternalServerError
+}
+
+// writePSAError writes the failure object defined by the Pinning Service
+// API with reason derived from the status code.
+func writePSAError(w gohttp.ResponseWriter, code int, err error) {
+	writeJSON(w, code, &PSAFailure{
+		Error: PSAError{
+			Reason: strings.ToUpper(strings.ReplaceAll(
+				gohttp.StatusText(code),
+				" ",
+				"_",
+			)),
+			Details: err.Error(),
+		},
+	})
+}
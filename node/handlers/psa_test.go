@@ -0,0 +1,296 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	gohttp "net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	bs "github.com/ipfs/boxo/blockservice"
+	"github.com/ipfs/boxo/blockstore"
+	"github.com/ipfs/boxo/exchange/offline"
+	"github.com/ipfs/boxo/ipld/merkledag"
+	"github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipfs/kubo/core"
+
+	"github.com/photon-storage/go-common/testing/require"
+	"github.com/photon-storage/go-gw3/common/http"
+	rcpinner "github.com/photon-storage/go-rc-pinner"
+
+	"github.com/photon-storage/falcon/node/com"
+)
+
+func TestPinService(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	bstore := blockstore.NewBlockstore(dstore)
+	bserv := bs.New(bstore, offline.Exchange(bstore))
+	dserv := merkledag.NewDAGService(bserv)
+	rcp, err := rcpinner.New(ctx, dstore, dserv)
+	require.NoError(t, err)
+	pinner := &com.WrappedPinner{
+		Pinner: rcp,
+	}
+
+	a := rndNode(t)
+	b := rndNode(t)
+	c := rndNode(t)
+	require.NoError(t, dserv.Add(ctx, a))
+	require.NoError(t, dserv.Add(ctx, b))
+	require.NoError(t, dserv.Add(ctx, c))
+
+	api := &mockAPI{
+		dag: &mockAPIDag{
+			DAGService: dserv,
+		},
+	}
+	reported := make(chan *PinJob, 8)
+	q := NewPinJobQueue(
+		dstore,
+		api,
+		func(_ context.Context, j *PinJob, _ bool, _ uint64) error {
+			reported <- j
+			return nil
+		},
+		1,
+		0,
+	)
+	block := make(chan struct{})
+	q.pin = func(ctx context.Context, k cid.Cid, recursive bool) error {
+		if k == c.Cid() {
+			// Block the worker until released and complete the pin
+			// even if canceled.
+			<-block
+			ctx = context.Background()
+		}
+		nd, err := dserv.Get(ctx, k)
+		if err != nil {
+			return err
+		}
+		return pinner.Pin(ctx, nd, recursive)
+	}
+	svc := NewPinService(dstore, q, pinner)
+	require.NoError(t, q.Start(ctx))
+
+	h := New(&core.IpfsNode{Pinning: pinner}, api, nil)
+	h.SetPinService(svc)
+
+	call := func(
+		method string,
+		path string,
+		acctID string,
+		params url.Values,
+		body interface{},
+	) *httptest.ResponseRecorder {
+		var rd io.Reader
+		if body != nil {
+			data, err := json.Marshal(body)
+			require.NoError(t, err)
+			rd = bytes.NewReader(data)
+		}
+		r := httptest.NewRequest(method, "/api/v0/psa"+path, rd)
+		if params == nil {
+			params = url.Values{}
+		}
+		params.Set(
+			http.ParamP3Args,
+			http.NewArgs().SetArg(http.ArgP3AcctID, acctID).Encode(),
+		)
+		r.URL.RawQuery = params.Encode()
+		w := httptest.NewRecorder()
+		h.PinService().ServeHTTP(w, r)
+		return w
+	}
+	decodeStatus := func(w *httptest.ResponseRecorder) *PSAPinStatus {
+		var st PSAPinStatus
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &st))
+		return &st
+	}
+	waitPinned := func(id string) {
+		for i := 0; i < 100; i++ {
+			w := call(gohttp.MethodGet, "/pins/"+id, "acct", nil, nil)
+			require.Equal(t, gohttp.StatusOK, w.Code)
+			if decodeStatus(w).Status == PSAPinned {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("pin request %v not pinned", id)
+	}
+	count := func(k cid.Cid) uint16 {
+		cnt, err := pinner.GetCount(ctx, k, true)
+		require.NoError(t, err)
+		return cnt
+	}
+
+	// Pin is added and usage is reported by pin job.
+	w := call(gohttp.MethodPost, "/pins", "acct", nil, &PSAPin{
+		Cid:  a.Cid().String(),
+		Name: "Photo.jpg",
+		Meta: map[string]string{"app": "album"},
+	})
+	require.Equal(t, gohttp.StatusAccepted, w.Code)
+	sa := decodeStatus(w)
+	require.Equal(t, a.Cid().String(), sa.Pin.Cid)
+	waitPinned(sa.RequestID)
+	require.Equal(t, uint16(1), count(a.Cid()))
+	require.Equal(t, sa.RequestID, (<-reported).ID)
+
+	// Invalid pin object.
+	w = call(gohttp.MethodPost, "/pins", "acct", nil, &PSAPin{Cid: "bad"})
+	require.Equal(t, gohttp.StatusBadRequest, w.Code)
+	var failure PSAFailure
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &failure))
+	require.Equal(t, "BAD_REQUEST", failure.Error.Reason)
+
+	// Listing filters.
+	cases := []struct {
+		name   string
+		params url.Values
+		acctID string
+		count  int
+	}{
+		{
+			name:   "default",
+			acctID: "acct",
+			count:  1,
+		},
+		{
+			name:   "other account",
+			acctID: "other",
+			count:  0,
+		},
+		{
+			name:  "no account",
+			count: 0,
+		},
+		{
+			name:   "cid",
+			params: url.Values{"cid": {a.Cid().String() + "," + b.Cid().String()}},
+			acctID: "acct",
+			count:  1,
+		},
+		{
+			name:   "cid not match",
+			params: url.Values{"cid": {b.Cid().String()}},
+			acctID: "acct",
+			count:  0,
+		},
+		{
+			name:   "name exact",
+			params: url.Values{"name": {"photo.jpg"}},
+			acctID: "acct",
+			count:  0,
+		},
+		{
+			name:   "name iexact",
+			params: url.Values{"name": {"photo.jpg"}, "match": {"iexact"}},
+			acctID: "acct",
+			count:  1,
+		},
+		{
+			name:   "name partial",
+			params: url.Values{"name": {"Photo"}, "match": {"partial"}},
+			acctID: "acct",
+			count:  1,
+		},
+		{
+			name:   "status",
+			params: url.Values{"status": {"queued,pinning,failed"}},
+			acctID: "acct",
+			count:  0,
+		},
+		{
+			name: "before",
+			params: url.Values{"before": {
+				time.Now().Add(-time.Hour).Format(time.RFC3339),
+			}},
+			acctID: "acct",
+			count:  0,
+		},
+		{
+			name:   "meta",
+			params: url.Values{"meta": {`{"app":"album"}`}},
+			acctID: "acct",
+			count:  1,
+		},
+		{
+			name:   "meta not match",
+			params: url.Values{"meta": {`{"app":"video"}`}},
+			acctID: "acct",
+			count:  0,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := call(gohttp.MethodGet, "/pins", tc.acctID, tc.params, nil)
+			require.Equal(t, gohttp.StatusOK, w.Code)
+			var res PSAPinResults
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+			require.Equal(t, tc.count, res.Count)
+			require.Equal(t, tc.count, len(res.Results))
+		})
+	}
+
+	w = call(gohttp.MethodGet, "/pins", "acct", url.Values{"limit": {"0"}}, nil)
+	require.Equal(t, gohttp.StatusBadRequest, w.Code)
+
+	// Request of other account is not found.
+	w = call(gohttp.MethodGet, "/pins/"+sa.RequestID, "other", nil, nil)
+	require.Equal(t, gohttp.StatusNotFound, w.Code)
+
+	// Replace unpins the old CID.
+	w = call(gohttp.MethodPost, "/pins/"+sa.RequestID, "acct", nil, &PSAPin{
+		Cid: b.Cid().String(),
+	})
+	require.Equal(t, gohttp.StatusAccepted, w.Code)
+	sb := decodeStatus(w)
+	require.NotEqual(t, sa.RequestID, sb.RequestID)
+	waitPinned(sb.RequestID)
+	require.Equal(t, uint16(0), count(a.Cid()))
+	require.Equal(t, uint16(1), count(b.Cid()))
+	w = call(gohttp.MethodGet, "/pins/"+sa.RequestID, "acct", nil, nil)
+	require.Equal(t, gohttp.StatusNotFound, w.Code)
+
+	// Remove unpins the CID.
+	w = call(gohttp.MethodDelete, "/pins/"+sb.RequestID, "acct", nil, nil)
+	require.Equal(t, gohttp.StatusAccepted, w.Code)
+	require.Equal(t, uint16(0), count(b.Cid()))
+	w = call(gohttp.MethodGet, "/pins/"+sb.RequestID, "acct", nil, nil)
+	require.Equal(t, gohttp.StatusNotFound, w.Code)
+
+	// Removing request with a running job reverts the pin once the job
+	// finishes.
+	w = call(gohttp.MethodPost, "/pins", "acct", nil, &PSAPin{
+		Cid: c.Cid().String(),
+	})
+	require.Equal(t, gohttp.StatusAccepted, w.Code)
+	sc := decodeStatus(w)
+	waitPinJob(t, q, sc.RequestID, PinJobRunning)
+	w = call(gohttp.MethodDelete, "/pins/"+sc.RequestID, "acct", nil, nil)
+	require.Equal(t, gohttp.StatusAccepted, w.Code)
+	w = call(gohttp.MethodGet, "/pins/"+sc.RequestID, "acct", nil, nil)
+	require.Equal(t, gohttp.StatusNotFound, w.Code)
+	// The pin completes regardless of cancellation.
+	close(block)
+	waitPinJob(t, q, sc.RequestID, PinJobDone)
+	for i := 0; i < 100; i++ {
+		if _, err := svc.load(ctx, sc.RequestID); errors.Is(err, ErrPSANotFound) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	_, err = svc.load(ctx, sc.RequestID)
+	require.ErrorIs(t, ErrPSANotFound, err)
+	require.Equal(t, uint16(0), count(c.Cid()))
+}
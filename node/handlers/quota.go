@@ -0,0 +1,389 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	gohttp "net/http"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/multiformats/go-multibase"
+	"go.uber.org/atomic"
+
+	"github.com/photon-storage/go-common/log"
+	"github.com/photon-storage/go-common/metrics"
+	rcpinner "github.com/photon-storage/go-rc-pinner"
+)
+
+const (
+	defaultQuotaCheckInterval = time.Second
+)
+
+var (
+	ErrQuotaExceeded = errors.New("account quota exceeded")
+
+	quotaPrefix = datastore.NewKey("/falcon/quota")
+)
+
+// QuotaLimit limits pinned bytes and pin count of an account. Zero means
+// unlimited.
+type QuotaLimit struct {
+	MaxBytes int64
+	MaxCount int64
+}
+
+// QuotaUsage is pinned bytes and pin count of an account.
+type QuotaUsage struct {
+	Bytes int64 `json:"bytes"`
+	Count int64 `json:"count"`
+}
+
+// Quotas tracks per-account usage from DAG stats deltas of pin and unpin
+// requests. Accounts are identified by the account ID signed in P3 args.
+// Requests without an account are not limited. Pins in flight reserve
+// count and fetched bytes so concurrent pins cannot overshoot the quota.
+type Quotas struct {
+	ds       datastore.Datastore
+	def      QuotaLimit
+	accounts map[string]QuotaLimit
+	interval time.Duration
+
+	mu       sync.Mutex
+	reserved map[string]*QuotaUsage
+}
+
+func NewQuotas(
+	ds datastore.Datastore,
+	def QuotaLimit,
+	accounts map[string]QuotaLimit,
+) *Quotas {
+	return &Quotas{
+		ds:       ds,
+		def:      def,
+		accounts: accounts,
+		interval: defaultQuotaCheckInterval,
+		reserved: map[string]*QuotaUsage{},
+	}
+}
+
+func RegisterQuotaMetrics() {
+	metrics.NewCounter("quota_exceeded_total")
+}
+
+// SetQuotas enables per-account quotas.
+func (h *ExtendedHandlers) SetQuotas(q *Quotas) {
+	h.quotas = q
+}
+
+// Limit returns the quota of an account.
+func (q *Quotas) Limit(acct string) QuotaLimit {
+	if l, ok := q.accounts[acct]; ok {
+		return l
+	}
+	return q.def
+}
+
+// Usage returns the current usage of an account.
+func (q *Quotas) Usage(ctx context.Context, acct string) (*QuotaUsage, error) {
+	data, err := q.ds.Get(ctx, quotaKey(acct))
+	if err == datastore.ErrNotFound {
+		return &QuotaUsage{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var u QuotaUsage
+	if err := json.Unmarshal(data, &u); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// Update applies usage deltas to an account. Usage never drops below
+// zero as pins made before quotas were enabled are not accounted.
+func (q *Quotas) Update(
+	ctx context.Context,
+	acct string,
+	count int64,
+	bytes int64,
+) (*QuotaUsage, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	u, err := q.Usage(ctx, acct)
+	if err != nil {
+		return nil, err
+	}
+	u.Count += count
+	if u.Count < 0 {
+		u.Count = 0
+	}
+	u.Bytes += bytes
+	if u.Bytes < 0 {
+		u.Bytes = 0
+	}
+
+	data, err := json.Marshal(u)
+	if err != nil {
+		return nil, err
+	}
+	if err := q.ds.Put(ctx, quotaKey(acct), data); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// Check returns ErrQuotaExceeded if the account cannot pin count more
+// CIDs. Otherwise count is reserved until the reservation is released.
+// The remaining bytes are returned, or -1 if unlimited. The reservation
+// is nil if the account is not limited.
+func (q *Quotas) Check(
+	ctx context.Context,
+	acct string,
+	count int64,
+) (*quotaReservation, int64, error) {
+	if q == nil || acct == "" {
+		return nil, -1, nil
+	}
+
+	l := q.Limit(acct)
+	if l.MaxBytes <= 0 && l.MaxCount <= 0 {
+		return nil, -1, nil
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	u, err := q.usageLocked(ctx, acct)
+	if err != nil {
+		return nil, 0, err
+	}
+	if l.MaxCount > 0 && u.Count+count > l.MaxCount {
+		metrics.CounterInc("quota_exceeded_total")
+		return nil, 0, ErrQuotaExceeded
+	}
+	if l.MaxBytes > 0 && u.Bytes >= l.MaxBytes {
+		metrics.CounterInc("quota_exceeded_total")
+		return nil, 0, ErrQuotaExceeded
+	}
+
+	res := &quotaReservation{
+		q:    q,
+		acct: acct,
+	}
+	if count > 0 {
+		res.count = count
+		q.reservedLocked(acct).Count += count
+	}
+	if l.MaxBytes <= 0 {
+		return res, -1, nil
+	}
+	return res, l.MaxBytes - u.Bytes, nil
+}
+
+// usageLocked returns usage of an account including reservations. q.mu
+// must be held.
+func (q *Quotas) usageLocked(
+	ctx context.Context,
+	acct string,
+) (*QuotaUsage, error) {
+	u, err := q.Usage(ctx, acct)
+	if err != nil {
+		return nil, err
+	}
+	if r, ok := q.reserved[acct]; ok {
+		u.Count += r.Count
+		u.Bytes += r.Bytes
+	}
+	return u, nil
+}
+
+// reservedLocked returns reservations of an account. q.mu must be held.
+func (q *Quotas) reservedLocked(acct string) *QuotaUsage {
+	r, ok := q.reserved[acct]
+	if !ok {
+		r = &QuotaUsage{}
+		q.reserved[acct] = r
+	}
+	return r
+}
+
+// quotaReservation holds count and bytes of a pin in flight. Usage
+// recorded before the reservation is released is counted twice for a
+// moment, which errs on rejecting.
+type quotaReservation struct {
+	q     *Quotas
+	acct  string
+	count int64
+	bytes int64
+}
+
+// reserve grows reserved bytes to size. ErrQuotaExceeded is returned if
+// usage with reservations of the account exceeds the bytes quota.
+func (r *quotaReservation) reserve(ctx context.Context, size int64) error {
+	if r == nil {
+		return nil
+	}
+
+	r.q.mu.Lock()
+	defer r.q.mu.Unlock()
+
+	if size > r.bytes {
+		r.q.reservedLocked(r.acct).Bytes += size - r.bytes
+		r.bytes = size
+	}
+	l := r.q.Limit(r.acct)
+	if l.MaxBytes <= 0 {
+		return nil
+	}
+	u, err := r.q.usageLocked(ctx, r.acct)
+	if err != nil {
+		return err
+	}
+	if u.Bytes > l.MaxBytes {
+		metrics.CounterInc("quota_exceeded_total")
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// release drops the reservation. It is safe to call more than once.
+func (r *quotaReservation) release() {
+	if r == nil {
+		return
+	}
+
+	r.q.mu.Lock()
+	defer r.q.mu.Unlock()
+
+	if res, ok := r.q.reserved[r.acct]; ok {
+		res.Count -= r.count
+		res.Bytes -= r.bytes
+		if res.Count <= 0 && res.Bytes <= 0 {
+			delete(r.q.reserved, r.acct)
+		}
+	}
+	r.count = 0
+	r.bytes = 0
+}
+
+// record applies DAG stats of a pin, or an unpin if remove is set, to the
+// account usage. Errors are logged as the pin has been done.
+func (q *Quotas) record(
+	ctx context.Context,
+	acct string,
+	ds *DagStats,
+	remove bool,
+) {
+	if q == nil || acct == "" {
+		return
+	}
+
+	count := ds.TotalCount.Load()
+	bytes := ds.TotalSize.Load()
+	if remove {
+		count, bytes = -count, -bytes
+	}
+	if _, err := q.Update(ctx, acct, count, bytes); err != nil {
+		log.Error("Error updating account quota usage",
+			"account", acct,
+			"error", err,
+		)
+	}
+}
+
+// enforce checks the account can pin count more CIDs. The returned context
+// is canceled once the DAG size fetched exceeds the remaining bytes of the
+// account, in which case the guard reports exceeded. Bytes fetched are
+// reserved as the pin goes. The guard must be stopped when the pin
+// finishes, which releases the reservation.
+func (q *Quotas) enforce(
+	ctx context.Context,
+	acct string,
+	count int64,
+) (context.Context, *quotaGuard, error) {
+	res, remaining, err := q.Check(ctx, acct, count)
+	if err != nil {
+		return ctx, nil, err
+	}
+	if res == nil {
+		return ctx, nil, nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	g := &quotaGuard{
+		exceeded: atomic.NewBool(false),
+		cancel:   cancel,
+		res:      res,
+	}
+	if remaining < 0 {
+		return ctx, g, nil
+	}
+
+	// Reuse the p2p ingress counter set by auth if any.
+	size := rcpinner.DagSize(ctx)
+	if size == nil {
+		size = atomic.NewUint64(0)
+		ctx = rcpinner.WithDagSize(ctx, size)
+	}
+	base := size.Load()
+
+	go func() {
+		ticker := time.NewTicker(q.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+
+			case <-ticker.C:
+				err := res.reserve(ctx, int64(size.Load()-base))
+				if errors.Is(err, ErrQuotaExceeded) {
+					g.exceeded.Store(true)
+					cancel()
+					return
+				}
+				if err != nil && ctx.Err() == nil {
+					log.Error("Error reserving account quota",
+						"account", acct,
+						"error", err,
+					)
+				}
+			}
+		}
+	}()
+	return ctx, g, nil
+}
+
+// quotaGuard aborts a pin exceeding the account quota.
+type quotaGuard struct {
+	exceeded *atomic.Bool
+	cancel   context.CancelFunc
+	res      *quotaReservation
+}
+
+func (g *quotaGuard) stop() {
+	if g != nil {
+		g.cancel()
+		g.res.release()
+	}
+}
+
+func (g *quotaGuard) isExceeded() bool {
+	return g != nil && g.exceeded.Load()
+}
+
+func quotaErrorCode(err error) int {
+	if errors.Is(err, ErrQuotaExceeded) {
+		return gohttp.StatusInsufficientStorage
+	}
+	return gohttp.StatusInternalServerError
+}
+
+func quotaKey(acct string) datastore.Key {
+	k, _ := multibase.Encode(multibase.Base64url, []byte(acct))
+	return quotaPrefix.ChildString(k)
+}
@@ -0,0 +1,266 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	gohttp "net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	bs "github.com/ipfs/boxo/blockservice"
+	"github.com/ipfs/boxo/blockstore"
+	"github.com/ipfs/boxo/exchange/offline"
+	"github.com/ipfs/boxo/ipld/merkledag"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipfs/kubo/core"
+	"go.uber.org/atomic"
+
+	"github.com/photon-storage/go-common/testing/require"
+	"github.com/photon-storage/go-gw3/common/http"
+	rcpinner "github.com/photon-storage/go-rc-pinner"
+
+	"github.com/photon-storage/falcon/node/com"
+)
+
+func TestQuotas(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	q := NewQuotas(
+		dssync.MutexWrap(ds.NewMapDatastore()),
+		QuotaLimit{MaxBytes: 100, MaxCount: 2},
+		map[string]QuotaLimit{
+			"unlimited": QuotaLimit{},
+		},
+	)
+	q.interval = 10 * time.Millisecond
+
+	check := func(acct string, count int64) (int64, error) {
+		res, remaining, err := q.Check(ctx, acct, count)
+		res.release()
+		return remaining, err
+	}
+	update := func(acct string, count int64, bytes int64) *QuotaUsage {
+		u, err := q.Update(ctx, acct, count, bytes)
+		require.NoError(t, err)
+		return u
+	}
+
+	// No account or unlimited account.
+	remaining, err := check("", 10)
+	require.NoError(t, err)
+	require.Equal(t, int64(-1), remaining)
+	remaining, err = check("unlimited", 10)
+	require.NoError(t, err)
+	require.Equal(t, int64(-1), remaining)
+
+	remaining, err = check("acct", 1)
+	require.NoError(t, err)
+	require.Equal(t, int64(100), remaining)
+	_, err = check("acct", 3)
+	require.ErrorIs(t, ErrQuotaExceeded, err)
+
+	update("acct", 1, 60)
+	remaining, err = check("acct", 1)
+	require.NoError(t, err)
+	require.Equal(t, int64(40), remaining)
+
+	// Count exhausted.
+	update("acct", 1, 10)
+	_, err = check("acct", 1)
+	require.ErrorIs(t, ErrQuotaExceeded, err)
+
+	// Bytes exhausted.
+	update("acct", -1, 30)
+	_, err = check("acct", 1)
+	require.ErrorIs(t, ErrQuotaExceeded, err)
+
+	// Usage does not drop below zero.
+	u := update("acct", -5, -500)
+	require.Equal(t, int64(0), u.Count)
+	require.Equal(t, int64(0), u.Bytes)
+
+	// Concurrent pins are limited by reservations until released.
+	res0, _, err := q.Check(ctx, "acct", 1)
+	require.NoError(t, err)
+	require.NoError(t, res0.reserve(ctx, 70))
+	res1, remaining, err := q.Check(ctx, "acct", 1)
+	require.NoError(t, err)
+	require.Equal(t, int64(30), remaining)
+	_, err = check("acct", 1)
+	require.ErrorIs(t, ErrQuotaExceeded, err)
+	require.ErrorIs(t, ErrQuotaExceeded, res1.reserve(ctx, 31))
+	res0.release()
+	res0.release()
+	res1.release()
+	remaining, err = check("acct", 2)
+	require.NoError(t, err)
+	require.Equal(t, int64(100), remaining)
+
+	// Fetch is aborted once the DAG size exceeds remaining bytes.
+	update("acct", 1, 60)
+	size := atomic.NewUint64(1000)
+	gctx, guard, err := q.enforce(
+		rcpinner.WithDagSize(ctx, size),
+		"acct",
+		1,
+	)
+	require.NoError(t, err)
+	defer guard.stop()
+	size.Add(40)
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, gctx.Err())
+	require.False(t, guard.isExceeded())
+	size.Add(1)
+	select {
+	case <-gctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("fetch is not aborted")
+	}
+	require.True(t, guard.isExceeded())
+
+	// Unlimited accounts are not guarded.
+	_, guard, err = q.enforce(ctx, "unlimited", 1)
+	require.NoError(t, err)
+	require.Nil(t, guard)
+	require.False(t, guard.isExceeded())
+	guard.stop()
+}
+
+func TestPinBatchQuota(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	bstore := blockstore.NewBlockstore(dstore)
+	bserv := bs.New(bstore, offline.Exchange(bstore))
+	dserv := merkledag.NewDAGService(bserv)
+	rcp, err := rcpinner.New(ctx, dstore, dserv)
+	require.NoError(t, err)
+	pinner := &com.WrappedPinner{
+		Pinner:    rcp,
+		Datastore: dstore,
+	}
+
+	// A{B}
+	a := rndNode(t)
+	b := rndNode(t)
+	require.NoError(t, a.AddNodeLink("c0", b))
+	require.NoError(t, dserv.Add(ctx, a))
+	require.NoError(t, dserv.Add(ctx, b))
+
+	h := New(
+		&core.IpfsNode{
+			Pinning: pinner,
+		},
+		&mockAPI{
+			dag: &mockAPIDag{
+				DAGService: dserv,
+			},
+			pin: &mockAPIPin{
+				dag:    dserv,
+				pinner: pinner,
+			},
+		},
+		nil,
+	)
+	quotas := NewQuotas(dstore, QuotaLimit{MaxCount: 1}, nil)
+	h.SetQuotas(quotas)
+
+	call := func(
+		handler gohttp.Handler,
+		uri string,
+		acct string,
+		items ...*PinBatchItem,
+	) (int, *PinBatchResult) {
+		var buf bytes.Buffer
+		require.NoError(t, json.NewEncoder(&buf).Encode(&PinBatchRequest{
+			Pins: items,
+		}))
+		query := url.Values{}
+		query.Set(
+			http.ParamP3Args,
+			http.NewArgs().SetArg(http.ArgP3AcctID, acct).Encode(),
+		)
+		r := httptest.NewRequest(
+			gohttp.MethodPost,
+			uri+"?"+query.Encode(),
+			&buf,
+		)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		var res PinBatchResult
+		decodeResp(t, w, &res)
+		return w.Code, &res
+	}
+	usage := func() *QuotaUsage {
+		u, err := quotas.Usage(ctx, "acct")
+		require.NoError(t, err)
+		return u
+	}
+
+	code, res := call(
+		h.PinAddBatch(),
+		"/api/v0/pin/add/batch",
+		"acct",
+		&PinBatchItem{Cid: a.Cid().String(), Recursive: true},
+		&PinBatchItem{Cid: b.Cid().String(), Recursive: true},
+	)
+	require.Equal(t, gohttp.StatusInsufficientStorage, code)
+	require.False(t, res.Success)
+	require.Equal(t, int64(0), usage().Count)
+
+	code, res = call(
+		h.PinAddBatch(),
+		"/api/v0/pin/add/batch",
+		"acct",
+		&PinBatchItem{Cid: a.Cid().String(), Recursive: true},
+	)
+	require.Equal(t, gohttp.StatusOK, code)
+	require.True(t, res.Success)
+	require.Equal(t, int64(1), usage().Count)
+	require.Equal(t, totalSize(a, b), usage().Bytes)
+
+	code, _ = call(
+		h.PinAddBatch(),
+		"/api/v0/pin/add/batch",
+		"acct",
+		&PinBatchItem{Cid: b.Cid().String(), Recursive: true},
+	)
+	require.Equal(t, gohttp.StatusInsufficientStorage, code)
+
+	// An unpin of a CID not pinned by the account is not credited.
+	code, res = call(
+		h.PinAddBatch(),
+		"/api/v0/pin/add/batch",
+		"other",
+		&PinBatchItem{Cid: b.Cid().String(), Recursive: true},
+	)
+	require.Equal(t, gohttp.StatusOK, code)
+	require.True(t, res.Success)
+	code, res = call(
+		h.PinRmBatch(),
+		"/api/v0/pin/rm/batch",
+		"acct",
+		&PinBatchItem{Cid: b.Cid().String(), Recursive: true},
+	)
+	require.Equal(t, gohttp.StatusOK, code)
+	require.True(t, res.Success)
+	require.Equal(t, int64(1), usage().Count)
+	require.Equal(t, totalSize(a, b), usage().Bytes)
+
+	code, res = call(
+		h.PinRmBatch(),
+		"/api/v0/pin/rm/batch",
+		"acct",
+		&PinBatchItem{Cid: a.Cid().String(), Recursive: true},
+	)
+	require.Equal(t, gohttp.StatusOK, code)
+	require.True(t, res.Success)
+	require.Equal(t, int64(0), usage().Count)
+	require.Equal(t, int64(0), usage().Bytes)
+}
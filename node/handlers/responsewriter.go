@@ -6,13 +6,28 @@ import (
 	gohttp "net/http"
 )
 
-var _ gohttp.ResponseWriter = (*responseWriter)(nil)
+var (
+	_ gohttp.ResponseWriter = (*responseWriter)(nil)
+	_ gohttp.Flusher        = (*responseWriter)(nil)
+)
 
 type responseHandler interface {
 	status(statusCode int)
 	update(context.Context, []byte) ([]byte, error)
 }
 
+// headerHandler is optionally implemented by a responseHandler to modify
+// response headers before they are sent.
+type headerHandler interface {
+	header(gohttp.Header)
+}
+
+// statusOverrider is optionally implemented by a responseHandler to
+// replace the status code sent. Zero keeps the default.
+type statusOverrider interface {
+	overrideStatus() int
+}
+
 // responseWriter intercepts response written by upstream handler.
 // number of bytes written to it.
 type responseWriter struct {
@@ -43,7 +58,8 @@ func (w *responseWriter) Write(data []byte) (int, error) {
 		if w.h != nil {
 			w.h.status(gohttp.StatusOK)
 		}
-		w.w.WriteHeader(gohttp.StatusOK)
+		w.setHeader()
+		w.w.WriteHeader(w.statusCode())
 		w.headerWritten = true
 	}
 
@@ -69,6 +85,40 @@ func (w *responseWriter) WriteHeader(statusCode int) {
 	if w.h != nil {
 		w.h.status(statusCode)
 	}
-	w.w.WriteHeader(gohttp.StatusOK)
+	w.setHeader()
+	w.w.WriteHeader(w.statusCode())
 	w.headerWritten = true
 }
+
+// Flush sends the header if not yet and flushes data written for
+// streaming responses.
+func (w *responseWriter) Flush() {
+	f, ok := w.w.(http.Flusher)
+	if !ok {
+		return
+	}
+	if !w.headerWritten {
+		if w.h != nil {
+			w.h.status(gohttp.StatusOK)
+		}
+		w.setHeader()
+		w.w.WriteHeader(w.statusCode())
+		w.headerWritten = true
+	}
+	f.Flush()
+}
+
+func (w *responseWriter) statusCode() int {
+	if so, ok := w.h.(statusOverrider); ok {
+		if code := so.overrideStatus(); code != 0 {
+			return code
+		}
+	}
+	return gohttp.StatusOK
+}
+
+func (w *responseWriter) setHeader() {
+	if hh, ok := w.h.(headerHandler); ok {
+		hh.header(w.w.Header())
+	}
+}
@@ -54,6 +54,17 @@ func TestResponseWriter(t *testing.T) {
 				require.DeepEqual(t, data, w.Body.Bytes())
 			},
 		},
+		{
+			name: "flush",
+			run: func(t *testing.T) {
+				w := httptest.NewRecorder()
+				rw := newResponseWriter(ctx, w, &mockHandler{})
+
+				rw.Flush()
+				require.True(t, w.Flushed)
+				require.Equal(t, http.StatusOK, w.Code)
+			},
+		},
 	}
 
 	for _, c := range cases {
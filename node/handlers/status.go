@@ -2,24 +2,46 @@ package handlers
 
 import (
 	gohttp "net/http"
+	"strconv"
+
+	"go.opentelemetry.io/otel/attribute"
 
 	"github.com/photon-storage/falcon/node/config"
+	"github.com/photon-storage/falcon/node/tracing"
 )
 
 type StatusResult struct {
-	Status    string `json:"status"`
-	PublicKey string `json:"public_key"`
+	Status    string               `json:"status"`
+	PublicKey string               `json:"public_key"`
+	Checks    []*HealthCheckResult `json:"checks,omitempty"`
 }
 
+// Status runs registered health checks. It responds 503 if any critical
+// check fails. Individual check results are included with ?verbose=1.
 func (h *ExtendedHandlers) Status() gohttp.HandlerFunc {
 	return gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
-		writeJSON(
-			w,
-			gohttp.StatusOK,
-			&StatusResult{
-				Status:    "ok",
-				PublicKey: config.Get().PublicKeyBase64,
-			},
+		ctx, span := tracing.Span(r.Context(), "Handlers", "Status")
+		defer span.End()
+
+		status, checks := h.runHealthChecks(
+			ctx,
+			config.Get().Health.CheckTimeout,
 		)
+		span.SetAttributes(attribute.String("status", status))
+
+		code := gohttp.StatusOK
+		if status == HealthError {
+			code = gohttp.StatusServiceUnavailable
+		}
+
+		res := &StatusResult{
+			Status:    status,
+			PublicKey: config.Get().PublicKeyBase64,
+		}
+		if verbose, _ := strconv.ParseBool(r.URL.Query().Get("verbose")); verbose {
+			res.Checks = checks
+		}
+
+		writeJSON(w, code, res)
 	})
 }
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	gohttp "net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/photon-storage/go-common/testing/require"
+
+	"github.com/photon-storage/falcon/node/config"
+)
+
+func TestStatus(t *testing.T) {
+	config.Mock(&config.Config{
+		PublicKeyBase64: "pk",
+	})
+
+	errCheck := errors.New("check failed")
+	ok := func(context.Context) error { return nil }
+	fail := func(context.Context) error { return errCheck }
+	release := make(chan struct{})
+	defer close(release)
+	hang := func(context.Context) error {
+		<-release
+		return nil
+	}
+
+	cases := []struct {
+		name   string
+		checks []*HealthCheck
+		query  string
+		code   int
+		status string
+		ok     []bool
+	}{
+		{
+			name:   "no checks",
+			code:   gohttp.StatusOK,
+			status: HealthOk,
+		},
+		{
+			name: "all ok",
+			checks: []*HealthCheck{
+				{Name: "a", Critical: true, Run: ok},
+				{Name: "b", Run: ok},
+			},
+			query:  "?verbose=1",
+			code:   gohttp.StatusOK,
+			status: HealthOk,
+			ok:     []bool{true, true},
+		},
+		{
+			name: "non-critical failure",
+			checks: []*HealthCheck{
+				{Name: "a", Critical: true, Run: ok},
+				{Name: "b", Run: fail},
+			},
+			query:  "?verbose=1",
+			code:   gohttp.StatusOK,
+			status: HealthDegraded,
+			ok:     []bool{true, false},
+		},
+		{
+			name: "critical failure",
+			checks: []*HealthCheck{
+				{Name: "a", Critical: true, Run: fail},
+				{Name: "b", Run: fail},
+			},
+			code:   gohttp.StatusServiceUnavailable,
+			status: HealthError,
+		},
+		{
+			name: "critical timeout",
+			checks: []*HealthCheck{
+				{Name: "a", Run: ok},
+				{Name: "b", Critical: true, Run: hang},
+			},
+			query:  "?verbose=true",
+			code:   gohttp.StatusServiceUnavailable,
+			status: HealthError,
+			ok:     []bool{true, false},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cfg := config.Get()
+			cfg.Health.CheckTimeout = 100 * time.Millisecond
+			h := New(nil, nil, nil)
+			for _, chk := range c.checks {
+				h.RegisterHealthCheck(chk)
+			}
+
+			w := httptest.NewRecorder()
+			h.Status().ServeHTTP(
+				w,
+				httptest.NewRequest(gohttp.MethodGet, "/status"+c.query, nil),
+			)
+			require.Equal(t, c.code, w.Code)
+
+			var res StatusResult
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+			require.Equal(t, c.status, res.Status)
+			require.Equal(t, "pk", res.PublicKey)
+			require.Equal(t, len(c.ok), len(res.Checks))
+			for i, ok := range c.ok {
+				require.Equal(t, c.checks[i].Name, res.Checks[i].Name)
+				require.Equal(t, ok, res.Checks[i].Ok)
+			}
+		})
+	}
+}
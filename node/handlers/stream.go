@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime"
+	gohttp "net/http"
+	"strings"
+
+	rcpinner "github.com/photon-storage/go-rc-pinner"
+)
+
+type streamFormat int
+
+const (
+	// Concatenated JSON objects without separators, kept for
+	// compatibility with existing clients.
+	streamLegacy streamFormat = iota
+	// Newline delimited JSON.
+	streamNDJSON
+	// Server-Sent Events.
+	streamSSE
+
+	mediaTypeNDJSON      = "application/x-ndjson"
+	mediaTypeEventStream = "text/event-stream"
+
+	EventProgress = "progress"
+	EventDone     = "done"
+	EventError    = "error"
+)
+
+// StreamEvent is a typed event emitted by streaming pin operations.
+// Result carries the operation result on done events.
+type StreamEvent struct {
+	Type          string      `json:"type"`
+	FetchedBlocks int         `json:"fetched_blocks"`
+	FetchedBytes  uint64      `json:"fetched_bytes"`
+	Result        interface{} `json:"result,omitempty"`
+	Message       string      `json:"message,omitempty"`
+}
+
+// apiError is the error object streamed by Kubo commands.
+type apiError struct {
+	Message string `json:"Message"`
+	Type    string `json:"Type"`
+}
+
+func decodeAPIError(data []byte) (string, bool) {
+	var v apiError
+	if err := json.Unmarshal(data, &v); err != nil || v.Type != "error" {
+		return "", false
+	}
+	return v.Message, true
+}
+
+// parseStreamFormat picks the stream format from the Accept header.
+func parseStreamFormat(r *gohttp.Request) streamFormat {
+	for _, v := range r.Header.Values("Accept") {
+		for _, part := range strings.Split(v, ",") {
+			mt, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+			if err != nil {
+				continue
+			}
+			switch mt {
+			case mediaTypeNDJSON:
+				return streamNDJSON
+			case mediaTypeEventStream:
+				return streamSSE
+			}
+		}
+	}
+	return streamLegacy
+}
+
+func (f streamFormat) contentType() string {
+	switch f {
+	case streamNDJSON:
+		return mediaTypeNDJSON
+	case streamSSE:
+		return mediaTypeEventStream
+	default:
+		return ""
+	}
+}
+
+// setStreamHeaders sets response headers for the stream format. Legacy
+// format leaves headers untouched.
+func setStreamHeaders(h gohttp.Header, f streamFormat) {
+	if f == streamLegacy {
+		return
+	}
+	h.Set("Content-Type", f.contentType())
+	h.Set("Cache-Control", "no-cache")
+	// Disable proxy buffering so events are delivered in time.
+	h.Set("X-Accel-Buffering", "no")
+	h.Del("Content-Length")
+}
+
+func encodeEvent(f streamFormat, ev *StreamEvent) ([]byte, error) {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return nil, err
+	}
+
+	switch f {
+	case streamSSE:
+		return []byte(fmt.Sprintf("event: %s\ndata: %s\n\n", ev.Type, data)), nil
+	default:
+		return append(data, '\n'), nil
+	}
+}
+
+// newStreamEvent creates an event with bytes fetched so far by the pinner.
+func newStreamEvent(
+	ctx context.Context,
+	typ string,
+	blocks int,
+) *StreamEvent {
+	ev := &StreamEvent{
+		Type:          typ,
+		FetchedBlocks: blocks,
+	}
+	if sz := rcpinner.DagSize(ctx); sz != nil {
+		ev.FetchedBytes = sz.Load()
+	}
+	return ev
+}
+
+// writeEvent encodes and writes a stream event, flushing if possible.
+func writeEvent(w gohttp.ResponseWriter, f streamFormat, ev *StreamEvent) error {
+	data, err := encodeEvent(f, ev)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if fl, ok := w.(gohttp.Flusher); ok {
+		fl.Flush()
+	}
+	return nil
+}
@@ -0,0 +1,278 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	gohttp "net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	bs "github.com/ipfs/boxo/blockservice"
+	"github.com/ipfs/boxo/blockstore"
+	"github.com/ipfs/boxo/exchange/offline"
+	"github.com/ipfs/boxo/ipld/merkledag"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipfs/kubo/core"
+	"go.uber.org/atomic"
+
+	"github.com/photon-storage/go-common/testing/require"
+	rcpinner "github.com/photon-storage/go-rc-pinner"
+
+	"github.com/photon-storage/falcon/node/com"
+)
+
+func TestParseStreamFormat(t *testing.T) {
+	cases := []struct {
+		name   string
+		accept []string
+		format streamFormat
+	}{
+		{
+			name:   "no accept",
+			format: streamLegacy,
+		},
+		{
+			name:   "json",
+			accept: []string{"application/json"},
+			format: streamLegacy,
+		},
+		{
+			name:   "ndjson",
+			accept: []string{"application/x-ndjson"},
+			format: streamNDJSON,
+		},
+		{
+			name:   "sse with params",
+			accept: []string{"text/html, text/event-stream;q=0.9"},
+			format: streamSSE,
+		},
+		{
+			name:   "multiple headers",
+			accept: []string{"application/json", "application/x-ndjson"},
+			format: streamNDJSON,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := httptest.NewRequest(gohttp.MethodPost, "/api/v0/pin/add", nil)
+			for _, v := range c.accept {
+				r.Header.Add("Accept", v)
+			}
+			require.Equal(t, c.format, parseStreamFormat(r))
+		})
+	}
+}
+
+func TestEncodeEvent(t *testing.T) {
+	ev := &StreamEvent{
+		Type:          EventProgress,
+		FetchedBlocks: 2,
+		FetchedBytes:  64,
+	}
+
+	data, err := encodeEvent(streamNDJSON, ev)
+	require.NoError(t, err)
+	require.Equal(
+		t,
+		`{"type":"progress","fetched_blocks":2,"fetched_bytes":64}`+"\n",
+		string(data),
+	)
+
+	data, err = encodeEvent(streamSSE, ev)
+	require.NoError(t, err)
+	require.Equal(
+		t,
+		"event: progress\n"+
+			`data: {"type":"progress","fetched_blocks":2,"fetched_bytes":64}`+
+			"\n\n",
+		string(data),
+	)
+}
+
+func TestPinAddRespHandlerStream(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	bstore := blockstore.NewBlockstore(dstore)
+	bserv := bs.New(bstore, offline.Exchange(bstore))
+	dserv := merkledag.NewDAGService(bserv)
+
+	// A{B}
+	a := rndNode(t)
+	b := rndNode(t)
+	require.NoError(t, a.AddNodeLink("c0", b))
+	require.NoError(t, dserv.Add(ctx, a))
+	require.NoError(t, dserv.Add(ctx, b))
+
+	var fetched atomic.Uint64
+	fetched.Store(100)
+	ctx = rcpinner.WithDagSize(ctx, &fetched)
+
+	h := &pinAddRespHandler{
+		api: &mockAPI{
+			dag: &mockAPIDag{
+				DAGService: dserv,
+			},
+		},
+		root:      a.Cid(),
+		recursive: true,
+		format:    streamNDJSON,
+	}
+
+	decode := func(data []byte) *StreamEvent {
+		require.True(t, strings.HasSuffix(string(data), "\n"))
+		var ev StreamEvent
+		require.NoError(t, json.Unmarshal(data, &ev))
+		return &ev
+	}
+
+	data, err := h.update(ctx, []byte(`{"Progress":1}`))
+	require.NoError(t, err)
+	ev := decode(data)
+	require.Equal(t, EventProgress, ev.Type)
+	require.Equal(t, 1, ev.FetchedBlocks)
+	require.Equal(t, uint64(100), ev.FetchedBytes)
+	require.Nil(t, ev.Result)
+
+	data, err = h.update(
+		ctx,
+		[]byte(`{"Pins":["`+a.Cid().String()+`"],"Progress":2}`),
+	)
+	require.NoError(t, err)
+	ev = decode(data)
+	require.Equal(t, EventDone, ev.Type)
+	require.Equal(t, 2, ev.FetchedBlocks)
+	res := ev.Result.(map[string]interface{})
+	require.Equal(t, true, res["success"])
+	require.Equal(t, float64(2), res["total_num_blocks"])
+
+	data, err = h.update(
+		ctx,
+		[]byte(`{"Message":"context canceled","Code":0,"Type":"error"}`),
+	)
+	require.NoError(t, err)
+	ev = decode(data)
+	require.Equal(t, EventError, ev.Type)
+	require.Equal(t, "context canceled", ev.Message)
+
+	header := gohttp.Header{}
+	h.header(header)
+	require.Equal(t, mediaTypeNDJSON, header.Get("Content-Type"))
+}
+
+func TestPinRmRespHandlerError(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	bstore := blockstore.NewBlockstore(dstore)
+	dserv := merkledag.NewDAGService(bs.New(bstore, offline.Exchange(bstore)))
+	rcp, err := rcpinner.New(ctx, dstore, dserv)
+	require.NoError(t, err)
+	pinner := &com.WrappedPinner{
+		Pinner:    rcp,
+		Datastore: dstore,
+	}
+
+	a := rndNode(t)
+	require.NoError(t, dserv.Add(ctx, a))
+	require.NoError(t, pinner.Pin(ctx, a, true))
+	require.NoError(t, pinner.AddMeta(ctx, a.Cid(), true, &com.PinMeta{
+		Owner: "acct",
+	}))
+
+	// A failed unpin is passed through without side effects in all
+	// formats.
+	errData := []byte(`{"Message":"not pinned","Code":0,"Type":"error"}`)
+	for _, f := range []streamFormat{streamLegacy, streamNDJSON} {
+		h := &pinRmRespHandler{
+			api: &mockAPI{
+				dag: &mockAPIDag{
+					DAGService: dserv,
+				},
+			},
+			root:      a.Cid(),
+			recursive: true,
+			pinner:    pinner,
+			owner:     "acct",
+			format:    f,
+		}
+		data, err := h.update(ctx, errData)
+		require.NoError(t, err)
+		if f == streamLegacy {
+			require.DeepEqual(t, errData, data)
+		} else {
+			var ev StreamEvent
+			require.NoError(t, json.Unmarshal(data, &ev))
+			require.Equal(t, EventError, ev.Type)
+		}
+
+		metas, err := pinner.ListMeta(ctx, a.Cid(), true)
+		require.NoError(t, err)
+		require.Equal(t, 1, len(metas))
+	}
+}
+
+func TestPinListStream(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	bstore := blockstore.NewBlockstore(dstore)
+	bserv := bs.New(bstore, offline.Exchange(bstore))
+	dserv := merkledag.NewDAGService(bserv)
+	rcp, err := rcpinner.New(ctx, dstore, dserv)
+	require.NoError(t, err)
+	pinner := &com.WrappedPinner{
+		Pinner: rcp,
+	}
+
+	h := New(
+		&core.IpfsNode{
+			Pinning: pinner,
+		},
+		nil,
+		nil,
+	)
+
+	for i := 0; i < cidBatchSize+10; i++ {
+		nd := rndNode(t)
+		require.NoError(t, dserv.Add(ctx, nd))
+		require.NoError(t, pinner.Pin(ctx, nd, true))
+	}
+
+	r := httptest.NewRequest(
+		gohttp.MethodGet,
+		"/api/v0/pin/ls?recursive=1",
+		nil,
+	)
+	r.Header.Set("Accept", mediaTypeNDJSON)
+	w := httptest.NewRecorder()
+	h.PinList()(w, r)
+	require.Equal(t, gohttp.StatusOK, w.Code)
+	require.Equal(t, mediaTypeNDJSON, w.Header().Get("Content-Type"))
+
+	type listEvent struct {
+		Type   string         `json:"type"`
+		Result *PinListResult `json:"result"`
+	}
+	var events []*listEvent
+	sc := bufio.NewScanner(w.Body)
+	for sc.Scan() {
+		var ev listEvent
+		require.NoError(t, json.Unmarshal(sc.Bytes(), &ev))
+		events = append(events, &ev)
+	}
+	require.Equal(t, 2, len(events))
+	require.Equal(t, EventProgress, events[0].Type)
+	require.True(t, events[0].Result.InProgress)
+	require.Equal(t, cidBatchSize, len(events[0].Result.Batch))
+	require.Equal(t, EventDone, events[1].Type)
+	require.True(t, events[1].Result.Success)
+	require.Equal(t, 10, len(events[1].Result.Batch))
+}
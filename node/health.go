@@ -0,0 +1,207 @@
+package node
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/kubo/core"
+
+	"github.com/photon-storage/falcon/node/config"
+	"github.com/photon-storage/falcon/node/handlers"
+)
+
+const (
+	defaultMinPeers            = 1
+	defaultCertExpiryThreshold = 72 * time.Hour
+	defaultMaxReportBacklog    = 100
+	defaultMinDiskFreeMBytes   = 1024
+)
+
+var (
+	ErrNotRegistered     = errors.New("node is not registered with starbase")
+	ErrNotEnoughPeers    = errors.New("not enough connected peers")
+	ErrCertExpiring      = errors.New("TLS certificate is expiring")
+	ErrReportBacklog     = errors.New("usage report backlog is too large")
+	ErrDiskSpaceLow      = errors.New("disk space is low")
+	ErrInvalidCertFormat = errors.New("invalid certificate format")
+
+	errDiskFreeUnsupported = errors.New("disk free space unsupported")
+
+	healthProbeKey = datastore.NewKey("/falcon/health/probe")
+)
+
+func registerHealthChecks(
+	h *handlers.ExtendedHandlers,
+	nd *core.IpfsNode,
+	repoPath string,
+) {
+	cfg := config.Get()
+
+	h.RegisterHealthCheck(&handlers.HealthCheck{
+		Name:     "datastore",
+		Critical: true,
+		Run: func(ctx context.Context) error {
+			return checkDatastore(ctx, nd.Repo.Datastore())
+		},
+	})
+	h.RegisterHealthCheck(&handlers.HealthCheck{
+		Name:     "peers",
+		Critical: true,
+		Run: func(context.Context) error {
+			return checkPeers(
+				len(nd.PeerHost.Network().Peers()),
+				withDefault(cfg.Health.MinPeers, defaultMinPeers),
+			)
+		},
+	})
+	h.RegisterHealthCheck(&handlers.HealthCheck{
+		Name:     "disk",
+		Critical: true,
+		Run: func(context.Context) error {
+			return checkDiskFree(
+				repoPath,
+				uint64(withDefault(
+					cfg.Health.MinDiskFreeMBytes,
+					defaultMinDiskFreeMBytes,
+				))<<20,
+			)
+		},
+	})
+	if cfg.EnableNodeRegistration() {
+		h.RegisterHealthCheck(&handlers.HealthCheck{
+			Name:     "registration",
+			Critical: true,
+			Run: func(context.Context) error {
+				if !registered.Load() {
+					return ErrNotRegistered
+				}
+				return nil
+			},
+		})
+	}
+	if cfg.RequireTLSCert() {
+		h.RegisterHealthCheck(&handlers.HealthCheck{
+			Name:     "certificate",
+			Critical: true,
+			Run: func(context.Context) error {
+				return checkCertExpiry(
+					time.Now(),
+					withDefault(
+						cfg.Health.CertExpiryThreshold,
+						defaultCertExpiryThreshold,
+					),
+				)
+			},
+		})
+	}
+	if cfg.ExternalServices.Spaceport != "" {
+		h.RegisterHealthCheck(&handlers.HealthCheck{
+			Name: "reporting",
+			Run: func(context.Context) error {
+				return checkReportBacklog(
+					int(logPending.Load()),
+					withDefault(
+						cfg.Health.MaxReportBacklog,
+						defaultMaxReportBacklog,
+					),
+				)
+			},
+		})
+	}
+}
+
+func withDefault[T int | time.Duration](v T, def T) T {
+	if v <= 0 {
+		return def
+	}
+	return v
+}
+
+// checkDatastore probes datastore writability, which catches read-only
+// filesystems and corrupted stores.
+func checkDatastore(ctx context.Context, ds datastore.Datastore) error {
+	if err := ds.Put(
+		ctx,
+		healthProbeKey,
+		[]byte(strconv.FormatInt(time.Now().Unix(), 10)),
+	); err != nil {
+		return fmt.Errorf("error writing datastore: %w", err)
+	}
+	if err := ds.Delete(ctx, healthProbeKey); err != nil {
+		return fmt.Errorf("error deleting from datastore: %w", err)
+	}
+	return nil
+}
+
+func checkPeers(connected int, min int) error {
+	if connected < min {
+		return fmt.Errorf("%w: %v < %v", ErrNotEnoughPeers, connected, min)
+	}
+	return nil
+}
+
+func checkReportBacklog(pending int, max int) error {
+	if pending > max {
+		return fmt.Errorf("%w: %v > %v", ErrReportBacklog, pending, max)
+	}
+	return nil
+}
+
+func checkDiskFree(path string, min uint64) error {
+	free, err := diskFree(path)
+	if err != nil {
+		if errors.Is(err, errDiskFreeUnsupported) {
+			return nil
+		}
+		return fmt.Errorf("error getting free disk space: %w", err)
+	}
+	if free < min {
+		return fmt.Errorf("%w: %v bytes free", ErrDiskSpaceLow, free)
+	}
+	return nil
+}
+
+func checkCertExpiry(now time.Time, threshold time.Duration) error {
+	cp, err := findCertAndKeyFile()
+	if err != nil {
+		return err
+	}
+
+	notAfter, err := certNotAfter(cp.certFile)
+	if err != nil {
+		return err
+	}
+
+	if now.Add(threshold).After(notAfter) {
+		return fmt.Errorf("%w: expires at %v",
+			ErrCertExpiring,
+			notAfter.UTC().Format(time.RFC3339),
+		)
+	}
+	return nil
+}
+
+func certNotAfter(certFile string) (time.Time, error) {
+	data, err := os.ReadFile(certFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	blk, _ := pem.Decode(data)
+	if blk == nil {
+		return time.Time{}, ErrInvalidCertFormat
+	}
+	cert, err := x509.ParseCertificate(blk.Bytes)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: %v", ErrInvalidCertFormat, err)
+	}
+
+	return cert.NotAfter, nil
+}
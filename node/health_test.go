@@ -0,0 +1,30 @@
+package node
+
+import (
+	"context"
+	"testing"
+
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+
+	"github.com/photon-storage/go-common/testing/require"
+)
+
+func TestHealthChecks(t *testing.T) {
+	ctx := context.Background()
+
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	require.NoError(t, checkDatastore(ctx, dstore))
+	exists, err := dstore.Has(ctx, healthProbeKey)
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	require.NoError(t, checkPeers(1, 1))
+	require.ErrorIs(t, ErrNotEnoughPeers, checkPeers(0, 1))
+
+	require.NoError(t, checkReportBacklog(10, 10))
+	require.ErrorIs(t, ErrReportBacklog, checkReportBacklog(11, 10))
+
+	require.NoError(t, checkDiskFree(t.TempDir(), 0))
+	require.ErrorIs(t, ErrDiskSpaceLow, checkDiskFree(t.TempDir(), 1<<62))
+}
@@ -1,8 +1,10 @@
 package node
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/enescakir/emoji"
 	cmds "github.com/ipfs/go-ipfs-cmds"
@@ -16,6 +18,7 @@ import (
 
 	"github.com/photon-storage/falcon/node/com"
 	"github.com/photon-storage/falcon/node/config"
+	"github.com/photon-storage/falcon/node/tracing"
 )
 
 // This file defines interfaces for hooking Falcon logic into the
@@ -84,15 +87,18 @@ import (
 //     }
 //     //////////////////// Falcon ////////////////////
 //
-//  8. Disable debug handler in cmd/falcon/debug.go
+//  8. Replace corerepo.PeriodicGC(...) with falcon.PeriodicGC(...) in
+//     maybeRunGC() from cmd/falcon/daemon.go to collect GC metrics.
 //
-//  9. Run `go mod tidy` to update go.mod with whats required by the new kubo
+//  9. Disable debug handler in cmd/falcon/debug.go
+//
+//  10. Run `go mod tidy` to update go.mod with whats required by the new kubo
 //     version. There might be a conflict with the otel package when building
 //     falcon. Use `go get` to force the version used in kubo/go.mod.
 //     For example:
 //     go get go.opentelemetry.io/otel@v1.7.0
 //
-//  10. Make sure corenode.Core = fx.Options() assignments in
+//  11. Make sure corenode.Core = fx.Options() assignments in
 //     InitFalconBeforeNodeConstruction is consistent with Kubo.
 //
 // Example command to run falcon daemon:
@@ -135,6 +141,19 @@ func InitFalconBeforeNodeConstruction(
 		return err
 	}
 
+	shutdownTracing, err := tracing.Init(req.Context)
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-req.Context.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			log.Error("Error shutting down tracing", "error", err)
+		}
+	}()
+
 	if err := overrideIPFSConfig(rpath, rpo); err != nil {
 		return err
 	}
@@ -179,6 +198,10 @@ func InitFalconAfterNodeConstruction(
 		return nil, ErrRcPinnerMissing
 	}
 
+	if err := migrateLegacyPins(req.Context, nd); err != nil {
+		return nil, err
+	}
+
 	if config.Get().EnableNodeRegistration() {
 		if err := registerFalconNode(req.Context, nd); err != nil {
 			return nil, err
@@ -186,7 +209,7 @@ func InitFalconAfterNodeConstruction(
 	}
 
 	initMetrics(req.Context, 9981)
-	go updateNodeMetrics(req.Context, nd)
+	go updateNodeMetrics(req.Context, nd, cctx.ConfigRoot)
 
 	errc, err := initFalconGateway(req.Context, cctx, nd)
 	if err != nil {
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"io"
 	"net/http"
+	"strconv"
 )
 
 var (
@@ -48,16 +49,25 @@ func (c *ingressCounter) size() int {
 }
 
 // egressCounter is a wrapper for the underlying http.ResponseWriter and counts
-// number of bytes written to it.
+// number of bytes written to it. Once the written bytes exceed maxSize, the
+// response is cut off and further writes fail with ErrSizeCapExceeded.
 type egressCounter struct {
-	w  http.ResponseWriter
-	sz int
+	w             http.ResponseWriter
+	maxSize       int
+	sz            int
+	statusCode    int
+	ipfsPath      string
+	ipfsRoots     string
+	headerWritten bool
+	exceeded      bool
+	rejected      bool
 }
 
-func newEgressCounter(w http.ResponseWriter) *egressCounter {
+func newEgressCounter(w http.ResponseWriter, maxSize int) *egressCounter {
 	return &egressCounter{
-		w:  w,
-		sz: 0,
+		w:       w,
+		maxSize: maxSize,
+		sz:      0,
 	}
 }
 
@@ -66,14 +76,96 @@ func (c *egressCounter) Header() http.Header {
 }
 
 func (c *egressCounter) Write(data []byte) (int, error) {
-	c.sz += len(data)
-	return c.w.Write(data)
+	if !c.headerWritten {
+		c.WriteHeader(http.StatusOK)
+	}
+	if c.exceeded {
+		return 0, ErrSizeCapExceeded
+	}
+
+	if c.maxSize > 0 && c.sz+len(data) > c.maxSize+allowance {
+		c.exceeded = true
+		n, err := c.w.Write(data[:c.maxSize+allowance-c.sz])
+		c.sz += n
+		if err != nil {
+			return n, err
+		}
+		return n, ErrSizeCapExceeded
+	}
+
+	n, err := c.w.Write(data)
+	c.sz += n
+	return n, err
 }
 
+// WriteHeader rejects the response upfront if the declared content length
+// already exceeds the size cap. Nothing has been sent to the client yet, so
+// a proper error status can be returned instead of a truncated body.
 func (c *egressCounter) WriteHeader(statusCode int) {
+	if c.headerWritten {
+		return
+	}
+	c.headerWritten = true
+	c.statusCode = statusCode
+	c.ipfsPath = c.w.Header().Get("X-Ipfs-Path")
+	c.ipfsRoots = c.w.Header().Get("X-Ipfs-Roots")
+
+	if c.maxSize > 0 {
+		cl, err := strconv.ParseInt(c.w.Header().Get("Content-Length"), 10, 64)
+		if err == nil && int(cl) > c.maxSize+allowance {
+			c.exceeded = true
+			c.rejected = true
+
+			header := c.w.Header()
+			delete(header, "Content-Length")
+			delete(header, "Content-Type")
+			delete(header, "Etag")
+			delete(header, "X-Ipfs-Path")
+			delete(header, "X-Ipfs-Roots")
+			c.statusCode = http.StatusRequestEntityTooLarge
+			http.Error(c.w, ErrSizeCapExceeded.Error(), c.statusCode)
+			return
+		}
+	}
+
 	c.w.WriteHeader(statusCode)
 }
 
+// Flush sends buffered data to the client unless the response is
+// rejected.
+func (c *egressCounter) Flush() {
+	if c.rejected {
+		return
+	}
+	if f, ok := c.w.(http.Flusher); ok {
+		if !c.headerWritten {
+			c.WriteHeader(http.StatusOK)
+			if c.rejected {
+				return
+			}
+		}
+		f.Flush()
+	}
+}
+
 func (c *egressCounter) size() int {
 	return c.sz
 }
+
+// status returns the status code sent to the client, or 0 if no header
+// has been written yet.
+func (c *egressCounter) status() int {
+	return c.statusCode
+}
+
+// capExceeded returns true if the response hit the size cap.
+func (c *egressCounter) capExceeded() bool {
+	return c.exceeded
+}
+
+// truncated returns true if part of the response was sent to the client
+// before hitting the size cap. The connection needs to be aborted in this
+// case so the client does not mistake a truncated body as complete.
+func (c *egressCounter) truncated() bool {
+	return c.exceeded && !c.rejected
+}
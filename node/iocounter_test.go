@@ -0,0 +1,100 @@
+package node
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/photon-storage/go-common/testing/require"
+)
+
+func TestIngressCounter(t *testing.T) {
+	maxSize := 1024
+	body := io.NopCloser(bytes.NewReader(make([]byte, maxSize+allowance+1)))
+	c := newIngressCounter(body, maxSize)
+	_, err := io.ReadAll(c)
+	require.ErrorIs(t, ErrSizeCapExceeded, err)
+}
+
+func TestEgressCounter(t *testing.T) {
+	maxSize := 1024
+
+	cases := []struct {
+		name string
+		run  func(t *testing.T)
+	}{
+		{
+			name: "no cap",
+			run: func(t *testing.T) {
+				w := httptest.NewRecorder()
+				c := newEgressCounter(w, 0)
+				n, err := c.Write(make([]byte, maxSize+allowance+1))
+				require.NoError(t, err)
+				require.Equal(t, maxSize+allowance+1, n)
+				require.Equal(t, maxSize+allowance+1, c.size())
+				require.False(t, c.capExceeded())
+			},
+		},
+		{
+			name: "within cap",
+			run: func(t *testing.T) {
+				w := httptest.NewRecorder()
+				c := newEgressCounter(w, maxSize)
+				for i := 0; i < 2; i++ {
+					_, err := c.Write(make([]byte, maxSize))
+					require.NoError(t, err)
+				}
+				require.Equal(t, http.StatusOK, w.Code)
+				require.Equal(t, 2*maxSize, c.size())
+				require.False(t, c.capExceeded())
+			},
+		},
+		{
+			name: "cut off",
+			run: func(t *testing.T) {
+				w := httptest.NewRecorder()
+				c := newEgressCounter(w, maxSize)
+				c.WriteHeader(http.StatusOK)
+				_, err := c.Write(make([]byte, maxSize))
+				require.NoError(t, err)
+				n, err := c.Write(make([]byte, allowance+1))
+				require.ErrorIs(t, ErrSizeCapExceeded, err)
+				require.Equal(t, allowance, n)
+				_, err = c.Write(make([]byte, 1))
+				require.ErrorIs(t, ErrSizeCapExceeded, err)
+
+				require.Equal(t, http.StatusOK, w.Code)
+				require.Equal(t, maxSize+allowance, w.Body.Len())
+				require.Equal(t, maxSize+allowance, c.size())
+				require.True(t, c.capExceeded())
+				require.True(t, c.truncated())
+			},
+		},
+		{
+			name: "rejected by content length",
+			run: func(t *testing.T) {
+				w := httptest.NewRecorder()
+				c := newEgressCounter(w, maxSize)
+				c.Header().Set(
+					"Content-Length",
+					fmt.Sprintf("%v", maxSize+allowance+1),
+				)
+				c.WriteHeader(http.StatusOK)
+				_, err := c.Write(make([]byte, 1))
+				require.ErrorIs(t, ErrSizeCapExceeded, err)
+
+				require.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+				require.Equal(t, 0, c.size())
+				require.True(t, c.capExceeded())
+				require.False(t, c.truncated())
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, c.run)
+	}
+}
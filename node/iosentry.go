@@ -111,6 +111,20 @@ func (s *contentSentry) WriteHeader(statusCode int) {
 	s.statusCode = statusCode
 }
 
+// Flush sends written data to the client. With detection enabled, data is
+// held until a batch is checked, so only data sent already is flushed.
+func (s *contentSentry) Flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.headerWritten {
+		return
+	}
+	if f, ok := s.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
 func (s *contentSentry) flush() error {
 	// Empty batch buffer. No accumulation happened since the last flush.
 	if len(s.buf) == 0 {
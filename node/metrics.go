@@ -3,18 +3,25 @@ package node
 import (
 	"context"
 	"fmt"
+	"io/fs"
+	"path/filepath"
 	"time"
 
+	"github.com/dustin/go-humanize"
+	"github.com/ipfs/boxo/bitswap"
 	core "github.com/ipfs/kubo/core"
 
+	"github.com/photon-storage/go-common/log"
 	"github.com/photon-storage/go-common/metrics"
 
 	"github.com/photon-storage/falcon/node/com"
+	"github.com/photon-storage/falcon/node/handlers"
 )
 
 // initMetrics register the metrics to prometheus.
 func initMetrics(ctx context.Context, port int) {
-	metrics.Init(ctx, "p3_falcon", port)
+	namespace := "p3_falcon"
+	metrics.Init(ctx, namespace, port)
 	metrics.NewGauge("restart_at_seconds")
 
 	metrics.RegisterDiskMetrics(ctx)
@@ -30,13 +37,35 @@ func initMetrics(ctx context.Context, port int) {
 		))
 	}
 	metrics.NewCounter("request_served_total")
+	metrics.NewCounter("request_egress_capped_total")
+	metrics.NewCounter(fmt.Sprintf("request_%v_total", abortReasonTimeout))
+	metrics.NewCounter(fmt.Sprintf("request_%v_total", abortReasonStalled))
 	metrics.NewCounter("request_log_total")
 	metrics.NewCounter("request_log_err_total")
+	registerRouteMetrics(namespace)
 
 	// Node metrics.
 	com.RegisterPinnerMetrics()
+	handlers.RegisterPinJobMetrics()
+	handlers.RegisterDagStatsMetrics()
+	handlers.RegisterPinTTLMetrics()
+	handlers.RegisterQuotaMetrics()
+	handlers.RegisterPinVerifyMetrics()
 	metrics.NewGauge("pinned_count_total")
 	metrics.NewGauge("connected_peers_total")
+	metrics.NewGauge("repo_size_bytes")
+	metrics.NewGauge("repo_storage_max_bytes")
+	metrics.NewGauge("datastore_disk_usage_bytes")
+	metrics.NewGauge("blockstore_blocks_total")
+	metrics.NewCounter("gc_runs_total")
+	metrics.NewCounter("gc_err_total")
+	metrics.NewCounter("gc_removed_blocks_total")
+	metrics.NewCounter("gc_reclaimed_bytes")
+	metrics.NewGauge("bitswap_wantlist_total")
+	metrics.NewCounter("bitswap_data_received_bytes")
+	metrics.NewCounter("bitswap_data_sent_bytes")
+	metrics.NewGauge("dht_routing_table_size.table#wan")
+	metrics.NewGauge("dht_routing_table_size.table#lan")
 
 	metrics.GaugeSet("restart_at_seconds", float64(time.Now().Unix()))
 }
@@ -44,8 +73,13 @@ func initMetrics(ctx context.Context, port int) {
 func updateNodeMetrics(
 	ctx context.Context,
 	nd *core.IpfsNode,
+	repoPath string,
 ) {
 	ticker := time.NewTicker(15 * time.Second)
+	// Enumerating blocks and walking the repo directory are expensive
+	// on large repos, hence a slower interval.
+	slowTicker := time.NewTicker(10 * time.Minute)
+	bs := &bitswapCounter{}
 
 	for {
 		select {
@@ -63,6 +97,142 @@ func updateNodeMetrics(
 				"pinned_count_total",
 				float64(com.GetRcPinner(nd.Pinning).TotalPinnedCount()),
 			)
+
+			updateRepoMetrics(ctx, nd)
+			bs.update(nd)
+			updateDHTMetrics(nd)
+
+		case <-slowTicker.C:
+			updateBlockstoreMetrics(ctx, nd)
+			updateDiskUsageMetrics(repoPath)
 		}
 	}
 }
+
+func updateRepoMetrics(ctx context.Context, nd *core.IpfsNode) {
+	sz, err := nd.Repo.GetStorageUsage(ctx)
+	if err != nil {
+		log.Error("Error getting repo storage usage", "error", err)
+	} else {
+		metrics.GaugeSet("repo_size_bytes", float64(sz))
+	}
+
+	cfg, err := nd.Repo.Config()
+	if err != nil {
+		log.Error("Error reading IPFS config", "error", err)
+		return
+	}
+	if cfg.Datastore.StorageMax == "" {
+		return
+	}
+	max, err := humanize.ParseBytes(cfg.Datastore.StorageMax)
+	if err != nil {
+		log.Error("Error parsing storage max",
+			"value", cfg.Datastore.StorageMax,
+			"error", err,
+		)
+		return
+	}
+	metrics.GaugeSet("repo_storage_max_bytes", float64(max))
+}
+
+func updateBlockstoreMetrics(ctx context.Context, nd *core.IpfsNode) {
+	ch, err := nd.Blockstore.AllKeysChan(ctx)
+	if err != nil {
+		log.Error("Error enumerating blockstore keys", "error", err)
+		return
+	}
+
+	cnt := 0
+	for range ch {
+		cnt++
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	metrics.GaugeSet("blockstore_blocks_total", float64(cnt))
+}
+
+// updateDiskUsageMetrics sets the actual disk space taken by the repo
+// directory, which may diverge from the datastore estimation used
+// against StorageMax.
+func updateDiskUsageMetrics(repoPath string) {
+	if repoPath == "" {
+		return
+	}
+
+	var sz int64
+	if err := filepath.WalkDir(
+		repoPath,
+		func(_ string, d fs.DirEntry, err error) error {
+			if err != nil {
+				// Files may be removed during the walk.
+				return nil
+			}
+			if d.Type().IsRegular() {
+				if info, err := d.Info(); err == nil {
+					sz += info.Size()
+				}
+			}
+			return nil
+		},
+	); err != nil {
+		log.Error("Error walking repo directory", "error", err)
+		return
+	}
+	metrics.GaugeSet("datastore_disk_usage_bytes", float64(sz))
+}
+
+// bitswapCounter converts cumulative bitswap stats to counter increments.
+type bitswapCounter struct {
+	received uint64
+	sent     uint64
+}
+
+func (c *bitswapCounter) update(nd *core.IpfsNode) {
+	bs, ok := nd.Exchange.(*bitswap.Bitswap)
+	if !ok {
+		return
+	}
+
+	st, err := bs.Stat()
+	if err != nil {
+		log.Error("Error getting bitswap stat", "error", err)
+		return
+	}
+
+	metrics.GaugeSet("bitswap_wantlist_total", float64(len(st.Wantlist)))
+	if st.DataReceived >= c.received {
+		metrics.CounterAdd(
+			"bitswap_data_received_bytes",
+			float64(st.DataReceived-c.received),
+		)
+	}
+	c.received = st.DataReceived
+	if st.DataSent >= c.sent {
+		metrics.CounterAdd(
+			"bitswap_data_sent_bytes",
+			float64(st.DataSent-c.sent),
+		)
+	}
+	c.sent = st.DataSent
+}
+
+func updateDHTMetrics(nd *core.IpfsNode) {
+	if nd.DHT == nil {
+		return
+	}
+
+	if nd.DHT.WAN != nil {
+		metrics.GaugeSet(
+			"dht_routing_table_size.table#wan",
+			float64(nd.DHT.WAN.RoutingTable().Size()),
+		)
+	}
+	if nd.DHT.LAN != nil {
+		metrics.GaugeSet(
+			"dht_routing_table_size.table#lan",
+			float64(nd.DHT.LAN.RoutingTable().Size()),
+		)
+	}
+}
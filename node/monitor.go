@@ -9,9 +9,13 @@ import (
 	gohttp "net/http"
 	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	coreiface "github.com/ipfs/boxo/coreiface"
+	"github.com/ipfs/go-cid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/atomic"
 
 	"github.com/photon-storage/go-common/log"
@@ -23,6 +27,7 @@ import (
 
 	"github.com/photon-storage/falcon/node/config"
 	"github.com/photon-storage/falcon/node/handlers"
+	"github.com/photon-storage/falcon/node/tracing"
 )
 
 type monitorHandler struct {
@@ -51,9 +56,18 @@ func (m *monitorHandler) wrap(next gohttp.Handler) gohttp.Handler {
 		ctx, cancel := context.WithCancel(r.Context())
 		defer cancel()
 
+		ctx, span := tracing.Span(ctx, "Monitor", "Request")
+		defer span.End()
+
 		ctx = WithNoReport(ctx)
-		p2pIngr := atomic.NewUint64(0)
-		ctx = rcpinner.WithDagSize(ctx, p2pIngr)
+		// Reuse the p2p ingress counter set by auth for stall detection.
+		p2pIngr := rcpinner.DagSize(ctx)
+		if p2pIngr == nil {
+			p2pIngr = atomic.NewUint64(0)
+			ctx = rcpinner.WithDagSize(ctx, p2pIngr)
+		}
+		networkFetch := atomic.NewBool(false)
+		ctx = WithNetworkFetch(ctx, networkFetch)
 		dagStats := handlers.NewDagStats()
 		ctx = handlers.WithDagStat(ctx, dagStats)
 		r = r.WithContext(ctx)
@@ -68,12 +82,13 @@ func (m *monitorHandler) wrap(next gohttp.Handler) gohttp.Handler {
 			return
 		}
 
+		sw, _ := w.(*contentSentry)
 		httpIngr := newIngressCounter(r.Body, maxSize)
-		httpEgr := newEgressCounter(w)
+		httpEgr := newEgressCounter(w, maxSize)
 		r.Body = httpIngr
 		w = httpEgr
 
-		go newMonitor(
+		mon := newMonitor(
 			m.coreapi,
 			r,
 			httpIngr,
@@ -81,9 +96,29 @@ func (m *monitorHandler) wrap(next gohttp.Handler) gohttp.Handler {
 			p2pIngr,
 			maxSize,
 			dagStats,
-		).run(ctx, cancel)
+		)
+		mon.networkFetch = networkFetch
+		go mon.run(ctx, cancel)
 
 		next.ServeHTTP(w, r)
+
+		// Flush pending content so the final report sees the sentry
+		// decision. It is safe as auth flushes again afterwards.
+		if sw != nil {
+			sw.flush()
+			mon.flagged.Store(sw.getFlaggedRuleName() != "")
+		}
+
+		if httpEgr.capExceeded() {
+			metrics.CounterInc("request_egress_capped_total")
+			if httpEgr.truncated() {
+				// Part of the body has been sent. Abort the connection
+				// so the client sees an incomplete response instead of
+				// a truncated one. The deferred cancel still fires and
+				// the monitor reports the usage up to the cutoff.
+				panic(gohttp.ErrAbortHandler)
+			}
+		}
 	})
 }
 
@@ -93,12 +128,16 @@ type monitor struct {
 	httpEgr         *egressCounter
 	p2pIngr         *atomic.Uint64
 	p2pIngrReported *atomic.Uint64
+	networkFetch    *atomic.Bool
+	flagged         *atomic.Bool
 	maxSize         int
 	dagStats        *handlers.DagStats
 	method          string
 	host            string
 	uri             string
 	query           url.Values
+	requestID       string
+	start           time.Time
 }
 
 func newMonitor(
@@ -116,12 +155,16 @@ func newMonitor(
 		httpEgr:         httpEgr,
 		p2pIngr:         p2pIngr,
 		p2pIngrReported: atomic.NewUint64(0),
+		networkFetch:    atomic.NewBool(false),
+		flagged:         atomic.NewBool(false),
 		maxSize:         maxSize,
 		dagStats:        dagStats,
 		method:          req.Method,
 		host:            req.Host,
 		uri:             auth.CanonicalizeURI(req.URL.Path),
 		query:           req.URL.Query(),
+		requestID:       GetRequestIDFromCtx(req.Context()),
+		start:           time.Now(),
 	}
 }
 
@@ -145,17 +188,13 @@ func (m *monitor) run(ctx context.Context, cancel context.CancelFunc) {
 			head := m.p2pIngr.Load()
 			tail := m.p2pIngrReported.Load()
 			if head-tail > reportIncr {
-				if err := sendLog(
-					m.method,
-					m.host,
-					m.uri,
-					m.query,
+				if err := sendLog(ctx, m.newLog(
 					true, // in progress
 					int(head-tail),
 					0, // egress
 					0, // pinned count
 					0, // pinned bytes
-				); err != nil {
+				)); err != nil {
 					metrics.CounterInc("request_log_err_total")
 					log.Error("Error making in-progress log request", "error", err)
 				}
@@ -166,58 +205,173 @@ func (m *monitor) run(ctx context.Context, cancel context.CancelFunc) {
 		}
 	}
 
-	if err := sendLog(
-		m.method,
-		m.host,
-		m.uri,
-		m.query,
+	if err := sendLog(ctx, m.newLog(
 		false, // in progress
 		m.httpIngr.size()+int(m.p2pIngr.Load()-m.p2pIngrReported.Load()),
 		m.httpEgr.size(),
 		int(m.dagStats.TotalCount.Load()),
 		int(m.dagStats.TotalSize.Load()),
-	); err != nil {
+	)); err != nil {
 		metrics.CounterInc("request_log_err_total")
 		log.Error("Error making log request", "error", err)
 	}
 }
 
-func sendLog(
-	method string,
-	host string,
-	uri string,
-	query url.Values,
+func (m *monitor) newLog(
 	inProgress bool,
 	ingr int,
 	egr int,
 	pinnedCount int,
 	pinnedBytes int,
-) error {
-	metrics.CounterAdd("ingress_bytes", float64(ingr))
-	metrics.CounterAdd("egress_bytes", float64(egr))
-	metrics.CounterInc("request_log_total")
+) *LogV3 {
+	source := logSourceLocal
+	if m.p2pIngr.Load() > 0 || m.networkFetch.Load() {
+		source = logSourceNetwork
+	}
+
+	root, name := m.resolvedContent()
+	return &LogV3{
+		LogV1: reporting.LogV1{
+			Req: reporting.AuthReq{
+				Method: m.method,
+				Host:   m.host,
+				URI:    m.uri,
+				Args:   m.query.Get(http.ParamP3Args),
+				Sig:    m.query.Get(http.ParamP3Sig),
+			},
+			InProgress:  inProgress,
+			PinnedCount: pinnedCount,
+			PinnedBytes: pinnedBytes,
+			Ingress:     ingr,
+			Egress:      egr,
+			At:          time.Now().Unix(),
+		},
+		Status:     m.httpEgr.status(),
+		DurationMs: time.Since(m.start).Milliseconds(),
+		Root:       root,
+		Name:       name,
+		Source:     source,
+		Flagged:    m.flagged.Load(),
+		RequestID:  m.requestID,
+	}
+}
+
+// resolvedContent returns the root CID and IPNS name served by the request.
+// Gateway responses carry them in X-Ipfs-Roots and X-Ipfs-Path headers.
+// API requests fall back to the CID given in the arg parameter.
+func (m *monitor) resolvedContent() (string, string) {
+	root := ""
+	if roots := m.httpEgr.ipfsRoots; roots != "" {
+		root = strings.Split(roots, ",")[0]
+	} else if c, err := cid.Decode(m.query.Get(http.ParamIPFSArg)); err == nil {
+		root = c.String()
+	}
+
+	name := ""
+	if p := m.httpEgr.ipfsPath; strings.HasPrefix(p, "/ipns/") {
+		name = strings.SplitN(strings.TrimPrefix(p, "/ipns/"), "/", 2)[0]
+	}
+
+	return root, name
+}
 
-	// NOTE(kmax):
+const (
 	// version 1: no PinnedCount field. Used sign of PinnedBytes to determine
 	// whether it is a pin or unpin.
-	logData, err := json.Marshal(&reporting.LogV1{
-		Version: 2,
-		Req: reporting.AuthReq{
-			Method: method,
-			Host:   host,
-			URI:    uri,
-			Args:   query.Get(http.ParamP3Args),
-			Sig:    query.Get(http.ParamP3Sig),
-		},
-		InProgress:  inProgress,
-		PinnedCount: pinnedCount,
-		PinnedBytes: pinnedBytes,
-		Ingress:     ingr,
-		Egress:      egr,
-		At:          time.Now().Unix(),
-	})
+	// version 2: LogV1 with PinnedCount.
+	// version 3: LogV3 with response status, duration, resolved content,
+	// content source, sentry flag and request ID.
+	logVersionLegacy = 2
+	logVersionLatest = 3
+
+	logSourceLocal   = "local"
+	logSourceNetwork = "network"
+)
+
+var (
+	// Set when Spaceport rejects the latest log version.
+	logVersionDowngraded = atomic.NewBool(false)
+	// Number of logs being delivered to Spaceport.
+	logPending = atomic.NewInt64(0)
+)
+
+// LogV3 extends reporting.LogV1 with per-request details used for billing
+// disputes and analytics.
+type LogV3 struct {
+	reporting.LogV1
+
+	Status     int    `json:"st"`
+	DurationMs int64  `json:"dur"`
+	Root       string `json:"rt"`
+	Name       string `json:"nm"`
+	Source     string `json:"src"`
+	Flagged    bool   `json:"fl"`
+	RequestID  string `json:"rid"`
+}
+
+// logVersion returns the log version to send to Spaceport. A version
+// pinned in config takes precedence. Otherwise, the latest version is
+// used until Spaceport rejects it.
+func logVersion() int {
+	if v := config.Get().ExternalServices.SpaceportLogVersion; v > 0 {
+		return v
+	}
+	if logVersionDowngraded.Load() {
+		return logVersionLegacy
+	}
+	return logVersionLatest
+}
+
+func sendLog(ctx context.Context, l *LogV3) (err error) {
+	_, span := tracing.Span(
+		ctx,
+		"Monitor",
+		"SendLog",
+		trace.WithAttributes(attribute.Bool("in_progress", l.InProgress)),
+	)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	metrics.CounterAdd("ingress_bytes", float64(l.Ingress))
+	metrics.CounterAdd("egress_bytes", float64(l.Egress))
+	metrics.CounterInc("request_log_total")
+	logPending.Inc()
+	defer logPending.Dec()
+
+	ver := logVersion()
+	span.SetAttributes(attribute.Int("version", ver))
+	code, err := postLog(l, ver)
+	if code == gohttp.StatusBadRequest &&
+		ver > logVersionLegacy &&
+		config.Get().ExternalServices.SpaceportLogVersion == 0 {
+		log.Warn("Spaceport rejects log version, falling back",
+			"version", ver,
+			"fallback", logVersionLegacy,
+			"error", err,
+		)
+		logVersionDowngraded.Store(true)
+		_, err = postLog(l, logVersionLegacy)
+	}
+
+	return err
+}
+
+// postLog encodes the log with the given version and posts it to Spaceport.
+// It returns the response status code if a response is received.
+func postLog(l *LogV3, ver int) (int, error) {
+	var v interface{}
+	if ver < logVersionLatest {
+		legacy := l.LogV1
+		legacy.Version = ver
+		v = &legacy
+	} else {
+		latest := *l
+		latest.Version = ver
+		v = &latest
+	}
+
+	logData, err := json.Marshal(v)
 	if err != nil {
-		return fmt.Errorf("error marshaling log struct: %w", err)
+		return 0, fmt.Errorf("error marshaling log struct: %w", err)
 	}
 
 	cfg := config.Get()
@@ -231,29 +385,96 @@ func sendLog(
 		bytes.NewReader(logData),
 	)
 	if err != nil {
-		return fmt.Errorf("error creating log request: %w", err)
+		return 0, fmt.Errorf("error creating log request: %w", err)
 	}
 
 	// Set auth header
 	sig, err := auth.SignBase64(logData, cfg.SecretKey)
 	if err != nil {
-		return fmt.Errorf("error signing log data: %w", err)
+		return 0, fmt.Errorf("error signing log data: %w", err)
 	}
 	req.Header.Set(http.HeaderAuthorization, sig)
 
 	resp, err := cfg.HttpClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("error making log request: %w", err)
+		return 0, fmt.Errorf("error making log request: %w", err)
 	}
 	if resp.StatusCode != gohttp.StatusOK {
 		msg, _ := ioutil.ReadAll(resp.Body)
-		return fmt.Errorf("unexpected status: [%v] %v",
+		return resp.StatusCode, fmt.Errorf("unexpected status: [%v] %v",
 			resp.StatusCode,
 			string(msg),
 		)
 	}
 
-	return nil
+	return resp.StatusCode, nil
+}
+
+// reportPinJob reports usage of a finished async pin job with the
+// signed request which enqueued it. A job not pinned reports ingress
+// only.
+func reportPinJob(
+	ctx context.Context,
+	j *handlers.PinJob,
+	pinned bool,
+	p2pIngr uint64,
+) error {
+	if config.Get().ExternalServices.Spaceport == "" {
+		return nil
+	}
+
+	source := logSourceLocal
+	if p2pIngr > 0 {
+		source = logSourceNetwork
+	}
+	status := gohttp.StatusOK
+	pinnedCount, pinnedBytes := 0, 0
+	if pinned {
+		pinnedCount, pinnedBytes = 1, int(j.TotalSize)
+	} else if j.State != handlers.PinJobDone {
+		status = gohttp.StatusInternalServerError
+	}
+	return sendLog(ctx, &LogV3{
+		LogV1: reporting.LogV1{
+			Req:         j.Req,
+			InProgress:  false,
+			PinnedCount: pinnedCount,
+			PinnedBytes: pinnedBytes,
+			Ingress:     int(p2pIngr),
+			At:          time.Now().Unix(),
+		},
+		Status:     status,
+		DurationMs: (j.UpdatedAt - j.CreatedAt) * 1000,
+		Root:       j.Cid,
+		Source:     source,
+		RequestID:  j.ID,
+	})
+}
+
+// reportPinExpiry reports an expired pin being unpinned with the signed
+// request which pinned it.
+func reportPinExpiry(
+	ctx context.Context,
+	e *handlers.PinExpiry,
+	stats *handlers.DagStats,
+) error {
+	if config.Get().ExternalServices.Spaceport == "" {
+		return nil
+	}
+
+	return sendLog(ctx, &LogV3{
+		LogV1: reporting.LogV1{
+			Req:         e.Req,
+			InProgress:  false,
+			PinnedCount: -1,
+			PinnedBytes: -int(stats.TotalSize.Load()),
+			At:          time.Now().Unix(),
+		},
+		Status:    gohttp.StatusOK,
+		Root:      e.Cid,
+		Source:    logSourceLocal,
+		RequestID: e.ID,
+	})
 }
 
 func extractSizeFromArgs(r *gohttp.Request) (int, error) {
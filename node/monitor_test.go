@@ -1,6 +1,7 @@
 package node
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -15,7 +16,6 @@ import (
 	"github.com/photon-storage/go-gw3/common/auth"
 	"github.com/photon-storage/go-gw3/common/crypto"
 	"github.com/photon-storage/go-gw3/common/http"
-	"github.com/photon-storage/go-gw3/common/reporting"
 
 	"github.com/photon-storage/falcon/node/config"
 	"github.com/photon-storage/falcon/node/handlers"
@@ -24,10 +24,15 @@ import (
 type mockHttpClient struct {
 	req  *gohttp.Request
 	resp *gohttp.Response
+	// Optional handler overriding resp.
+	handle func(req *gohttp.Request) *gohttp.Response
 }
 
 func (c *mockHttpClient) Do(req *gohttp.Request) (*gohttp.Response, error) {
 	c.req = req
+	if c.handle != nil {
+		return c.handle(req), nil
+	}
 	return c.resp, nil
 }
 
@@ -66,7 +71,7 @@ func TestSendLog(t *testing.T) {
 	maxSize := 1 << 20
 	httpIngr := newIngressCounter(nil, maxSize)
 	httpIngr.sz = 100
-	httpEgr := newEgressCounter(nil)
+	httpEgr := newEgressCounter(nil, maxSize)
 	httpEgr.sz = 8192
 	mon := newMonitor(
 		nil,
@@ -85,9 +90,9 @@ func TestSendLog(t *testing.T) {
 	logdata, err := ioutil.ReadAll(mockCli.req.Body)
 	require.NoError(t, err)
 
-	var log reporting.LogV1
+	var log LogV3
 	require.NoError(t, json.Unmarshal(logdata, &log))
-	require.Equal(t, 2, log.Version)
+	require.Equal(t, 3, log.Version)
 	require.Equal(t, gohttp.MethodGet, log.Req.Method)
 	require.Equal(t, "/api/v0/dag/get", log.Req.URI)
 	require.Equal(t, args.Encode(), log.Req.Args)
@@ -105,6 +110,8 @@ func TestSendLog(t *testing.T) {
 	require.Equal(t, 0, log.PinnedBytes)
 	require.Equal(t, 200, log.Ingress)
 	require.Equal(t, 8192, log.Egress)
+	require.Equal(t, logSourceNetwork, log.Source)
+	require.False(t, log.Flagged)
 
 	require.NoError(t, auth.VerifySigBase64(
 		string(logdata),
@@ -112,3 +119,59 @@ func TestSendLog(t *testing.T) {
 		sk1.GetPublic(),
 	))
 }
+
+func TestSendLogVersionFallback(t *testing.T) {
+	defer logVersionDowngraded.Store(false)
+
+	var versions []int
+	mockCli := &mockHttpClient{
+		handle: func(req *gohttp.Request) *gohttp.Response {
+			logdata, err := ioutil.ReadAll(req.Body)
+			require.NoError(t, err)
+			var log LogV3
+			require.NoError(t, json.Unmarshal(logdata, &log))
+			versions = append(versions, log.Version)
+
+			if log.Version > 2 {
+				require.Equal(t, "mock_id", log.RequestID)
+				require.Equal(t, gohttp.StatusOK, log.Status)
+				return &gohttp.Response{
+					StatusCode: gohttp.StatusBadRequest,
+					Body:       ioutil.NopCloser(bytes.NewReader(nil)),
+				}
+			}
+
+			// Legacy version does not carry the new fields.
+			require.Equal(t, "", log.RequestID)
+			require.Equal(t, 0, log.Status)
+			return &gohttp.Response{
+				StatusCode: gohttp.StatusOK,
+			}
+		},
+	}
+	cfg := &config.Config{
+		HttpClient: mockCli,
+		SecretKey:  crypto.PregenEd25519(1),
+	}
+	cfg.ExternalServices.Spaceport = "http://127.0.0.1:9981"
+	config.Mock(cfg)
+
+	l := &LogV3{
+		Status:    gohttp.StatusOK,
+		RequestID: "mock_id",
+	}
+	require.NoError(t, sendLog(context.Background(), l))
+	require.DeepEqual(t, []int{3, 2}, versions)
+
+	// Downgrade sticks for subsequent logs.
+	versions = nil
+	require.NoError(t, sendLog(context.Background(), l))
+	require.DeepEqual(t, []int{2}, versions)
+
+	// Version pinned in config is always used.
+	logVersionDowngraded.Store(false)
+	cfg.ExternalServices.SpaceportLogVersion = 2
+	versions = nil
+	require.NoError(t, sendLog(context.Background(), l))
+	require.DeepEqual(t, []int{2}, versions)
+}
@@ -0,0 +1,102 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ipfs/boxo/blockstore"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/kubo/core"
+
+	"github.com/photon-storage/go-common/log"
+
+	"github.com/photon-storage/falcon/node/com"
+	"github.com/photon-storage/falcon/node/config"
+	"github.com/photon-storage/falcon/node/handlers"
+)
+
+// migrateLegacyPins migrates pins left by stock Kubo at daemon start so
+// they are not collected by GC. It runs once unless disabled.
+func migrateLegacyPins(ctx context.Context, nd *core.IpfsNode) error {
+	cfg := config.Get().PinMigration
+	if cfg.Disable {
+		return nil
+	}
+
+	dstore := nd.Repo.Datastore()
+	pending, err := com.HasLegacyPins(ctx, dstore)
+	if err != nil {
+		return err
+	}
+	if !pending {
+		return nil
+	}
+
+	dir := cfg.BackupDir
+	if dir == "" {
+		dir = falconPath()
+	}
+	backup := filepath.Join(
+		dir,
+		fmt.Sprintf("pins-backup-%d.car", time.Now().Unix()),
+	)
+	log.Warn("Migrating legacy pins", "backup", backup)
+
+	res, err := MigrateLegacyPins(
+		ctx,
+		dstore,
+		nd.Blockstore,
+		com.GetRcPinner(nd.Pinning),
+		backup,
+	)
+	if err != nil {
+		return fmt.Errorf("error migrating legacy pins: %w", err)
+	}
+	log.Warn("Legacy pins migrated",
+		"recursive", res.Recursive,
+		"direct", res.Direct,
+		"skipped", res.Skipped,
+	)
+	return nil
+}
+
+// MigrateLegacyPins exports the rc pinner index to backup if set, then
+// converts the legacy pinset into rc counts. It is safe to run again.
+func MigrateLegacyPins(
+	ctx context.Context,
+	dstore datastore.Batching,
+	bstore blockstore.Blockstore,
+	pinner *com.WrappedPinner,
+	backup string,
+) (*com.PinMigrationResult, error) {
+	if backup != "" {
+		if err := writePinBackup(ctx, backup, pinner, bstore); err != nil {
+			return nil, fmt.Errorf("error writing pin backup: %w", err)
+		}
+	}
+	return com.MigrateLegacyPins(ctx, dstore, pinner)
+}
+
+func writePinBackup(
+	ctx context.Context,
+	path string,
+	pinner *com.WrappedPinner,
+	bstore blockstore.Blockstore,
+) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := handlers.ExportPins(ctx, f, pinner, bstore, false); err != nil {
+		return err
+	}
+	return f.Sync()
+}
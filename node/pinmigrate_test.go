@@ -0,0 +1,102 @@
+package node
+
+import (
+	"context"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	bs "github.com/ipfs/boxo/blockservice"
+	"github.com/ipfs/boxo/blockstore"
+	"github.com/ipfs/boxo/exchange/offline"
+	"github.com/ipfs/boxo/ipld/merkledag"
+	"github.com/ipfs/boxo/pinning/pinner/dspinner"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+
+	"github.com/photon-storage/go-common/testing/require"
+	rcpinner "github.com/photon-storage/go-rc-pinner"
+
+	"github.com/photon-storage/falcon/node/com"
+)
+
+func TestMigrateLegacyPins(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	bstore := blockstore.NewBlockstore(dstore)
+	dserv := merkledag.NewDAGService(bs.New(bstore, offline.Exchange(bstore)))
+
+	rndNode := func() *merkledag.ProtoNode {
+		nd := new(merkledag.ProtoNode)
+		nd.SetData(make([]byte, 32))
+		_, err := io.ReadFull(rand.Reader, nd.Data())
+		require.NoError(t, err)
+		return nd
+	}
+
+	// Legacy pins: A{B} recursive, C direct.
+	a := rndNode()
+	b := rndNode()
+	c := rndNode()
+	require.NoError(t, a.AddNodeLink("c0", b))
+	require.NoError(t, dserv.Add(ctx, b))
+	legacy, err := dspinner.New(ctx, dstore, dserv)
+	require.NoError(t, err)
+	require.NoError(t, legacy.Pin(ctx, a, true))
+	require.NoError(t, legacy.Pin(ctx, c, false))
+	require.NoError(t, legacy.Flush(ctx))
+
+	rcp, err := rcpinner.New(ctx, dstore, dserv)
+	require.NoError(t, err)
+	pinner := &com.WrappedPinner{
+		Pinner:    rcp,
+		Datastore: dstore,
+	}
+	count := func(nd *merkledag.ProtoNode, recursive bool) uint16 {
+		cnt, err := pinner.GetCount(ctx, nd.Cid(), recursive)
+		require.NoError(t, err)
+		return cnt
+	}
+
+	// A has been pinned by the rc pinner already.
+	require.NoError(t, pinner.Pin(ctx, a, true))
+	require.NoError(t, pinner.Pin(ctx, a, true))
+
+	pending, err := com.HasLegacyPins(ctx, dstore)
+	require.NoError(t, err)
+	require.True(t, pending)
+
+	backup := filepath.Join(t.TempDir(), "backup", "pins.car")
+	res, err := MigrateLegacyPins(ctx, dstore, bstore, pinner, backup)
+	require.NoError(t, err)
+	require.Equal(t, 1, res.Recursive)
+	require.Equal(t, 1, res.Direct)
+	require.Equal(t, 0, res.Skipped)
+	require.Equal(t, uint16(2), count(a, true))
+	require.Equal(t, uint16(1), count(c, false))
+	_, err = os.Stat(backup)
+	require.NoError(t, err)
+
+	pending, err = com.HasLegacyPins(ctx, dstore)
+	require.NoError(t, err)
+	require.False(t, pending)
+
+	// Pins unpinned after migration are not restored by a second run.
+	require.NoError(t, pinner.Unpin(ctx, c.Cid(), false))
+	res, err = MigrateLegacyPins(ctx, dstore, bstore, pinner, "")
+	require.NoError(t, err)
+	require.Equal(t, 0, res.Recursive)
+	require.Equal(t, 0, res.Direct)
+	require.Equal(t, 2, res.Skipped)
+	require.Equal(t, uint16(2), count(a, true))
+	require.Equal(t, uint16(0), count(c, false))
+
+	// Legacy pins are left untouched.
+	_, pinned, err := legacy.IsPinned(ctx, c.Cid())
+	require.NoError(t, err)
+	require.True(t, pinned)
+}
@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/ipfs/kubo/core"
+	"go.uber.org/atomic"
 
 	"github.com/photon-storage/falcon/node/config"
 	"github.com/photon-storage/go-common/log"
@@ -17,6 +18,11 @@ import (
 	"github.com/photon-storage/go-gw3/common/http"
 )
 
+var (
+	// Set after registration with Starbase succeeds.
+	registered = atomic.NewBool(false)
+)
+
 type Cert struct {
 	PrivateKey  string    `json:"priv_key"`
 	Certificate string    `json:"cert"`
@@ -147,6 +153,7 @@ func registerFalconNode(ctx context.Context, _ *core.IpfsNode) error {
 		}
 
 		if done {
+			registered.Store(true)
 			log.Info("Falcon node registration successful",
 				"host", cfg.Discovery.PublicHost,
 				"port", cfg.Discovery.PublicPort,
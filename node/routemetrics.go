@@ -0,0 +1,152 @@
+package node
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	hostTypePath      = "path"
+	hostTypeSubdomain = "subdomain"
+
+	// Label used for URIs not in the whitelist and uncommon methods to
+	// bound metric cardinality.
+	labelOther = "other"
+)
+
+var (
+	routeLabels       = []string{"uri", "host_type", "method"}
+	routeStatusLabels = []string{"uri", "host_type", "method", "code"}
+
+	requestDurationBuckets = []float64{
+		0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5,
+		1, 2.5, 5, 10, 30, 60, 300, 900, 3600,
+	}
+	responseSizeBuckets = prometheus.ExponentialBuckets(256, 4, 12)
+
+	routeDuration *prometheus.HistogramVec
+	routeRespSize *prometheus.HistogramVec
+	routeStatus   *prometheus.CounterVec
+)
+
+// registerRouteMetrics registers per-route metrics. The go-common metrics
+// package only supports constant labels, which does not fit histograms
+// labeled by route, so vectors are registered with prometheus directly.
+func registerRouteMetrics(namespace string) {
+	routeDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "request_duration_seconds",
+			Buckets:   requestDurationBuckets,
+		},
+		routeLabels,
+	)
+	routeRespSize = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "response_size_bytes",
+			Buckets:   responseSizeBuckets,
+		},
+		routeLabels,
+	)
+	routeStatus = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "request_status_total",
+		},
+		routeStatusLabels,
+	)
+}
+
+// routeRecorder is a wrapper for the underlying http.ResponseWriter and
+// records status code and response size for per-route metrics.
+type routeRecorder struct {
+	w          http.ResponseWriter
+	start      time.Time
+	statusCode int
+	sz         int
+}
+
+func newRouteRecorder(w http.ResponseWriter) *routeRecorder {
+	return &routeRecorder{
+		w:     w,
+		start: time.Now(),
+	}
+}
+
+func (r *routeRecorder) Header() http.Header {
+	return r.w.Header()
+}
+
+func (r *routeRecorder) Write(data []byte) (int, error) {
+	if r.statusCode == 0 {
+		r.statusCode = http.StatusOK
+	}
+	n, err := r.w.Write(data)
+	r.sz += n
+	return n, err
+}
+
+func (r *routeRecorder) WriteHeader(statusCode int) {
+	if r.statusCode == 0 {
+		r.statusCode = statusCode
+	}
+	r.w.WriteHeader(statusCode)
+}
+
+// Flush sends buffered data to the client for streaming responses.
+func (r *routeRecorder) Flush() {
+	if f, ok := r.w.(http.Flusher); ok {
+		if r.statusCode == 0 {
+			r.statusCode = http.StatusOK
+		}
+		f.Flush()
+	}
+}
+
+// observe records metrics for the finished request.
+func (r *routeRecorder) observe(uri string, subdomain bool, method string) {
+	if routeDuration == nil {
+		return
+	}
+
+	hostType := hostTypePath
+	if subdomain {
+		hostType = hostTypeSubdomain
+	}
+	method = methodLabel(method)
+
+	// Handlers which write nothing get the implicit 200 from net/http.
+	code := r.statusCode
+	if code == 0 {
+		code = http.StatusOK
+	}
+
+	routeDuration.WithLabelValues(uri, hostType, method).
+		Observe(time.Since(r.start).Seconds())
+	routeRespSize.WithLabelValues(uri, hostType, method).
+		Observe(float64(r.sz))
+	routeStatus.WithLabelValues(
+		uri,
+		hostType,
+		method,
+		strconv.Itoa(code),
+	).Inc()
+}
+
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet,
+		http.MethodHead,
+		http.MethodPost,
+		http.MethodPut,
+		http.MethodDelete,
+		http.MethodOptions:
+		return method
+	}
+	return labelOther
+}
@@ -0,0 +1,46 @@
+package node
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/photon-storage/go-common/testing/require"
+)
+
+func TestRouteRecorder(t *testing.T) {
+	// Vectors are registered once per process and reset between runs.
+	if routeStatus == nil {
+		registerRouteMetrics("test_falcon")
+	}
+	routeDuration.Reset()
+	routeRespSize.Reset()
+	routeStatus.Reset()
+
+	w := httptest.NewRecorder()
+	rec := newRouteRecorder(w)
+	rec.WriteHeader(http.StatusNotFound)
+	_, err := rec.Write([]byte("not found"))
+	require.NoError(t, err)
+	rec.observe("/ipfs", true, http.MethodGet)
+
+	rec = newRouteRecorder(httptest.NewRecorder())
+	rec.observe(labelOther, false, "PROPFIND")
+
+	require.Equal(t, float64(1), testutil.ToFloat64(routeStatus.WithLabelValues(
+		"/ipfs",
+		hostTypeSubdomain,
+		http.MethodGet,
+		"404",
+	)))
+	require.Equal(t, float64(1), testutil.ToFloat64(routeStatus.WithLabelValues(
+		labelOther,
+		hostTypePath,
+		labelOther,
+		"200",
+	)))
+	require.Equal(t, 2, testutil.CollectAndCount(routeDuration))
+	require.Equal(t, 2, testutil.CollectAndCount(routeRespSize))
+}
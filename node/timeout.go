@@ -1,19 +1,244 @@
 package node
 
-import "time"
+import (
+	"context"
+	"errors"
+	"fmt"
+	gohttp "net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/atomic"
+
+	"github.com/photon-storage/go-common/metrics"
+	"github.com/photon-storage/go-gw3/common/http"
+
+	"github.com/photon-storage/falcon/node/config"
+)
+
+const (
+	// Signed P3 arg overriding request timeout in seconds.
+	argP3Timeout = "arg-timeout"
+
+	abortReasonTimeout = "timeout"
+	abortReasonStalled = "stalled"
+
+	watchdogInterval = time.Second
+)
 
 var (
+	ErrRequestAborted = errors.New("request aborted")
+	ErrInvalidTimeout = errors.New("invalid timeout override")
+
 	uriTimeouts = map[string]time.Duration{
-		"/api/v0/pin/add": 3600 * time.Second,
+		"/api/v0/pin/add":           3600 * time.Second,
+		"/api/v0/pin/add/batch":     3600 * time.Second,
+		"/api/v0/pin/update":        3600 * time.Second,
+		"/api/v0/pin/verify":        3600 * time.Second,
+		"/api/v0/pin/export":        7200 * time.Second,
+		"/api/v0/pin/import":        7200 * time.Second,
+		"/api/v0/dag/import/stream": 3600 * time.Second,
 	}
-	defaultUriTimeout = 600 * time.Second
+	defaultUriTimeout  = 600 * time.Second
+	defaultMaxOverride = 7200 * time.Second
 )
 
+// getUriTimeout returns the timeout for the URI. Routes configured take
+// precedence over the built-in ones.
 func getUriTimeout(uri string) time.Duration {
+	cfg := config.Get().Timeouts
+	if to, ok := cfg.Routes[uri]; ok && to > 0 {
+		return to
+	}
+
 	to, ok := uriTimeouts[uri]
 	if ok {
 		return to
 	}
 
+	if cfg.Default > 0 {
+		return cfg.Default
+	}
 	return defaultUriTimeout
 }
+
+// requestTimeout returns the timeout for the request. A timeout signed
+// in P3 args overrides the URI timeout, bounded by the configured max.
+func requestTimeout(uri string, args *http.Args) (time.Duration, error) {
+	if args != nil {
+		if str := args.GetArg(argP3Timeout); str != "" {
+			secs, err := strconv.ParseInt(str, 10, 64)
+			if err != nil || secs <= 0 {
+				return 0, fmt.Errorf("%w: %v", ErrInvalidTimeout, str)
+			}
+
+			max := config.Get().Timeouts.MaxOverride
+			if max <= 0 {
+				max = defaultMaxOverride
+			}
+			to := time.Duration(secs) * time.Second
+			if to > max {
+				to = max
+			}
+			return to, nil
+		}
+	}
+
+	return getUriTimeout(uri), nil
+}
+
+// watchdog is a wrapper for the underlying http.ResponseWriter and cancels
+// the request on timeout, or when neither p2p ingress nor HTTP egress has
+// progressed for the stall interval. After abort, writes from the handler
+// are dropped and the abort status is sent on finish if no header has
+// been written.
+type watchdog struct {
+	w             gohttp.ResponseWriter
+	cancel        context.CancelFunc
+	p2pIngr       *atomic.Uint64
+	stallInterval time.Duration
+	interval      time.Duration
+	deadline      *atomic.Time
+	lastProgress  *atomic.Time
+	mu            sync.Mutex
+	headerWritten bool
+	reason        string
+}
+
+func newWatchdog(
+	w gohttp.ResponseWriter,
+	cancel context.CancelFunc,
+	p2pIngr *atomic.Uint64,
+	timeout time.Duration,
+) *watchdog {
+	now := time.Now()
+	return &watchdog{
+		w:             w,
+		cancel:        cancel,
+		p2pIngr:       p2pIngr,
+		stallInterval: config.Get().Timeouts.StallInterval,
+		interval:      watchdogInterval,
+		deadline:      atomic.NewTime(now.Add(timeout)),
+		lastProgress:  atomic.NewTime(now),
+	}
+}
+
+func (d *watchdog) Header() gohttp.Header {
+	return d.w.Header()
+}
+
+func (d *watchdog) Write(data []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.reason != "" {
+		return 0, ErrRequestAborted
+	}
+
+	d.headerWritten = true
+	n, err := d.w.Write(data)
+	if n > 0 {
+		d.lastProgress.Store(time.Now())
+	}
+	return n, err
+}
+
+func (d *watchdog) WriteHeader(statusCode int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.reason != "" || d.headerWritten {
+		return
+	}
+
+	d.headerWritten = true
+	d.w.WriteHeader(statusCode)
+}
+
+// Flush sends buffered data to the client unless the request is aborted.
+// A flush counts as a written header.
+func (d *watchdog) Flush() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.reason != "" {
+		return
+	}
+	if f, ok := d.w.(gohttp.Flusher); ok {
+		d.headerWritten = true
+		f.Flush()
+	}
+}
+
+// setTimeout resets the request deadline relative to now.
+func (d *watchdog) setTimeout(timeout time.Duration) {
+	d.deadline.Store(time.Now().Add(timeout))
+}
+
+func (d *watchdog) run(ctx context.Context) {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	ingr := d.p2pIngr.Load()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case now := <-ticker.C:
+			if v := d.p2pIngr.Load(); v != ingr {
+				ingr = v
+				d.lastProgress.Store(now)
+			}
+
+			if now.After(d.deadline.Load()) {
+				d.abort(abortReasonTimeout)
+				return
+			}
+			if d.stallInterval > 0 &&
+				now.Sub(d.lastProgress.Load()) > d.stallInterval {
+				d.abort(abortReasonStalled)
+				return
+			}
+		}
+	}
+}
+
+// abort cancels the request. The status is not written here as the
+// handler may still be manipulating response headers.
+func (d *watchdog) abort(reason string) {
+	d.mu.Lock()
+	d.reason = reason
+	d.mu.Unlock()
+
+	d.cancel()
+	metrics.CounterInc(fmt.Sprintf("request_%v_total", reason))
+}
+
+// finish must be called after the handler returns. It sends the abort
+// status if the request was aborted before responding.
+func (d *watchdog) finish() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.reason == "" || d.headerWritten {
+		return
+	}
+
+	code := gohttp.StatusGatewayTimeout
+	if d.reason == abortReasonStalled {
+		code = gohttp.StatusRequestTimeout
+	}
+	d.headerWritten = true
+	header := d.w.Header()
+	delete(header, "Content-Length")
+	delete(header, "Content-Encoding")
+	gohttp.Error(d.w, gohttp.StatusText(code), code)
+}
+
+func (d *watchdog) abortReason() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.reason
+}
@@ -0,0 +1,125 @@
+package node
+
+import (
+	"context"
+	gohttp "net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.uber.org/atomic"
+
+	"github.com/photon-storage/go-common/testing/require"
+	"github.com/photon-storage/go-gw3/common/http"
+
+	"github.com/photon-storage/falcon/node/config"
+)
+
+func TestRequestTimeout(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Timeouts.Routes = map[string]time.Duration{
+		"/ipfs": time.Minute,
+	}
+	cfg.Timeouts.MaxOverride = time.Hour
+	config.Mock(cfg)
+
+	require.Equal(t, time.Minute, getUriTimeout("/ipfs"))
+	require.Equal(t, 3600*time.Second, getUriTimeout("/api/v0/pin/add"))
+	require.Equal(t, defaultUriTimeout, getUriTimeout("/ipns"))
+	cfg.Timeouts.Default = 10 * time.Second
+	require.Equal(t, 10*time.Second, getUriTimeout("/ipns"))
+
+	to, err := requestTimeout("/ipfs", nil)
+	require.NoError(t, err)
+	require.Equal(t, time.Minute, to)
+
+	to, err = requestTimeout("/ipfs", http.NewArgs().SetArg(argP3Timeout, "120"))
+	require.NoError(t, err)
+	require.Equal(t, 2*time.Minute, to)
+
+	to, err = requestTimeout("/ipfs", http.NewArgs().SetArg(argP3Timeout, "7200"))
+	require.NoError(t, err)
+	require.Equal(t, time.Hour, to)
+
+	_, err = requestTimeout("/ipfs", http.NewArgs().SetArg(argP3Timeout, "-1"))
+	require.ErrorIs(t, ErrInvalidTimeout, err)
+}
+
+func TestWatchdog(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Timeouts.StallInterval = 50 * time.Millisecond
+	config.Mock(cfg)
+
+	newTestWatchdog := func(
+		w gohttp.ResponseWriter,
+		p2pIngr *atomic.Uint64,
+		timeout time.Duration,
+	) (context.Context, *watchdog) {
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+		wd := newWatchdog(w, cancel, p2pIngr, timeout)
+		wd.interval = 5 * time.Millisecond
+		go wd.run(ctx)
+		return ctx, wd
+	}
+
+	cases := []struct {
+		name string
+		run  func(t *testing.T)
+	}{
+		{
+			name: "timeout",
+			run: func(t *testing.T) {
+				w := httptest.NewRecorder()
+				ctx, wd := newTestWatchdog(w, atomic.NewUint64(0), time.Hour)
+				wd.setTimeout(20 * time.Millisecond)
+				// Keep egress progressing so the request does not stall.
+				for ctx.Err() == nil {
+					time.Sleep(5 * time.Millisecond)
+					wd.Write([]byte{0})
+				}
+				_, err := wd.Write([]byte{0})
+				require.ErrorIs(t, ErrRequestAborted, err)
+				wd.finish()
+
+				require.Equal(t, abortReasonTimeout, wd.abortReason())
+				require.Equal(t, gohttp.StatusOK, w.Code)
+			},
+		},
+		{
+			name: "stalled",
+			run: func(t *testing.T) {
+				w := httptest.NewRecorder()
+				w.Header().Set("Content-Length", "100")
+				ctx, wd := newTestWatchdog(w, atomic.NewUint64(0), time.Hour)
+				<-ctx.Done()
+				wd.WriteHeader(gohttp.StatusInternalServerError)
+				wd.finish()
+
+				require.Equal(t, abortReasonStalled, wd.abortReason())
+				require.Equal(t, gohttp.StatusRequestTimeout, w.Code)
+				require.Equal(t, "", w.Header().Get("Content-Length"))
+			},
+		},
+		{
+			name: "p2p progress",
+			run: func(t *testing.T) {
+				w := httptest.NewRecorder()
+				p2pIngr := atomic.NewUint64(0)
+				ctx, wd := newTestWatchdog(w, p2pIngr, 200*time.Millisecond)
+				for ctx.Err() == nil {
+					time.Sleep(5 * time.Millisecond)
+					p2pIngr.Inc()
+				}
+				wd.finish()
+
+				require.Equal(t, abortReasonTimeout, wd.abortReason())
+				require.Equal(t, gohttp.StatusGatewayTimeout, w.Code)
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, c.run)
+	}
+}
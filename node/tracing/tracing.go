@@ -0,0 +1,173 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	version "github.com/ipfs/kubo"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/photon-storage/falcon/node/config"
+)
+
+const (
+	tracerName = "Falcon"
+
+	ExporterOtlp = "otlp"
+	ExporterFile = "file"
+
+	protocolGrpc = "grpc"
+	protocolHttp = "http/protobuf"
+)
+
+// Init installs a global tracer provider exporting spans as configured in
+// falcon config. Nothing is installed if no exporter is configured, which
+// leaves the provider set up by Kubo from OTEL_* environment variables.
+// The returned function flushes and shuts down the provider, then closes
+// the trace file if exporting to one.
+func Init(ctx context.Context) (func(context.Context) error, error) {
+	cfg := config.Get().Tracing
+	if cfg.Exporter == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, closer, err := newExporter(ctx)
+	if err != nil {
+		return nil, err
+	}
+	closeOutput := func() error {
+		if closer == nil {
+			return nil
+		}
+		return closer.Close()
+	}
+
+	res, err := resource.Merge(
+		resource.Default(),
+		resource.NewSchemaless(
+			semconv.ServiceNameKey.String(tracerName),
+			semconv.ServiceVersionKey.String(version.CurrentVersionNumber),
+		),
+	)
+	if err != nil {
+		closeOutput()
+		return nil, err
+	}
+
+	sampler := sdktrace.AlwaysSample()
+	if cfg.SampleRatio > 0 && cfg.SampleRatio < 1 {
+		sampler = sdktrace.TraceIDRatioBased(cfg.SampleRatio)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sampler)),
+	)
+	otel.SetTracerProvider(tp)
+
+	return func(ctx context.Context) error {
+		err := tp.Shutdown(ctx)
+		if cerr := closeOutput(); err == nil {
+			err = cerr
+		}
+		return err
+	}, nil
+}
+
+// newExporter creates the configured span exporter. The returned closer
+// releases the exporter output if any and is called after the provider
+// shuts down.
+func newExporter(
+	ctx context.Context,
+) (sdktrace.SpanExporter, io.Closer, error) {
+	cfg := config.Get().Tracing
+	switch cfg.Exporter {
+	case ExporterOtlp:
+		switch cfg.OtlpProtocol {
+		case protocolGrpc:
+			opts := []otlptracegrpc.Option{}
+			if cfg.OtlpEndpoint != "" {
+				opts = append(opts, otlptracegrpc.WithEndpoint(cfg.OtlpEndpoint))
+			}
+			if cfg.OtlpInsecure {
+				opts = append(opts, otlptracegrpc.WithInsecure())
+			}
+			exporter, err := otlptracegrpc.New(ctx, opts...)
+			return exporter, nil, err
+
+		case protocolHttp, "":
+			opts := []otlptracehttp.Option{}
+			if cfg.OtlpEndpoint != "" {
+				opts = append(opts, otlptracehttp.WithEndpoint(cfg.OtlpEndpoint))
+			}
+			if cfg.OtlpInsecure {
+				opts = append(opts, otlptracehttp.WithInsecure())
+			}
+			exporter, err := otlptracehttp.New(ctx, opts...)
+			return exporter, nil, err
+
+		default:
+			return nil, nil, fmt.Errorf(
+				"unsupported OTLP protocol: %v",
+				cfg.OtlpProtocol,
+			)
+		}
+
+	case ExporterFile:
+		if cfg.FilePath == "" {
+			return nil, nil, fmt.Errorf("trace file path is missing")
+		}
+		f, err := os.OpenFile(
+			cfg.FilePath,
+			os.O_CREATE|os.O_WRONLY|os.O_APPEND,
+			0644,
+		)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error opening trace file: %w", err)
+		}
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		return exporter, f, nil
+
+	default:
+		return nil, nil, fmt.Errorf("unsupported trace exporter: %v", cfg.Exporter)
+	}
+}
+
+// Span starts a new span named <component>.<name>, following the Kubo
+// span naming convention.
+func Span(
+	ctx context.Context,
+	component string,
+	name string,
+	opts ...trace.SpanStartOption,
+) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(
+		ctx,
+		fmt.Sprintf("%s.%s", component, name),
+		opts...,
+	)
+}
+
+// EndSpan records err on span if non-nil and ends the span.
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}